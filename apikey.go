@@ -0,0 +1,56 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"strings"
+)
+
+// bearerToken returns the token of the request's Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// APIKeyMiddleware authenticates requests that present an API key in
+// their Authorization header:
+//
+//   Authorization: Bearer gak_<id>.<secret>
+//
+// The Key and its User are available to h through apikey.Current and
+// apikey.User. Requests with an invalid or expired key are rejected
+// with a 401. Requests without an API key, including those with other
+// bearer tokens, are passed to h unchanged, e.g. to use the cookie
+// session.
+//
+//   http.Handle("/api/", auth.APIKeyMiddleware(api))
+//
+func APIKeyMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || !strings.HasPrefix(token, apikey.Prefix) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		k, err := apikey.Authenticate(r, token)
+		var u *user.User
+		if err == nil {
+			u, err = profile.DefaultUserStore.Get(r, k.UserID)
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSON(w, http.StatusUnauthorized, &TokenReply{Error: "invalid_token"})
+			return
+		}
+		h.ServeHTTP(w, apikey.NewRequest(r, k, u))
+	})
+}
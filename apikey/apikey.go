@@ -0,0 +1,298 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/apikey provides personal access tokens that let scripts and
+integrations act as a User without a browser session.
+
+A logged in User creates named, scoped and optionally expiring Keys
+through Service. The token is only returned when the Key is created;
+only its hash is saved. Requests present the token in the Authorization
+header:
+
+  Authorization: Bearer gak_<id>.<secret>
+
+and are authenticated by auth.APIKeyMiddleware. Handlers for a scope are
+wrapped in auth.RequireScope.
+*/
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchKey   = errors.New("auth/apikey: no such key")
+	ErrInvalidKey  = errors.New("auth/apikey: invalid key")
+	ErrExpired     = errors.New("auth/apikey: key expired")
+	ErrMissingName = errors.New("auth/apikey: a name is required")
+	ErrInvalidTTL  = errors.New("auth/apikey: the lifetime can't be negative")
+	ErrNoStore     = errors.New("auth/apikey: no Store is set, import github.com/gaego/auth/appengine or set DefaultStore")
+)
+
+// Prefix starts every token, so that leaked tokens are easy to find in
+// code and logs.
+const Prefix = "gak_"
+
+// LastUsedInterval is the minimum time between two updates of a Key's
+// LastUsed time, so that each request doesn't write to the Store.
+var LastUsedInterval = 5 * time.Minute
+
+// Key is a personal access token of a User.
+type Key struct {
+	ID     string `datastore:"-" json:"id"`
+	UserID string `json:"-"`
+	Name   string `json:"name"`
+	// Scopes limit the Key to the handlers of auth.RequireScope for
+	// them, e.g. "repo". A Key without Scopes has every scope.
+	Scopes []string `json:"scopes"`
+	// Hash is the SHA-256 hash of the token's secret. Unlike passwords
+	// the secrets are random, so a slow hash is not needed.
+	Hash    []byte    `datastore:",noindex" json:"-"`
+	Created time.Time `json:"created"`
+	// Expires is zero for Keys that don't expire.
+	Expires  time.Time `json:"expires,omitempty"`
+	LastUsed time.Time `json:"lastUsed,omitempty"`
+}
+
+// HasScope reports whether the Key was granted scope.
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the Key has expired at now.
+func (k *Key) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// Store is implemented by the types that persist Keys.
+type Store interface {
+	// Get returns the Key with the ID, or ErrNoSuchKey.
+	Get(r *http.Request, id string) (*Key, error)
+	Put(r *http.Request, k *Key) error
+	Delete(r *http.Request, id string) error
+	// List returns the Keys of the User.
+	List(r *http.Request, userID string) ([]*Key, error)
+}
+
+// DefaultStore is the Store used by the apikey functions. Until it is
+// set, e.g. by importing auth/apikey/datastore, it returns ErrNoStore.
+var DefaultStore Store = noStore{}
+
+// noStore is the DefaultStore until one is set.
+type noStore struct{}
+
+func (noStore) Get(r *http.Request, id string) (*Key, error) {
+	return nil, ErrNoStore
+}
+
+func (noStore) Put(r *http.Request, k *Key) error {
+	return ErrNoStore
+}
+
+func (noStore) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+func (noStore) List(r *http.Request, userID string) ([]*Key, error) {
+	return nil, ErrNoStore
+}
+
+// random returns n random bytes encoded as base64.
+func random(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth/apikey: unable to generate a key: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hash(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// Create creates a Key for the User and returns it with its token. A
+// zero ttl creates a Key that doesn't expire; a negative one returns
+// ErrInvalidTTL.
+func Create(r *http.Request, userID, name string, scopes []string, ttl time.Duration) (
+	*Key, string, error) {
+
+	if name == "" {
+		return nil, "", ErrMissingName
+	}
+	if ttl < 0 {
+		return nil, "", ErrInvalidTTL
+	}
+	id := random(12)
+	secret := random(32)
+	now := time.Now()
+	k := &Key{
+		ID:      id,
+		UserID:  userID,
+		Name:    name,
+		Scopes:  scopes,
+		Hash:    hash(secret),
+		Created: now,
+	}
+	if ttl > 0 {
+		k.Expires = now.Add(ttl)
+	}
+	if err := DefaultStore.Put(r, k); err != nil {
+		return nil, "", err
+	}
+	return k, Prefix + id + "." + secret, nil
+}
+
+// Authenticate returns the Key of the token and updates its LastUsed
+// time.
+func Authenticate(r *http.Request, token string) (*Key, error) {
+	if !strings.HasPrefix(token, Prefix) {
+		return nil, ErrInvalidKey
+	}
+	p := strings.SplitN(token[len(Prefix):], ".", 2)
+	if len(p) != 2 {
+		return nil, ErrInvalidKey
+	}
+	k, err := DefaultStore.Get(r, p[0])
+	if err == ErrNoSuchKey {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hash(p[1]), k.Hash) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if k.Expired(now) {
+		return nil, ErrExpired
+	}
+	if now.Sub(k.LastUsed) > LastUsedInterval {
+		k.LastUsed = now
+		DefaultStore.Put(r, k)
+	}
+	return k, nil
+}
+
+// List returns the Keys of the User, newest first.
+func List(r *http.Request, userID string) ([]*Key, error) {
+	ks, err := DefaultStore.List(r, userID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byCreated(ks))
+	return ks, nil
+}
+
+// Revoke deletes the User's Key with the ID.
+func Revoke(r *http.Request, userID, id string) error {
+	k, err := DefaultStore.Get(r, id)
+	if err != nil {
+		return err
+	}
+	if k.UserID != userID {
+		return ErrNoSuchKey
+	}
+	return DefaultStore.Delete(r, id)
+}
+
+type byCreated []*Key
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+type contextKey int
+
+const (
+	keyKey contextKey = iota
+	userKey
+)
+
+// NewRequest returns a copy of r authenticated with the Key as the
+// User.
+func NewRequest(r *http.Request, k *Key, u *user.User) *http.Request {
+	ctx := context.WithValue(r.Context(), keyKey, k)
+	ctx = context.WithValue(ctx, userKey, u)
+	return r.WithContext(ctx)
+}
+
+// Current returns the Key that authenticated the request, or nil.
+func Current(r *http.Request) *Key {
+	k, _ := r.Context().Value(keyKey).(*Key)
+	return k
+}
+
+// User returns the User authenticated by the request's Key, or nil.
+func User(r *http.Request) *user.User {
+	u, _ := r.Context().Value(userKey).(*user.User)
+	return u
+}
+
+// MemoryStore keeps Keys in memory. It is intended for tests and
+// development servers.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+func (s *MemoryStore) Get(r *http.Request, id string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return &k, nil
+}
+
+func (s *MemoryStore) Put(r *http.Request, k *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[tenant.Key(r, k.ID)] = *k
+	return nil
+}
+
+func (s *MemoryStore) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, tenant.Key(r, id))
+	return nil
+}
+
+func (s *MemoryStore) List(r *http.Request, userID string) ([]*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ks []*Key
+	for id, k := range s.keys {
+		if k.UserID == userID && tenant.InNamespace(r, id) {
+			k := k
+			ks = append(ks, &k)
+		}
+	}
+	return ks, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apikey
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	defer func(s Store) { DefaultStore = s }(DefaultStore)
+	DefaultStore = NewMemoryStore()
+	r, _ := http.NewRequest("GET", "http://localhost:8080/api", nil)
+
+	k, token, err := Create(r, "1", "deploy", []string{"read"}, 0)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if !strings.HasPrefix(token, Prefix+k.ID+".") {
+		t.Errorf(`token: %v, want %v<id>.<secret>`, token, Prefix)
+	}
+	if _, _, err = Create(r, "1", "", nil, 0); err != ErrMissingName {
+		t.Errorf(`err: %v, want %v`, err, ErrMissingName)
+	}
+	if _, _, err = Create(r, "1", "CI", nil, -time.Second); err != ErrInvalidTTL {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidTTL)
+	}
+
+	k2, err := Authenticate(r, token)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if k2.UserID != "1" || !k2.HasScope("read") || k2.HasScope("write") {
+		t.Errorf(`k2: %v, want user 1 with scope read`, k2)
+	}
+	if k3, _ := DefaultStore.Get(r, k.ID); k3.LastUsed.IsZero() {
+		t.Errorf(`LastUsed: zero, want the time of use`)
+	}
+
+	// Invalid tokens.
+
+	for _, tok := range []string{"", "gak_", k.ID, Prefix + k.ID + ".wrong", Prefix + "x." + "y"} {
+		if _, err = Authenticate(r, tok); err != ErrInvalidKey {
+			t.Errorf(`%q: err: %v, want %v`, tok, err, ErrInvalidKey)
+		}
+	}
+
+	// Expired.
+
+	_, expired, _ := Create(r, "1", "old", nil, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err = Authenticate(r, expired); err != ErrExpired {
+		t.Errorf(`err: %v, want %v`, err, ErrExpired)
+	}
+
+	// List and Revoke.
+
+	ks, _ := List(r, "1")
+	if len(ks) != 2 || ks[0].Name != "old" {
+		t.Errorf(`ks: %v, want old and deploy`, ks)
+	}
+	if err = Revoke(r, "2", k.ID); err != ErrNoSuchKey {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchKey)
+	}
+	if err = Revoke(r, "1", k.ID); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if _, err = Authenticate(r, token); err != ErrInvalidKey {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidKey)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/apikey/datastore saves the Keys of auth/apikey to the App
+Engine datastore, as "AuthAPIKey" entities. It is imported for its side
+effect:
+
+  import _ "github.com/gaego/auth/apikey/datastore"
+*/
+package datastore
+
+import (
+	"appengine/datastore"
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/namespace"
+	"net/http"
+)
+
+func init() {
+	apikey.DefaultStore = Store{}
+}
+
+// Store saves Keys to the App Engine datastore as "AuthAPIKey"
+// entities.
+type Store struct{}
+
+func (Store) Get(r *http.Request, id string) (*apikey.Key, error) {
+	c := namespace.NewContext(r)
+	k := new(apikey.Key)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthAPIKey", id, 0, nil), k)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, apikey.ErrNoSuchKey
+	}
+	k.ID = id
+	return k, err
+}
+
+func (Store) Put(r *http.Request, k *apikey.Key) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthAPIKey", k.ID, 0, nil), k)
+	return err
+}
+
+func (Store) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthAPIKey", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Store) List(r *http.Request, userID string) ([]*apikey.Key, error) {
+	c := namespace.NewContext(r)
+	var ks []*apikey.Key
+	keys, err := datastore.NewQuery("AuthAPIKey").
+		Filter("UserID =", userID).GetAll(c, &ks)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		ks[i].ID = k.StringID()
+	}
+	return ks, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apikey
+
+import (
+	"github.com/gaego/auth/session"
+	"net/http"
+	"time"
+)
+
+type Service struct{}
+
+type Args struct {
+	ID     string
+	Name   string
+	Scopes []string
+	// ExpiresIn is the lifetime of a new Key in seconds. Zero creates a
+	// Key that doesn't expire; negative values are rejected.
+	ExpiresIn int64
+}
+
+type Reply struct {
+	Key *Key
+	// Token is only returned when the Key is created.
+	Token string `json:",omitempty"`
+	Keys  []*Key
+}
+
+// Create creates a Key for the current User. The token in the reply
+// can not be retrieved later.
+func (s *Service) Create(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(args.ExpiresIn) * time.Second
+	reply.Key, reply.Token, err = Create(r, id, args.Name, args.Scopes, ttl)
+	return err
+}
+
+// List returns the current User's Keys, newest first.
+func (s *Service) List(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	reply.Keys, err = List(r, id)
+	return err
+}
+
+// Revoke deletes the current User's Key with args.ID.
+func (s *Service) Revoke(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	return Revoke(r, id, args.ID)
+}
@@ -0,0 +1,31 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/appengine runs the auth packages on App Engine. It is
+imported for its side effect:
+
+  import _ "github.com/gaego/auth/appengine"
+
+It saves the Profiles, Users, sessions, API keys, signing keys, refresh
+tokens, audit events, OAuth 1.0a request tokens and the OpenID Connect
+clients, codes and consents to the datastore, logs to the App Engine log
+and makes providers use the URL Fetch service.
+
+Without it the auth packages don't import the App Engine SDK, and their
+default stores return errors until they are set.
+*/
+package appengine
+
+import (
+	_ "github.com/gaego/auth/apikey/datastore"
+	_ "github.com/gaego/auth/audit/datastore"
+	_ "github.com/gaego/auth/fetch/urlfetch"
+	_ "github.com/gaego/auth/namespace"
+	_ "github.com/gaego/auth/oauth1/datastore"
+	_ "github.com/gaego/auth/oidc/datastore"
+	_ "github.com/gaego/auth/profile/datastore"
+	_ "github.com/gaego/auth/session/datastore"
+	_ "github.com/gaego/auth/token/datastore"
+)
@@ -28,6 +28,12 @@ func New() *Provider {
 	}
 }
 
+// Describe returns the Name and URL of the Provider, for the login page
+// of auth.
+func (p *Provider) Describe() (name, url string) {
+	return p.Name, p.URL
+}
+
 // Authenticate process the request and returns a populated UserProfile.
 // If the Authenticate method can not authenticate the User based on the
 // request, an error or a redirect URL wll be return.
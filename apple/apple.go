@@ -0,0 +1,221 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/apple provides Sign in with Apple authentication
+
+Apple posts the callback to /-/auth/apple/callback as a form
+(response_mode=form_post). The client secret is a short-lived JWT signed
+with the .p8 key downloaded from the Apple developer account:
+
+	key, err := jwt.ParseECPrivateKey(p8)
+	applePro := apple.New("com.example.web", "TEAMID", "KEYID", key, "name email")
+	auth.Register("apple", applePro)
+
+Apple only sends the User's name on the first authorization. It is saved
+to the Profile's Person and kept on subsequent logins.
+*/
+package apple
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ISSUER   = "https://appleid.apple.com"
+	KEYS_URL = "https://appleid.apple.com/auth/keys"
+)
+
+var (
+	ErrMissingIDToken = errors.New("auth/apple: the token response did not include an id_token")
+)
+
+// ClientSecretExpiration is the lifetime of the generated client secret.
+// Apple allows at most six months.
+var ClientSecretExpiration = 5 * time.Minute
+
+// keys is the cache of Apple's published signing keys.
+var keys = jwt.NewRemoteKeySet(KEYS_URL)
+
+type Provider struct {
+	oauth2.Provider
+	// TeamID is the Apple developer team ID.
+	TeamID string
+	// KeyID is the ID of the Sign in with Apple private key.
+	KeyID string
+	// Key is the private key used to sign the client secret.
+	Key *ecdsa.PrivateKey
+}
+
+// New creates a new Apple provider. clientID is the Services ID
+// configured for the web application.
+func New(clientID, teamID, keyID string, key *ecdsa.PrivateKey, scope string) *Provider {
+	return &Provider{
+		Provider: oauth2.Provider{
+			Name:     "Apple",
+			URL:      "https://appleid.apple.com",
+			ClientID: clientID,
+			Scope:    scope,
+			AuthURL:  "https://appleid.apple.com/auth/authorize",
+			TokenURL: "https://appleid.apple.com/auth/token",
+			// Apple requires a form post when the name or email is
+			// requested.
+			FormPost: true,
+		},
+		TeamID: teamID,
+		KeyID:  keyID,
+		Key:    key,
+	}
+}
+
+// ClientSecret generates the signed JWT Apple expects as the client
+// secret.
+func (p *Provider) ClientSecret(now time.Time) (string, error) {
+	cl := &jwt.StandardClaims{
+		Issuer:    p.TeamID,
+		Subject:   p.ClientID,
+		Audience:  jwt.Audience{ISSUER},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ClientSecretExpiration).Unix(),
+	}
+	return jwt.Sign(cl, p.KeyID, p.Key)
+}
+
+// Claims represents the claims of an Apple ID token.
+type Claims struct {
+	jwt.StandardClaims
+	Email string `json:"email"`
+	// EmailVerified and IsPrivateEmail are sent as either a boolean or
+	// the string "true".
+	EmailVerified  interface{} `json:"email_verified"`
+	IsPrivateEmail interface{} `json:"is_private_email"`
+}
+
+// Verified reports whether the email address was verified by Apple.
+func (cl *Claims) Verified() bool {
+	return cl.EmailVerified == true || cl.EmailVerified == "true"
+}
+
+// User is the JSON object Apple posts in the "user" form value on the
+// first authorization only.
+type User struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// VerifyIDToken parses raw and checks its signature against Apple's
+// published keys and its issuer, audience and expiry.
+func VerifyIDToken(client *http.Client, raw, clientID string) (*jwt.Token, *Claims, error) {
+	tok, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = keys.Verify(client, tok); err != nil {
+		return nil, nil, err
+	}
+	cl := new(Claims)
+	if err = tok.Claims(cl); err != nil {
+		return nil, nil, err
+	}
+	if err = cl.Validate(ISSUER, clientID, time.Now()); err != nil {
+		return nil, nil, err
+	}
+	return tok, cl, nil
+}
+
+// CrossSiteCallback reports that Apple POSTs the callback from its own
+// site, so the session cookie must be sent with SameSite=None.
+func (p *Provider) CrossSiteCallback() bool {
+	return true
+}
+
+// Authenticate process the request and returns a populated Profile.
+// If the Authenticate method can not authenticate the User based on the
+// request, an error or a redirect URL wll be return.
+func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
+	up *profile.Profile, redirectURL string, err error) {
+
+	if !oauth2.IsCallback(r) {
+		return nil, p.Start(w, r), nil
+	}
+	secret, err := p.ClientSecret(time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	// Copy the provider so that concurrent requests do not share the
+	// generated secret.
+	op := p.Provider
+	op.ClientSecret = secret
+	t, err := op.Callback(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := t.Extra["id_token"]
+	if raw == "" {
+		return nil, "", ErrMissingIDToken
+	}
+	tok, cl, err := VerifyIDToken(p.Client(r), raw, p.ClientID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = p.CheckEmail(cl.Email, cl.Verified()); err != nil {
+		return nil, "", err
+	}
+	up = profile.New(p.Name, p.URL)
+	up.ID = cl.Subject
+	up.PersonRawJSON = tok.Payload
+	up.Person = decodePerson(r, up, cl)
+	return up, "", nil
+}
+
+// decodePerson builds the Person from the "user" form value when Apple sent
+// it, otherwise the previously saved Person is used.
+func decodePerson(r *http.Request, up *profile.Profile, cl *Claims) *person.Person {
+	var per *person.Person
+	if v := r.FormValue("user"); v != "" {
+		u := new(User)
+		if err := json.Unmarshal([]byte(v), u); err == nil {
+			per = u.Person()
+		}
+	}
+	if per == nil {
+		if ep, err := profile.DefaultStore.Get(r, up.AuthID()); err == nil && ep.Person != nil {
+			per = ep.Person
+		} else {
+			per = new(person.Person)
+		}
+	}
+	// The email in the ID token is always current.
+	if cl.Email != "" {
+		per.Email = cl.Email
+		per.Emails = []*person.PersonEmails{
+			&person.PersonEmails{Primary: true, Type: "account", Value: cl.Email},
+		}
+	}
+	return per
+}
+
+// Person converts the User to a Person.
+func (u *User) Person() *person.Person {
+	return &person.Person{
+		DisplayName: strings.TrimSpace(u.Name.FirstName + " " + u.Name.LastName),
+		Email:       u.Email,
+		Name: &person.PersonName{
+			GivenName:  u.Name.FirstName,
+			FamilyName: u.Name.LastName,
+		},
+	}
+}
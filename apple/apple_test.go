@@ -0,0 +1,127 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tearDown() {
+	context.Close()
+}
+
+func TestClientSecret(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p := New("com.example.web", "TEAMID", "KEYID", key, "name email")
+	now := time.Now()
+	raw, err := p.ClientSecret(now)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	tok, err := jwt.Parse(raw)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if err = tok.Verify(&key.PublicKey); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if x := tok.Header.Algorithm; x != "ES256" {
+		t.Errorf(`tok.Header.Algorithm: %v, want "ES256"`, x)
+	}
+	if x := tok.Header.KeyID; x != "KEYID" {
+		t.Errorf(`tok.Header.KeyID: %v, want "KEYID"`, x)
+	}
+	cl := new(jwt.StandardClaims)
+	tok.Claims(cl)
+	if err = cl.Validate("TEAMID", ISSUER, now); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if x := cl.Subject; x != "com.example.web" {
+		t.Errorf(`cl.Subject: %v, want "com.example.web"`, x)
+	}
+}
+
+func TestAuthenticate_FormPost(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p := New("com.example.web", "TEAMID", "KEYID", key, "name email")
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/apple", nil)
+	w := httptest.NewRecorder()
+	_, x, err := p.Authenticate(w, r)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	u, _ := url.Parse(x)
+	if m := u.Query().Get("response_mode"); m != "form_post" {
+		t.Errorf(`response_mode: %v, want "form_post"`, m)
+	}
+	cs := w.Result().Cookies()
+	if len(cs) != 1 || cs[0].Value != u.Query().Get("state") {
+		t.Fatalf(`cookies: %v, want the state`, cs)
+	}
+	if cs[0].SameSite != http.SameSiteNoneMode || !cs[0].Secure {
+		t.Errorf(`cookie: %v, want SameSite=None and Secure`, cs[0])
+	}
+	// A callback posted without the state cookie, e.g. from an attacker's
+	// page, is rejected.
+	r, _ = http.NewRequest("POST", "http://localhost:8080/-/auth/apple/callback",
+		strings.NewReader(url.Values{"state": {cs[0].Value}, "code": {"abc"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, _, err = p.Authenticate(httptest.NewRecorder(), r); err != oauth2.ErrInvalidState {
+		t.Errorf(`err: %v, want %v`, err, oauth2.ErrInvalidState)
+	}
+}
+
+func TestDecodePerson(t *testing.T) {
+	defer func(s profile.Store) { profile.DefaultStore = s }(profile.DefaultStore)
+	profile.DefaultStore = profile.NewMemoryStore()
+
+	cl := &Claims{Email: "abc@privaterelay.appleid.com"}
+	cl.Subject = "001.abc"
+
+	// First authorization.
+
+	v := url.Values{}
+	v.Set("user", `{"name":{"firstName":"Barack","lastName":"Obama"},"email":"abc@privaterelay.appleid.com"}`)
+	r, _ := http.NewRequest("POST", "http://localhost:8080/-/auth/apple/callback",
+		strings.NewReader(v.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	up := profile.New("Apple", "https://appleid.apple.com")
+	up.ID = cl.Subject
+	up.Person = decodePerson(r, up, cl)
+	if x := up.Person.Name.GivenName; x != "Barack" {
+		t.Errorf(`up.Person.Name.GivenName: %v, want "Barack"`, x)
+	}
+	if x := up.Person.DisplayName; x != "Barack Obama" {
+		t.Errorf(`up.Person.DisplayName: %v, want "Barack Obama"`, x)
+	}
+	if err := profile.DefaultStore.Put(nil, up); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	// Subsequent authorization keeps the saved name.
+
+	r, _ = http.NewRequest("POST", "http://localhost:8080/-/auth/apple/callback", nil)
+	up2 := profile.New("Apple", "https://appleid.apple.com")
+	up2.ID = cl.Subject
+	up2.Person = decodePerson(r, up2, cl)
+	if up2.Person.Name == nil || up2.Person.Name.GivenName != "Barack" {
+		t.Errorf(`up2.Person.Name: %v, want "Barack"`, up2.Person.Name)
+	}
+	if x := up2.Person.Email; x != "abc@privaterelay.appleid.com" {
+		t.Errorf(`up2.Person.Email: %v, want "abc@privaterelay.appleid.com"`, x)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/audit provides the audit log of security events, e.g.
+logins, new users and password changes.
+
+The auth packages record an AuthEvent with Record for each of the
+events. The events are written to DefaultSink, which auth/audit/datastore
+sets to save them as "AuthEvent" entities in the datastore. It may be
+replaced by any type that implements Sink, e.g. to also send the events
+to an external log:
+
+  audit.DefaultSink = mySink{datastore.Sink{}}
+
+Users may read their own events through Service.History, and admins may
+query all of the events through Service.Query.
+*/
+package audit
+
+import (
+	"errors"
+	"github.com/gaego/auth/logging"
+	"github.com/gaego/auth/origin"
+	"github.com/gaego/auth/tenant"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotQueryable = errors.New("auth/audit: the sink can not be queried")
+	ErrNoSink       = errors.New("auth/audit: no Sink is set, import github.com/gaego/auth/appengine or set DefaultSink")
+)
+
+// The types of AuthEvent.
+const (
+	LoginSuccess    = "login.success"
+	LoginFailure    = "login.failure"
+	UserCreated     = "user.created"
+	ProfileLinked   = "profile.linked"
+	PasswordChanged = "password.changed"
+	RoleGranted     = "role.granted"
+	RoleRevoked     = "role.revoked"
+	Logout          = "logout"
+)
+
+// AuthEvent is a security event.
+type AuthEvent struct {
+	ID int64 `datastore:"-" json:"id"`
+	// Type is one of the event types, e.g. LoginSuccess.
+	Type string `json:"type"`
+	// UserID is the ID of the User the event concerns. It is empty for
+	// failed logins of unknown Users.
+	UserID string `json:"userId,omitempty"`
+	// Provider is the name of the provider, e.g. "Google".
+	Provider string `json:"provider,omitempty"`
+	// AuthID is the ID of the Profile, if any.
+	AuthID string `json:"authId,omitempty"`
+	// Detail is e.g. the error of a failed login or the granted role.
+	Detail    string    `datastore:",noindex" json:"detail,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `datastore:",noindex" json:"userAgent,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Sink is implemented by the types that write AuthEvents.
+type Sink interface {
+	Write(r *http.Request, e *AuthEvent) error
+}
+
+// Querier is implemented by Sinks that can be queried.
+type Querier interface {
+	// Query returns the AuthEvents that match q, newest first.
+	Query(r *http.Request, q *Query) ([]*AuthEvent, error)
+}
+
+// Query selects AuthEvents. Empty fields match any event.
+type Query struct {
+	UserID   string
+	Type     string
+	Provider string
+	Since    time.Time
+	Until    time.Time
+	// Limit is the maximum number of events. If zero DefaultLimit is
+	// used, and it is at most MaxLimit.
+	Limit int
+}
+
+var (
+	// DefaultLimit is the number of events returned by a Query without
+	// a Limit.
+	DefaultLimit = 100
+	// MaxLimit is the largest Limit of a Query, so that one query can't
+	// read the whole log.
+	MaxLimit = 1000
+)
+
+// Max returns the number of events to select: the Limit, or DefaultLimit
+// if zero, but at most MaxLimit.
+func (q *Query) Max() int {
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	if q.Limit > 0 {
+		return q.Limit
+	}
+	return DefaultLimit
+}
+
+// match reports whether e is selected by q, ignoring the Limit.
+func (q *Query) match(e *AuthEvent) bool {
+	return (q.UserID == "" || e.UserID == q.UserID) &&
+		(q.Type == "" || e.Type == q.Type) &&
+		(q.Provider == "" || e.Provider == q.Provider) &&
+		(q.Since.IsZero() || !e.Created.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Created.Before(q.Until))
+}
+
+// DefaultSink is the Sink used by Record. Until it is set, e.g. by
+// importing auth/audit/datastore, events fail to be written with
+// ErrNoSink.
+var DefaultSink Sink = noSink{}
+
+// noSink is the DefaultSink until one is set.
+type noSink struct{}
+
+func (noSink) Write(r *http.Request, e *AuthEvent) error {
+	return ErrNoSink
+}
+
+// Record writes the event to the DefaultSink. The IP address, user agent
+// and time are taken from the request. A failure to write the event is
+// logged but doesn't fail the request that caused it.
+func Record(r *http.Request, e *AuthEvent) {
+	e.IP = origin.IP(r)
+	e.UserAgent = r.UserAgent()
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	if err := DefaultSink.Write(r, e); err != nil {
+		logging.NewLogger(r).Errorf("auth/audit: unable to record %v event for user %v: %v",
+			e.Type, e.UserID, err)
+	}
+}
+
+// Search runs q against the DefaultSink.
+func Search(r *http.Request, q *Query) ([]*AuthEvent, error) {
+	s, ok := DefaultSink.(Querier)
+	if !ok {
+		return nil, ErrNotQueryable
+	}
+	return s.Query(r, q)
+}
+
+// MemorySink keeps AuthEvents in memory. It is intended for tests and
+// development servers.
+type MemorySink struct {
+	mu     sync.RWMutex
+	events []memoryEvent
+}
+
+// memoryEvent is an AuthEvent of a MemorySink and the namespace of its
+// Tenant.
+type memoryEvent struct {
+	namespace string
+	AuthEvent
+}
+
+// NewMemorySink creates an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(r *http.Request, e *AuthEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, memoryEvent{tenant.Namespace(r), *e})
+	return nil
+}
+
+func (s *MemorySink) Query(r *http.Request, q *Query) ([]*AuthEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var es []*AuthEvent
+	ns := tenant.Namespace(r)
+	for _, me := range s.events {
+		if me.namespace == ns && q.match(&me.AuthEvent) {
+			e := me.AuthEvent
+			es = append(es, &e)
+		}
+	}
+	sort.Sort(byCreated(es))
+	if len(es) > q.Max() {
+		es = es[:q.Max()]
+	}
+	return es, nil
+}
+
+type byCreated []*AuthEvent
+
+func (s byCreated) Len() int      { return len(s) }
+func (s byCreated) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool {
+	if s[i].Created.Equal(s[j].Created) {
+		return s[i].ID > s[j].ID
+	}
+	return s[i].Created.After(s[j].Created)
+}
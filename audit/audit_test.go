@@ -0,0 +1,95 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"github.com/gaego/auth/session"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	s := NewMemorySink()
+	defer func(s Sink) { DefaultSink = s }(DefaultSink)
+	DefaultSink = s
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("User-Agent", "laptop")
+	now := time.Now()
+	Record(r, &AuthEvent{Type: LoginFailure, Provider: "Google", Created: now.Add(-2 * time.Hour)})
+	Record(r, &AuthEvent{Type: LoginSuccess, UserID: "1", Provider: "Google", Created: now.Add(-time.Hour)})
+	Record(r, &AuthEvent{Type: PasswordChanged, UserID: "1", Provider: "Password"})
+	Record(r, &AuthEvent{Type: LoginSuccess, UserID: "2", Provider: "Password"})
+
+	es, err := Search(r, &Query{UserID: "1"})
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if len(es) != 2 {
+		t.Fatalf(`len(es): %v, want 2`, len(es))
+	}
+	if es[0].Type != PasswordChanged || es[1].Type != LoginSuccess {
+		t.Errorf(`es: %v, %v, want newest first`, es[0].Type, es[1].Type)
+	}
+	if es[0].IP != "10.0.0.1" || es[0].UserAgent != "laptop" || es[0].Created.IsZero() {
+		t.Errorf(`es[0]: %v, want the IP, user agent and time of the request`, es[0])
+	}
+
+	// Filters.
+
+	tests := []struct {
+		q    *Query
+		want int
+	}{
+		{&Query{}, 4},
+		{&Query{Limit: 1}, 1},
+		{&Query{Type: LoginSuccess}, 2},
+		{&Query{Provider: "Google"}, 2},
+		{&Query{Since: now.Add(-90 * time.Minute)}, 3},
+		{&Query{Until: now.Add(-90 * time.Minute)}, 1},
+	}
+	for i, tt := range tests {
+		es, _ := Search(r, tt.q)
+		if len(es) != tt.want {
+			t.Errorf(`%v: len(es): %v, want %v`, i, len(es), tt.want)
+		}
+	}
+}
+
+func TestQueryMax(t *testing.T) {
+	for _, tt := range []struct{ limit, want int }{
+		{0, DefaultLimit},
+		{10, 10},
+		{MaxLimit + 1, MaxLimit},
+	} {
+		if x := (&Query{Limit: tt.limit}).Max(); x != tt.want {
+			t.Errorf(`%v: Max: %v, want %v`, tt.limit, x, tt.want)
+		}
+	}
+}
+
+func TestService_History(t *testing.T) {
+	defer func(s Sink) { DefaultSink = s }(DefaultSink)
+	DefaultSink = NewMemorySink()
+	defer func(s session.Store) { session.Default = s }(session.Default)
+	session.Default = session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	Record(r, &AuthEvent{Type: LoginSuccess, UserID: "1"})
+	Record(r, &AuthEvent{Type: LoginSuccess, UserID: "2"})
+	session.Default.SetUserID(httptest.NewRecorder(), r, "1")
+
+	s := &Service{}
+	reply := &Reply{}
+	if err := s.History(nil, r, &Args{UserID: "2"}, reply); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if len(reply.Events) != 1 || reply.Events[0].UserID != "1" {
+		t.Errorf(`reply.Events: %v, want the events of user 1`, reply.Events)
+	}
+}
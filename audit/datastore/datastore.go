@@ -0,0 +1,69 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/audit/datastore saves the AuthEvents of auth/audit to the
+App Engine datastore, as "AuthEvent" entities. It is imported for its
+side effect:
+
+  import _ "github.com/gaego/auth/audit/datastore"
+*/
+package datastore
+
+import (
+	"appengine/datastore"
+	"github.com/gaego/auth/audit"
+	"github.com/gaego/auth/namespace"
+	"net/http"
+)
+
+func init() {
+	audit.DefaultSink = Sink{}
+}
+
+// Sink saves AuthEvents to the App Engine datastore as
+// "AuthEvent" entities.
+type Sink struct{}
+
+func (Sink) Write(r *http.Request, e *audit.AuthEvent) error {
+	c := namespace.NewContext(r)
+	key, err := datastore.Put(c, datastore.NewIncompleteKey(c, "AuthEvent", nil), e)
+	if err != nil {
+		return err
+	}
+	e.ID = key.IntID()
+	return nil
+}
+
+// Query runs q. Only one of UserID, Type and Provider should be set
+// together with the time range, unless the matching composite indexes
+// have been created.
+func (Sink) Query(r *http.Request, q *audit.Query) ([]*audit.AuthEvent, error) {
+	c := namespace.NewContext(r)
+	dq := datastore.NewQuery("AuthEvent")
+	if q.UserID != "" {
+		dq = dq.Filter("UserID =", q.UserID)
+	}
+	if q.Type != "" {
+		dq = dq.Filter("Type =", q.Type)
+	}
+	if q.Provider != "" {
+		dq = dq.Filter("Provider =", q.Provider)
+	}
+	if !q.Since.IsZero() {
+		dq = dq.Filter("Created >=", q.Since)
+	}
+	if !q.Until.IsZero() {
+		dq = dq.Filter("Created <", q.Until)
+	}
+	var es []*audit.AuthEvent
+	keys, err := dq.Order("-Created").Limit(q.Max()).GetAll(c, &es)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		es[i].ID = k.IntID()
+	}
+	return es, nil
+}
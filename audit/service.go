@@ -0,0 +1,73 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"errors"
+	"github.com/gaego/auth/session"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrForbidden = errors.New("auth/audit: the admin role is required")
+)
+
+type Service struct{}
+
+type Args struct {
+	UserID   string
+	Type     string
+	Provider string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+type Reply struct {
+	Events []*AuthEvent
+}
+
+// History returns the current User's events, newest first. Only the
+// Type, time range and Limit of args are used.
+func (s *Service) History(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	reply.Events, err = Search(r, &Query{
+		UserID: id,
+		Type:   args.Type,
+		Since:  args.Since,
+		Until:  args.Until,
+		Limit:  args.Limit,
+	})
+	return err
+}
+
+// Query returns the events of any User. The current User must have the
+// admin role.
+func (s *Service) Query(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	u, err := session.Current(r)
+	if err != nil {
+		return err
+	}
+	if !u.HasRole("admin") {
+		return ErrForbidden
+	}
+	reply.Events, err = Search(r, &Query{
+		UserID:   args.UserID,
+		Type:     args.Type,
+		Provider: args.Provider,
+		Since:    args.Since,
+		Until:    args.Until,
+		Limit:    args.Limit,
+	})
+	return err
+}
@@ -10,6 +10,11 @@ Example Usage:
   import (
     "github.com/gaego/auth"
     "github.com/gaego/auth/google"
+    // On the first generation App Engine runtime providers must use
+    // the URL Fetch service.
+    _ "github.com/gaego/auth/fetch/urlfetch"
+    // On App Engine save the Profiles and Users to the datastore.
+    _ "github.com/gaego/auth/profile/datastore"
   )
 
   // Register the Google Provider.
@@ -23,11 +28,25 @@ Example Usage:
 package auth
 
 import (
+	"encoding/json"
+	"github.com/gaego/auth/audit"
+	"github.com/gaego/auth/fetch"
+	"github.com/gaego/auth/logging"
+	"github.com/gaego/auth/metrics"
+	"github.com/gaego/auth/origin"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
-	"github.com/gaego/user"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/token"
+	"github.com/gaego/auth/tracing"
+	"github.com/gaego/auth/user"
+	"github.com/gaego/person"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -43,15 +62,68 @@ var (
 	LogoutURL = "/-/auth/logout"
 	// SuccessURL is a string representing the URL to be direct to on a
 	// successful login.
+	//
+	// LoginURL, LogoutURL and SuccessURL may only be set before the
+	// requests are served; use SetURLs to change them afterwards.
 	SuccessURL = "/"
+	// Timeout limits the time a provider may take to authenticate a
+	// request, including its requests to the provider's endpoints. The
+	// deadline is set on the request's context. Zero means no limit.
+	Timeout time.Duration
+	// RememberField is the name of the login form field that asks for a
+	// remember me login, e.g. <input type="checkbox" name="remember">.
+	// It has no effect unless session.Default has a
+	// session.RememberStore.
+	RememberField = "remember"
+	// NextField is the name of the login parameter with the local URL to
+	// return to after the login, instead of SuccessURL, e.g.
+	// /-/auth/google?next=/-/oidc/authorize%3Fclient_id%3D...
+	NextField = "next"
+	// IssueTokens makes the logins return an access token and a refresh
+	// token, see auth/token, along with the User. The token endpoint
+	// returns them regardless when it doesn't set the session cookie,
+	// e.g. to native apps, which send no Origin.
+	IssueTokens = false
+	// CookieSession is false for apps that only use bearer tokens; the
+	// login then doesn't set the session cookie.
+	CookieSession = true
 )
 
-var providers = make(map[string]authenticater)
+// rememberCookie carries the remember me request of the login form
+// through the provider's redirects.
+const rememberCookie = "auth-remember-request"
+
+// nextCookie carries the NextField of the login through the provider's
+// redirects.
+const nextCookie = "auth-next"
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]authenticater)
+	// tenantProviders are the providers of each Tenant by its Name.
+	tenantProviders = make(map[string]map[string]authenticater)
+	// handled are the keys whose URLs have been registered.
+	handled = make(map[string]bool)
+)
 
 type authenticater interface {
 	Authenticate(http.ResponseWriter, *http.Request) (*profile.Profile, string, error)
 }
 
+// tokenAuthenticater is implemented by providers that can authenticate
+// a token obtained by a native app, e.g. through the Google or Facebook
+// SDKs, without any redirects.
+type tokenAuthenticater interface {
+	AuthenticateToken(http.ResponseWriter, *http.Request) (*profile.Profile, error)
+}
+
+// crossSiteCallbacker is implemented by providers whose callback is a
+// cross-site POST, e.g. Apple's form_post, that needs the session cookie
+// to be sent with SameSite=None.
+type crossSiteCallbacker interface {
+	CrossSiteCallback() bool
+}
+
 // Register adds an Authenticater for the auth service.
 //
 // It takes a string which is used for the url, and a pointer to an
@@ -61,12 +133,122 @@ type authenticater interface {
 //   googleProvider := google.Provider.New("12345", "ABCD")
 //   Register("google", &googleProvider)
 //
+// Registering a key again replaces its provider, e.g. when auth/config
+// reloads the configuration.
 func Register(key string, auth authenticater) {
+	providersMu.Lock()
 	providers[key] = auth
+	providersMu.Unlock()
+	handle(key, auth)
+}
+
+// RegisterTenant adds a provider of the Tenant. The requests of a Tenant
+// only use its own providers, e.g. with the Tenant's OAuth client:
+//
+//   auth.RegisterTenant(acme, "google", google.New(acmeID, acmeSecret, "openid email"))
+//
+func RegisterTenant(t *tenant.Tenant, key string, auth authenticater) {
+	if err := t.Validate(); err != nil {
+		panic(err)
+	}
+	providersMu.Lock()
+	ps := tenantProviders[t.Name]
+	if ps == nil {
+		ps = make(map[string]authenticater)
+		tenantProviders[t.Name] = ps
+	}
+	ps[key] = auth
+	providersMu.Unlock()
+	handle(key, auth)
+}
+
+// handle registers the URLs of the key, unless they already are.
+func handle(key string, auth authenticater) {
+	providersMu.Lock()
+	done := handled[key]
+	handled[key] = true
+	providersMu.Unlock()
+	if done {
+		return
+	}
 	// Set the start url e.g. /-/auth/google to be handled by the handler.
 	http.HandleFunc(BaseURL+key, handler)
 	// Set the callback url e.g. /-/auth/google/callback to be handled by the handler.
 	http.HandleFunc(BaseURL+key+"/callback", handler)
+	// Set the token url e.g. /-/auth/google/token to be handled by the
+	// tokenHandler.
+	http.HandleFunc(BaseURL+key+"/token", tokenHandler)
+	if c, ok := auth.(crossSiteCallbacker); ok && c.CrossSiteCallback() {
+		session.AddCrossSitePath(BaseURL + key + "/callback")
+	}
+}
+
+// Unregister removes the provider of the key. Its URLs respond with 404
+// Not Found until it is registered again.
+func Unregister(key string) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	delete(providers, key)
+}
+
+// UnregisterTenant removes the provider of the key of the Tenant.
+func UnregisterTenant(t *tenant.Tenant, key string) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	delete(tenantProviders[t.Name], key)
+}
+
+// provider returns the provider of the key for the request's Tenant, or
+// nil.
+func provider(r *http.Request, key string) authenticater {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if t := tenant.Current(r); t != nil {
+		return tenantProviders[t.Name][key]
+	}
+	return providers[key]
+}
+
+// urlSet are the URLs of SetURLs.
+type urlSet struct {
+	login, logout, success string
+}
+
+// urls holds the *urlSet of the last SetURLs.
+var urls atomic.Value
+
+// SetURLs replaces LoginURL, LogoutURL and SuccessURL at once, e.g. when
+// auth/config reloads its configuration while requests are served. Once
+// it is called the variables are no longer read.
+func SetURLs(login, logout, success string) {
+	urls.Store(&urlSet{login, logout, success})
+}
+
+// URLs returns the URLs of the last SetURLs, or else LoginURL, LogoutURL
+// and SuccessURL.
+func URLs() (login, logout, success string) {
+	if u, ok := urls.Load().(*urlSet); ok {
+		return u.login, u.logout, u.success
+	}
+	return LoginURL, LogoutURL, SuccessURL
+}
+
+// loginURL returns the LoginURL of the request's Tenant.
+func loginURL(r *http.Request) string {
+	if t := tenant.Current(r); t != nil && t.LoginURL != "" {
+		return t.LoginURL
+	}
+	login, _, _ := URLs()
+	return login
+}
+
+// defaultSuccessURL returns the SuccessURL of the request's Tenant.
+func defaultSuccessURL(r *http.Request) string {
+	if t := tenant.Current(r); t != nil && t.SuccessURL != "" {
+		return t.SuccessURL
+	}
+	_, _, success := URLs()
+	return success
 }
 
 // breakURL parse an url and returns the provider key. If the URL is
@@ -81,37 +263,204 @@ func breakURL(url string) (name string) {
 // CreateAndLogin does the following:
 //
 //  - Search for an existing user - session -> Profile -> email address
-//  - Saves the Profile to the datastore
+//  - Saves the Profile to the profile.DefaultStore
 //  - Creates a User or appends the AuthID to the Requesting user's account
-//  - Logs in the User
+//  - Logs in the User with the cookie session, unless CookieSession is false
 //  - Adds the admin role to the User if they are an GAE Admin.
+//  - Issues a remember me cookie if the login form asked for it.
 func CreateAndLogin(w http.ResponseWriter, r *http.Request,
 	p *profile.Profile) (u *user.User, err error) {
-	c := context.NewContext(r)
+	return createAndLogin(w, r, p, CookieSession)
+}
+
+// createAndLogin is CreateAndLogin that only sets the session cookie if
+// cookie is true.
+func createAndLogin(w http.ResponseWriter, r *http.Request,
+	p *profile.Profile, cookie bool) (u *user.User, err error) {
+	r, span := tracing.Start(r, "auth.CreateAndLogin", tracing.Provider(p.ProviderName))
+	defer func() { tracing.End(span, err) }()
 	if u, err = p.UpdateUser(w, r); err != nil {
 		return
 	}
-	if err = user.CurrentUserSetID(w, r, p.UserID); err != nil {
+	if cookie {
+		if err = session.Login(w, r, p.UserID, p.ProviderName); err != nil {
+			return
+		}
+	}
+	if cookie && rememberRequested(r) {
+		setRememberRequest(w, -1)
+		err = session.Remember(w, r, p.UserID, p.ProviderName)
+		if err != nil && err != session.ErrNoRememberStore {
+			return
+		}
+	}
+	if err = profile.DefaultStore.Put(r, p); err != nil {
 		return
 	}
-	err = p.Put(c)
+	audit.Record(r, &audit.AuthEvent{
+		Type:     audit.LoginSuccess,
+		UserID:   p.UserID,
+		Provider: p.ProviderName,
+		AuthID:   p.AuthID(),
+	})
 	return
 }
 
+// Logout logs out the User and records the logout in the audit log.
+func Logout(w http.ResponseWriter, r *http.Request) error {
+	id, _ := session.Default.UserID(r)
+	if err := session.Default.Destroy(w, r); err != nil {
+		return err
+	}
+	if id != "" {
+		audit.Record(r, &audit.AuthEvent{Type: audit.Logout, UserID: id})
+	}
+	return nil
+}
+
+// loginFailed records a failed login with the provider registered as
+// key. Like the successful logins it is recorded with the provider's
+// name, e.g. "Google", if it has one.
+func loginFailed(r *http.Request, key string, err error) {
+	name := key
+	if d, ok := provider(r, key).(describer); ok {
+		if n, _ := d.Describe(); n != "" {
+			name = n
+		}
+	}
+	audit.Record(r, &audit.AuthEvent{
+		Type:     audit.LoginFailure,
+		Provider: name,
+		Detail:   err.Error(),
+	})
+}
+
+// rememberRequested reports whether the login form asked for a remember
+// me login, either in this request or before the provider's redirects.
+func rememberRequested(r *http.Request) bool {
+	if r.FormValue(RememberField) != "" {
+		return true
+	}
+	_, err := r.Cookie(rememberCookie)
+	return err == nil
+}
+
+// setRememberRequest sets, or with a negative maxAge removes, the
+// cookie that carries the remember me request. It is sent with
+// cross-site callbacks, e.g. Apple's form_post, when the session cookies
+// are Secure.
+func setRememberRequest(w http.ResponseWriter, maxAge int) {
+	setLoginCookie(w, rememberCookie, "1", maxAge)
+}
+
+// setLoginCookie sets, or with a negative maxAge removes, a cookie that
+// carries a parameter of the login through the provider's redirects.
+func setLoginCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     BaseURL,
+		MaxAge:   maxAge,
+		Secure:   session.DefaultOptions.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if c.Secure {
+		c.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, c)
+}
+
+// localURL reports whether u is a path on this host, so that the login
+// can't be used to redirect to another site. Browsers ignore tabs and
+// newlines and treat backslashes as slashes, e.g. in "/\t/evil.com", so
+// URLs with them are rejected.
+func localURL(u string) bool {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") {
+		return false
+	}
+	for _, c := range u {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			return false
+		}
+	}
+	p, err := url.Parse(u)
+	return err == nil && p.Scheme == "" && p.Host == ""
+}
+
+// setNext sets, or with a negative maxAge removes, the cookie that
+// carries the URL to return to after the login.
+func setNext(w http.ResponseWriter, next string, maxAge int) {
+	setLoginCookie(w, nextCookie, url.QueryEscape(next), maxAge)
+}
+
+// successURL returns the URL to redirect to after a login.
+func successURL(r *http.Request) string {
+	if c, err := r.Cookie(nextCookie); err == nil {
+		if next, err := url.QueryUnescape(c.Value); err == nil && localURL(next) {
+			return next
+		}
+	}
+	return defaultSuccessURL(r)
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
 	var url string
 	var err error
 	var up *profile.Profile
+	r, cancel := fetch.WithTimeout(r, Timeout)
+	defer cancel()
 	k := breakURL(r.URL.Path)
-	p := providers[k]
-	if up, url, err = p.Authenticate(w, r); err != nil {
-		// TODO: set error message in session.
-		http.Redirect(w, r, LoginURL, http.StatusFound)
+	p := provider(r, k)
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// The steps of the provider package are labeled with k too.
+	r = metrics.WithProvider(r, k)
+	mode, target, ok := loginMode(r)
+	if !ok {
+		http.Error(w, "auth: the origin may not open the login", http.StatusBadRequest)
+		return
+	}
+	r, span := tracing.Start(r, "auth.handler", tracing.Provider(k))
+	defer func() { tracing.End(span, err) }()
+	// A request is the callback of the login unless the provider
+	// redirects, or fails before redirecting, to its login page.
+	m := metrics.Time(metrics.Callback, k)
+	ar, aspan := tracing.Start(r, "auth.Authenticate", tracing.Provider(k))
+	up, url, err = p.Authenticate(w, ar)
+	tracing.End(aspan, err)
+	if url != "" || (err != nil && !strings.HasSuffix(r.URL.Path, "/callback")) {
+		m.Step = metrics.Start
+	}
+	m.Done(err)
+	if err != nil {
+		loginFailed(r, k, err)
+		loginError(w, r, mode, target, http.StatusUnauthorized, "login_failed")
 		return
 	}
 	// If we have a url the Provider wants to make a redirect before
 	// proceeding.
 	if url != "" {
+		if r.FormValue(RememberField) != "" {
+			setRememberRequest(w, 600)
+		}
+		if next := r.FormValue(NextField); localURL(next) {
+			setNext(w, next, 600)
+		}
+		switch mode {
+		case ModePopup:
+			setPopup(w, target, 600)
+		case ModeJSON:
+			writeJSON(w, http.StatusOK, &TokenReply{RedirectURL: url})
+			return
+		default:
+			// The cookie of an abandoned popup.
+			if _, err := r.Cookie(popupCookie); err == nil {
+				setPopup(w, "", -1)
+			}
+		}
 		http.Redirect(w, r, url, http.StatusFound)
 		return
 	}
@@ -121,12 +470,148 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		panic(`auth: The Profile's "ID" or "ProviderName" is empty.` +
 			`A Key can not be created.`)
 	}
-	if _, err = CreateAndLogin(w, r, up); err != nil {
-		// TODO: set error message in session.
-		http.Redirect(w, r, LoginURL, http.StatusFound)
+	m = metrics.Time(metrics.CreateAndLogin, k)
+	_, err = CreateAndLogin(w, r, up)
+	m.Done(err)
+	if err != nil {
+		loginFailed(r, k, err)
+		loginError(w, r, mode, target, http.StatusInternalServerError, "server_error")
 		return
 	}
-	// If we've made it this far redirect to the SuccessURL
-	http.Redirect(w, r, SuccessURL, http.StatusFound)
-	return
+	// If we've made it this far redirect to the SuccessURL, or the URL
+	// the login was started from.
+	next := successURL(r)
+	if next != defaultSuccessURL(r) {
+		setNext(w, "", -1)
+	}
+	if mode == "" {
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	}
+	reply, err := newReply(r, up, IssueTokens)
+	if err != nil {
+		loginError(w, r, mode, target, http.StatusInternalServerError, "server_error")
+		return
+	}
+	reply.Next = next
+	if mode == ModePopup {
+		setPopup(w, "", -1)
+		writePopup(w, target, reply)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// TokenReply is the JSON response of the token endpoint, and of the
+// logins in ModeJSON and ModePopup.
+type TokenReply struct {
+	UserID string         `json:"userId,omitempty"`
+	Person *person.Person `json:"person,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	// Next is the URL the login was started from, or the SuccessURL.
+	Next string `json:"next,omitempty"`
+	// RedirectURL is the provider's login page, to be opened by the
+	// app, in ModeJSON.
+	RedirectURL string `json:"redirectURL,omitempty"`
+	// Tokens are set if IssueTokens is true, or if the token endpoint
+	// didn't set the session cookie.
+	*token.Tokens
+}
+
+// newReply returns the TokenReply of the login of the Profile, whose
+// UserID is set by UpdateUser, with the tokens if tokens is true.
+func newReply(r *http.Request, up *profile.Profile, tokens bool) (*TokenReply, error) {
+	reply := &TokenReply{
+		UserID: up.UserID,
+		Person: up.Person,
+	}
+	if tokens {
+		var err error
+		if reply.Tokens, err = token.Issue(r, reply.UserID, up.ProviderName); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// tokenOrigin reports whether the token endpoint may be used from the
+// Origin of the request, and whether the login may set the session
+// cookie. Native apps send no Origin and get the tokens instead. Pages of other origins are rejected, as their forms could
+// otherwise log the browser in to another account.
+func tokenOrigin(r *http.Request) (cookie, ok bool) {
+	switch o := r.Header.Get("Origin"); o {
+	case "":
+		return false, true
+	case origin.Of(r):
+		return CookieSession, true
+	}
+	return false, false
+}
+
+// tokenHandler handles the token endpoint used by native apps. The app
+// POSTs the token it obtained from the provider's SDK, e.g. "id_token"
+// for Google or "access_token" for Facebook, and the User is created and
+// logged in exactly as with the redirect flow. The User is returned in
+// the JSON body. The session is only returned in the response's cookie
+// to pages of the app's own origin, see tokenOrigin; the other requests,
+// and all of them if IssueTokens is true, get the tokens in the body.
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, &TokenReply{Error: "method_not_allowed"})
+		return
+	}
+	cookie, ok := tokenOrigin(r)
+	if !ok {
+		writeJSON(w, http.StatusForbidden, &TokenReply{Error: "invalid_origin"})
+		return
+	}
+	r, cancel := fetch.WithTimeout(r, Timeout)
+	defer cancel()
+	k := breakURL(r.URL.Path)
+	p, ok := provider(r, k).(tokenAuthenticater)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &TokenReply{Error: "unsupported_provider"})
+		return
+	}
+	var err error
+	r = metrics.WithProvider(r, k)
+	r, span := tracing.Start(r, "auth.tokenHandler", tracing.Provider(k))
+	defer func() { tracing.End(span, err) }()
+	m := metrics.Time(metrics.Token, k)
+	ar, aspan := tracing.Start(r, "auth.AuthenticateToken", tracing.Provider(k))
+	up, err := p.AuthenticateToken(w, ar)
+	tracing.End(aspan, err)
+	m.Done(err)
+	if err != nil {
+		logging.NewLogger(r).Infof("auth: token login for %v failed: %v", k, err)
+		loginFailed(r, k, err)
+		writeJSON(w, http.StatusUnauthorized, &TokenReply{Error: "invalid_token"})
+		return
+	}
+	if up.ID == "" || up.ProviderName == "" {
+		panic(`auth: The Profile's "ID" or "ProviderName" is empty.` +
+			`A Key can not be created.`)
+	}
+	m = metrics.Time(metrics.CreateAndLogin, k)
+	_, err = createAndLogin(w, r, up, cookie)
+	m.Done(err)
+	if err != nil {
+		loginFailed(r, k, err)
+		writeJSON(w, http.StatusInternalServerError, &TokenReply{Error: "server_error"})
+		return
+	}
+	// The app gets a session or the tokens.
+	reply, err := newReply(r, up, IssueTokens || !cookie)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &TokenReply{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
 }
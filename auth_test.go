@@ -5,15 +5,22 @@
 package auth
 
 import (
-	"appengine/datastore"
+	"encoding/json"
 	"errors"
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/audit"
 	"github.com/gaego/auth/dev"
+	"github.com/gaego/auth/jwt"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
-	"github.com/gaego/user"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/token"
+	"github.com/gaego/auth/user"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func setup() {
@@ -21,10 +28,15 @@ func setup() {
 	LoginURL = "/-/auth/login"
 	LogoutURL = "/-/auth/logout"
 	SuccessURL = "/"
+	profile.DefaultStore = profile.NewMemoryStore()
+	profile.DefaultUserStore = profile.NewMemoryUserStore()
+	session.Default = session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+	audit.DefaultSink = audit.NewMemorySink()
+	token.DefaultKeyStore = token.NewMemoryKeyStore()
+	token.DefaultRefreshStore = token.NewMemoryRefreshStore()
 }
 
 func teardown() {
-	context.Close()
 }
 
 type TestProvider struct {
@@ -66,6 +78,20 @@ func (p *TPComplete) Authenticate(w http.ResponseWriter, r *http.Request) (
 	return up, "", nil
 }
 
+type TPToken struct {
+	dev.Provider
+}
+
+func (p *TPToken) AuthenticateToken(w http.ResponseWriter, r *http.Request) (
+	*profile.Profile, error) {
+	if r.FormValue("id_token") != "valid" {
+		return nil, errors.New("Mock error")
+	}
+	up := profile.New("Example", "example.com")
+	up.ID = "1"
+	return up, nil
+}
+
 func TestNew(t *testing.T) {
 	setup()
 	defer teardown()
@@ -145,15 +171,14 @@ func TestError(t *testing.T) {
 	// Inspected the redirect.
 
 	hdr := w.Header()
-	if hdr["Location"][0] != LoginURL {
-		t.Errorf(`hdr["Location"]: %q, want %q`, hdr["Location"], LoginURL)
+	if x := hdr["Location"][0]; x != LoginURL+"?error=login_failed" {
+		t.Errorf(`hdr["Location"]: %q, want %q`, x, LoginURL+"?error=login_failed")
 	}
 }
 
 func Test_handler(t *testing.T) {
 	setup()
 	defer teardown()
-	_ = context.NewContext(nil)
 
 	// Register the Provider
 
@@ -179,7 +204,6 @@ func Test_handler(t *testing.T) {
 func Test_CreateAndLogin(t *testing.T) {
 	setup()
 	defer teardown()
-	c := context.NewContext(nil)
 
 	up := profile.New("Example", "example.com")
 	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/example4", nil)
@@ -189,15 +213,7 @@ func Test_CreateAndLogin(t *testing.T) {
 
 	// Confirm.
 
-	q := datastore.NewQuery("User")
-	if cnt, _ := q.Count(c); cnt != 0 {
-		t.Errorf(`User cnt: %v, want 0`, cnt)
-	}
-	q = datastore.NewQuery("Profile")
-	if cnt, _ := q.Count(c); cnt != 0 {
-		t.Errorf(`Profile cnt: %v, want 0`, cnt)
-	}
-	u, err := user.Current(r)
+	u, err := session.Current(r)
 	if err != user.ErrNoLoggedInUser {
 		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
 	}
@@ -206,61 +222,58 @@ func Test_CreateAndLogin(t *testing.T) {
 
 	up.ID = "1"
 	up.ProviderName = "Example"
-	up.SetKey(c)
 	u, err = CreateAndLogin(w, r, up)
 	if err != nil {
-		t.Errorf(`err: %v, want nil`, err)
+		t.Fatalf(`err: %v, want nil`, err)
 	}
 
-	if u.Key.StringID() != "1" {
-		t.Errorf(`u.Key.StringID(): %v, want 1`, u.Key.StringID())
+	if u.ID != "1" {
+		t.Errorf(`u.ID: %v, want 1`, u.ID)
 	}
-	if up.Key.StringID() != "example|1" {
-		t.Errorf(`up.Key.StringID(): %v, want "example|1"`, up.Key.StringID())
+	if up.AuthID() != "example|1" {
+		t.Errorf(`up.AuthID(): %v, want "example|1"`, up.AuthID())
 	}
-	if up.UserID != u.Key.StringID() {
-		t.Errorf(`up.UserID: %v, want %v`, up.UserID, u.Key.StringID())
+	if up.UserID != u.ID {
+		t.Errorf(`up.UserID: %v, want %v`, up.UserID, u.ID)
 	}
 
 	// Confirm Profile.
 
-	rup, err := profile.Get(c, "example|1")
+	rup, err := profile.DefaultStore.Get(r, "example|1")
 	if err != nil {
 		t.Errorf(`err: %v, want nil`, err)
 	}
 	if rup.ID != "1" {
 		t.Errorf(`rup.ID: %v, want "1"`, rup.ID)
 	}
-	if rup.Key.StringID() != "example|1" {
-		t.Errorf(`rup.Key.StringID(): %v, want "example|1"`, rup.Key.StringID())
+	if rup.AuthID() != "example|1" {
+		t.Errorf(`rup.AuthID(): %v, want "example|1"`, rup.AuthID())
 	}
-	if rup.UserID != u.Key.StringID() {
-		t.Errorf(`rup.UserID: %v, want %v`, rup.UserID, u.Key.StringID())
+	if rup.UserID != u.ID {
+		t.Errorf(`rup.UserID: %v, want %v`, rup.UserID, u.ID)
 	}
 
 	// Confirm User.
 
-	ru, err := user.Get(c, "1")
+	ru, err := profile.DefaultUserStore.Get(r, "1")
 	if err != nil {
 		t.Fatalf(`err: %v, want nil`, err)
 	}
 	if ru.AuthIDs[0] != "example|1" {
 		t.Errorf(`ru.AuthIDs[0]: %v, want "example|1"`, ru.AuthIDs[0])
 	}
-	if ru.Key.StringID() != "1" {
-		t.Errorf(`ru.Key.StringID(): %v, want 1`, ru.Key.StringID())
+	if ru.ID != "1" {
+		t.Errorf(`ru.ID: %v, want 1`, ru.ID)
 	}
-	q2 := datastore.NewQuery("User")
-	q4 := datastore.NewQuery("AuthProfile")
 
 	// Confirm Logged in User.
 
-	u, err = user.Current(r)
+	u, err = session.Current(r)
 	if err != nil {
-		t.Errorf(`err: %v, want %v`, err, nil)
+		t.Fatalf(`err: %v, want %v`, err, nil)
 	}
-	if u.Key.StringID() != "1" {
-		t.Errorf(`u.Key.StringID(): %v, want 1`, u.Key.StringID())
+	if u.ID != "1" {
+		t.Errorf(`u.ID: %v, want 1`, u.ID)
 	}
 	if len(u.AuthIDs) != 1 {
 		t.Errorf(`len(u.AuthIDs): %v, want 1`, len(u.AuthIDs))
@@ -274,41 +287,38 @@ func Test_CreateAndLogin(t *testing.T) {
 
 	up = profile.New("AnotherExample", "anotherexample.com")
 	up.ID = "2"
-	up.SetKey(c)
 	u, err = CreateAndLogin(w, r, up)
 	if err != nil {
-		t.Errorf(`err: %v, want nil`, err)
+		t.Fatalf(`err: %v, want nil`, err)
 	}
 
 	// Confirm Profile.
 
-	rup, err = profile.Get(c, "anotherexample|2")
+	rup, err = profile.DefaultStore.Get(r, "anotherexample|2")
 	if err != nil {
 		t.Errorf(`err: %v, want nil`, err)
 	}
 	if rup.ID != "2" {
 		t.Errorf(`rup.ID: %v, want "2"`, rup.ID)
 	}
-	if rup.Key.StringID() != "anotherexample|2" {
-		t.Errorf(`rup.Key.StringID(): %v, want "anotherexample|2"`, rup.Key.StringID())
+	if rup.AuthID() != "anotherexample|2" {
+		t.Errorf(`rup.AuthID(): %v, want "anotherexample|2"`, rup.AuthID())
 	}
-	if rup.UserID != u.Key.StringID() {
-		t.Errorf(`rup.UserID: %v, want %v`, rup.UserID, u.Key.StringID())
+	if rup.UserID != u.ID {
+		t.Errorf(`rup.UserID: %v, want %v`, rup.UserID, u.ID)
 	}
 
 	// Confirm Logged in User hasn't changed.
 
-	u, err = user.Current(r)
+	u, err = session.Current(r)
 	if err != nil {
-		t.Errorf(`err: %v, want %v`, err, nil)
+		t.Fatalf(`err: %v, want %v`, err, nil)
 	}
-	if u.Key.StringID() != "1" {
-		t.Errorf(`u.Key.StringID(): %v, want 1`, u.Key.StringID())
+	if u.ID != "1" {
+		t.Errorf(`u.ID: %v, want 1`, u.ID)
 	}
 	if len(u.AuthIDs) != 2 {
-		t.Errorf(`len(u.AuthIDs): %v, want 2`, len(u.AuthIDs))
-		t.Errorf(`u.AuthIDs: %v`, u.AuthIDs)
-		t.Errorf(`u: %v`, u)
+		t.Fatalf(`u.AuthIDs: %v, want 2 auth ids`, u.AuthIDs)
 	}
 	if u.AuthIDs[0] != "example|1" {
 		t.Errorf(`u.AuthIDs[0]: %v, want "example|1"`, u.AuthIDs[0])
@@ -317,24 +327,22 @@ func Test_CreateAndLogin(t *testing.T) {
 		t.Errorf(`u.AuthIDs[1]: %v, want "anotherexample|2"`, u.AuthIDs[1])
 	}
 
-	// Confirm Counts
+	// Confirm no other User was created.
 
-	q2 = datastore.NewQuery("User")
-	if cnt, _ := q2.Count(c); cnt != 1 {
-		t.Errorf(`User cnt: %v, want 1`, cnt)
+	if _, err = profile.DefaultUserStore.Get(r, "2"); err != profile.ErrNoSuchUser {
+		t.Errorf(`err: %v, want %v`, err, profile.ErrNoSuchUser)
 	}
-	q4 = datastore.NewQuery("AuthProfile")
 
 	// Round 3: Logged out User | Existing Profile
 
-	err = user.Logout(w, r)
+	err = session.Default.Destroy(w, r)
 	if err != nil {
 		t.Errorf(`err: %v, want nil`, err)
 	}
 
 	// Confirm Logged out User.
 
-	u, err = user.Current(r)
+	u, err = session.Current(r)
 	if err != user.ErrNoLoggedInUser {
 		t.Errorf(`err: %q, want %q`, err, user.ErrNoLoggedInUser)
 	}
@@ -343,31 +351,25 @@ func Test_CreateAndLogin(t *testing.T) {
 
 	up = profile.New("Example", "example.com")
 	up.ID = "1"
-	up.SetKey(c)
 	u, err = CreateAndLogin(w, r, up)
 	if err != nil {
-		t.Errorf(`err: %v, want nil`, err)
+		t.Fatalf(`err: %v, want nil`, err)
 	}
 
 	// Confirm.
 
-	q2 = datastore.NewQuery("User")
-	if cnt, _ := q2.Count(c); cnt != 1 {
-		t.Errorf(`User cnt: %v, want 1`, cnt)
-	}
-	q4 = datastore.NewQuery("AuthProfile")
-	if cnt, _ := q4.Count(c); cnt != 2 {
-		t.Errorf(`Profile cnt: %v, want 1`, cnt)
+	if _, err = profile.DefaultUserStore.Get(r, "2"); err != profile.ErrNoSuchUser {
+		t.Errorf(`err: %v, want %v`, err, profile.ErrNoSuchUser)
 	}
 
 	// Confirm Logged in User hasn't changed.
 
-	u, err = user.Current(r)
+	u, err = session.Current(r)
 	if err != nil {
-		t.Errorf(`err: %v, want %v`, err, nil)
+		t.Fatalf(`err: %v, want %v`, err, nil)
 	}
-	if u.Key.StringID() != "1" {
-		t.Errorf(`u.Key.StringID(): %v, want "1"`, u.Key.StringID())
+	if u.ID != "1" {
+		t.Errorf(`u.ID: %v, want "1"`, u.ID)
 	}
 	if len(u.AuthIDs) != 2 {
 		t.Errorf(`len(u.AuthIDs): %v, want 2`, len(u.AuthIDs))
@@ -375,3 +377,479 @@ func Test_CreateAndLogin(t *testing.T) {
 		t.Errorf(`u: %v`, u)
 	}
 }
+
+func Test_tokenOrigin(t *testing.T) {
+	r, _ := http.NewRequest("POST", "http://localhost:8080/-/auth/example6/token", nil)
+	for o, want := range map[string][2]bool{
+		"":                          {false, true},
+		"http://localhost:8080":     {true, true},
+		"https://evil.example.com":  {false, false},
+		"http://localhost:8080.com": {false, false},
+	} {
+		r.Header.Set("Origin", o)
+		if cookie, ok := tokenOrigin(r); cookie != want[0] || ok != want[1] {
+			t.Errorf(`%q: tokenOrigin: %v, %v, want %v, %v`, o, cookie, ok, want[0], want[1])
+		}
+	}
+
+	// A form of another site.
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	tokenHandler(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf(`code: %v, want %v without a cookie`, w.Code, http.StatusForbidden)
+	}
+}
+
+func Test_newReply(t *testing.T) {
+	defer func(s token.KeyStore) { token.DefaultKeyStore = s }(token.DefaultKeyStore)
+	token.DefaultKeyStore = token.NewMemoryKeyStore()
+	defer func(s token.RefreshStore) { token.DefaultRefreshStore = s }(token.DefaultRefreshStore)
+	token.DefaultRefreshStore = token.NewMemoryRefreshStore()
+
+	r, _ := http.NewRequest("POST", "http://localhost:8080/-/auth/example6/token", nil)
+	up := profile.New("Example", "example.com")
+	up.ID, up.UserID = "1", "1"
+	reply, err := newReply(r, up, false)
+	if err != nil || reply.Tokens != nil {
+		t.Errorf(`reply.Tokens: %v, %v, want nil`, reply.Tokens, err)
+	}
+
+	// A native app without the session cookie gets the tokens.
+
+	cookie, _ := tokenOrigin(r)
+	if reply, err = newReply(r, up, IssueTokens || !cookie); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if reply.Tokens == nil || reply.Tokens.AccessToken == "" {
+		t.Errorf(`reply.Tokens: %v, want the tokens`, reply.Tokens)
+	}
+}
+
+func Test_tokenHandler(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// Register the Provider
+
+	Register("example6", &TPToken{})
+	Register("example7", &TPComplete{})
+
+	post := func(u, token string) *httptest.ResponseRecorder {
+		v := url.Values{"id_token": {token}}
+		r, _ := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tokenHandler(w, r)
+		return w
+	}
+
+	// Invalid token.
+
+	w := post("http://localhost:8080/-/auth/example6/token", "invalid")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf(`w.Code: %v, want %v`, w.Code, http.StatusUnauthorized)
+	}
+
+	// Provider without token support.
+
+	w = post("http://localhost:8080/-/auth/example7/token", "valid")
+	if w.Code != http.StatusNotFound {
+		t.Errorf(`w.Code: %v, want %v`, w.Code, http.StatusNotFound)
+	}
+
+	// Valid token.
+
+	w = post("http://localhost:8080/-/auth/example6/token", "valid")
+	if w.Code != http.StatusOK {
+		t.Errorf(`w.Code: %v, want %v`, w.Code, http.StatusOK)
+	}
+	if x := w.Header().Get("Location"); x != "" {
+		t.Errorf(`Location: %v, want ""`, x)
+	}
+	var reply TokenReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if reply.UserID == "" {
+		t.Errorf(`reply.UserID: %v, want an ID`, reply.UserID)
+	}
+}
+
+func Test_rememberRequested(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google", nil)
+	if rememberRequested(r) {
+		t.Errorf(`rememberRequested: true, want false`)
+	}
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google?remember=1", nil)
+	if !rememberRequested(r) {
+		t.Errorf(`rememberRequested: false, want true`)
+	}
+
+	// The request is carried through the provider's redirects.
+
+	w := httptest.NewRecorder()
+	setRememberRequest(w, 600)
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+	r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	if !rememberRequested(r) {
+		t.Errorf(`rememberRequested: false, want true`)
+	}
+
+	// A failed login removes it.
+
+	w = httptest.NewRecorder()
+	loginError(w, r, "", "", http.StatusUnauthorized, "login_failed")
+	if c := w.Header().Get("Set-Cookie"); !strings.HasPrefix(c, rememberCookie+"=") || !strings.Contains(c, "Max-Age=0") {
+		t.Errorf(`Set-Cookie: %v, want %v removed`, c, rememberCookie)
+	}
+}
+
+func Test_successURL(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+	if u := successURL(r); u != SuccessURL {
+		t.Errorf(`successURL: %v, want %v`, u, SuccessURL)
+	}
+	for next, want := range map[string]string{
+		"/-/oidc/authorize?client_id=1": "/-/oidc/authorize?client_id=1",
+		"//evil.example.com/":           SuccessURL,
+		"/\\evil.example.com/":          SuccessURL,
+		"/\t/evil.example.com/":         SuccessURL,
+		"/\n/evil.example.com/":         SuccessURL,
+		"https://evil.example.com/":     SuccessURL,
+	} {
+		w := httptest.NewRecorder()
+		setNext(w, next, 600)
+		r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+		r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+		if u := successURL(r); u != want {
+			t.Errorf(`successURL(%q): %v, want %v`, next, u, want)
+		}
+	}
+}
+
+func Test_loginMode(t *testing.T) {
+	defer func() { PopupOrigins = nil }()
+	PopupOrigins = []string{"https://app.example.com"}
+	tests := []struct {
+		url, mode, target string
+		ok                bool
+	}{
+		{"http://localhost:8080/-/auth/google", "", "", true},
+		{"http://localhost:8080/-/auth/password?mode=json", ModeJSON, "", true},
+		{"http://localhost:8080/-/auth/google?mode=popup", ModePopup, "http://localhost:8080", true},
+		{"http://localhost:8080/-/auth/google?mode=popup&origin=https://app.example.com",
+			ModePopup, "https://app.example.com", true},
+		{"http://localhost:8080/-/auth/google?mode=popup&origin=https://evil.example.com",
+			ModePopup, "https://evil.example.com", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", tt.url, nil)
+		mode, target, ok := loginMode(r)
+		if mode != tt.mode || target != tt.target || ok != tt.ok {
+			t.Errorf(`loginMode(%q): %q, %q, %v, want %q, %q, %v`, tt.url,
+				mode, target, ok, tt.mode, tt.target, tt.ok)
+		}
+	}
+
+	// The popup's origin is carried to the callback.
+	w := httptest.NewRecorder()
+	setPopup(w, "https://app.example.com", 600)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+	r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	if mode, target, _ := loginMode(r); mode != ModePopup || target != "https://app.example.com" {
+		t.Errorf(`loginMode: %q, %q, want the popup`, mode, target)
+	}
+}
+
+func TestModes(t *testing.T) {
+	setup()
+	defer teardown()
+	Register("example7", &TPRedirect{})
+	Register("example8", &TPError{})
+
+	// JSON replies with the provider's login page.
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/example7?mode=json", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	var reply TokenReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if reply.RedirectURL != "/redirect-to-url" {
+		t.Errorf(`reply.RedirectURL: %v, want "/redirect-to-url"`, reply.RedirectURL)
+	}
+
+	// JSON errors.
+	r, _ = http.NewRequest("POST", "http://localhost:8080/-/auth/example8?mode=json", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"login_failed"`) {
+		t.Errorf(`code: %v, body: %v, want 401 and login_failed`, w.Code, w.Body)
+	}
+
+	// The popup posts the error to its origin only.
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/example8?mode=popup", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	b := w.Body.String()
+	if !strings.Contains(b, `"error":"login_failed"`) || !strings.Contains(b, `"http://localhost:8080"`) {
+		t.Errorf(`body: %v, want the error posted to "http://localhost:8080"`, b)
+	}
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/example8?mode=popup&origin=https://evil.example.com", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	defer func(s apikey.Store) { apikey.DefaultStore = s }(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewMemoryStore()
+
+	var called bool
+	h := APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	// No key.
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/api", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !called {
+		t.Errorf(`called: false, want true`)
+	}
+
+	// Invalid key.
+
+	called = false
+	r.Header.Set("Authorization", "Bearer "+apikey.Prefix+"x.y")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if called || w.Code != http.StatusUnauthorized {
+		t.Errorf(`called: %v, code: %v, want false, 401`, called, w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf(`WWW-Authenticate: "", want a challenge`)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	defer func(s apikey.Store) { apikey.DefaultStore = s }(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewMemoryStore()
+
+	var called bool
+	h := RequireScope("repo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	r, _ := http.NewRequest("GET", "http://localhost:8080/api/repos", nil)
+	for _, tt := range []struct {
+		scopes []string
+		code   int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"read", "repo"}, http.StatusOK},
+		{[]string{"read"}, http.StatusForbidden},
+	} {
+		_, tok, _ := apikey.Create(r, "1", "CI", tt.scopes, 0)
+		r.Header.Set("Authorization", "Bearer "+tok)
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code || called != (tt.code == http.StatusOK) {
+			t.Errorf(`scopes %v: code: %v, want %v`, tt.scopes, w.Code, tt.code)
+		}
+	}
+}
+
+// noUserStore is a Store that has a session without a User.
+type noUserStore struct {
+	session.Store
+}
+
+func (noUserStore) UserID(r *http.Request) (string, error) {
+	return "", nil
+}
+
+func TestRequire(t *testing.T) {
+	setup()
+	defer func(s session.Store) { session.Default = s }(session.Default)
+	session.Default = session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+	defer func(s token.KeyStore) { token.DefaultKeyStore = s }(token.DefaultKeyStore)
+	token.DefaultKeyStore = token.NewMemoryKeyStore()
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/account?tab=1", nil)
+	if err := profile.DefaultUserStore.Put(r, "1", user.New()); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	var l *session.LoginInfo
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l = CurrentLogin(r)
+	})
+	serve := func(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+		l = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Not logged in.
+
+	w := serve(Require(ok), r)
+	if loc := w.Header().Get("Location"); loc != LoginURL+"?next=%2Faccount%3Ftab%3D1" {
+		t.Errorf(`Location: %v, want the login page with next`, loc)
+	}
+	r.Header.Set("Accept", "application/json")
+	if w = serve(Require(ok), r); w.Code != http.StatusUnauthorized || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusUnauthorized)
+	}
+
+	// Logged in.
+
+	session.Login(httptest.NewRecorder(), r, "1", "Google")
+	if w = serve(Require(ok), r); l == nil || l.UserID != "1" || l.Provider != "Google" {
+		t.Errorf(`l: %+v, want the Google login of User 1`, l)
+	}
+	if w = serve(RequireFreshLogin(time.Minute, ok), r); l == nil {
+		t.Errorf(`code: %v, want the fresh login to pass`, w.Code)
+	}
+	if w = serve(RequireRole("admin", ok), r); w.Code != http.StatusForbidden || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusForbidden)
+	}
+
+	// A login that is too old.
+
+	r, _ = http.NewRequest("GET", "http://localhost:8080/account", nil)
+	session.Default.(*session.CookieStore).SetLogin(httptest.NewRecorder(), r, &session.LoginInfo{
+		UserID:   "1",
+		AuthTime: time.Now().Add(-time.Hour),
+	})
+	w = serve(RequireFreshLogin(time.Minute, ok), r)
+	if w.Code != http.StatusFound || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusFound)
+	}
+
+	// A login without its time, which logging in again wouldn't fix.
+
+	session.Default.(*session.CookieStore).SetLogin(httptest.NewRecorder(), r, &session.LoginInfo{UserID: "1"})
+	w = serve(RequireFreshLogin(time.Minute, ok), r)
+	if w.Code != http.StatusForbidden || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusForbidden)
+	}
+
+	// A store that returns no User.
+
+	session.Default = noUserStore{session.Default}
+	r, _ = http.NewRequest("GET", "http://localhost:8080/api", nil)
+	r.Header.Set("Accept", "application/json")
+	if w = serve(Require(ok), r); w.Code != http.StatusUnauthorized || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusUnauthorized)
+	}
+
+	// Access tokens.
+
+	r, _ = http.NewRequest("GET", "http://localhost:8080/api", nil)
+	at, _ := token.AccessToken(r, "2", "Apple")
+	r.Header.Set("Authorization", "Bearer "+at)
+	if w = serve(Require(ok), r); l == nil || l.UserID != "2" {
+		t.Errorf(`l: %+v, want the login of User 2`, l)
+	}
+	r.Header.Set("Authorization", "Bearer "+at[:len(at)-4]+"AAAA")
+	w = serve(Require(ok), r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid_token") {
+		t.Errorf(`code: %v, body: %s, want 401 invalid_token`, w.Code, w.Body)
+	}
+
+	// The ID tokens of auth/oidc are for other apps.
+
+	idt, _ := token.Sign(r, &struct {
+		jwt.StandardClaims
+		Nonce string `json:"nonce"`
+	}{StandardClaims: jwt.StandardClaims{
+		Issuer:    token.IssuerURL(r),
+		Subject:   "2",
+		Audience:  jwt.Audience{"client"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}, Nonce: "n"})
+	r.Header.Set("Authorization", "Bearer "+idt)
+	if w = serve(Require(ok), r); w.Code != http.StatusUnauthorized || l != nil {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginFailed(t *testing.T) {
+	defer func(s audit.Sink) { audit.DefaultSink = s }(audit.DefaultSink)
+	sink := audit.NewMemorySink()
+	audit.DefaultSink = sink
+	Register("example12", &TestProvider{dev.Provider{Name: "Example"}})
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/example12/callback", nil)
+	loginFailed(r, "example12", errors.New("denied"))
+	es, _ := sink.Query(r, &audit.Query{Type: audit.LoginFailure})
+	if len(es) != 1 || es[0].Provider != "Example" {
+		t.Errorf(`events: %v, want the failure with provider Example`, es)
+	}
+}
+
+type TPForm struct {
+	dev.Provider
+}
+
+func (p *TPForm) LoginForm() bool { return true }
+
+func TestLoginHandler(t *testing.T) {
+	setup()
+	defer teardown()
+	defer delete(Icons, "example9")
+	Register("example9", &TPComplete{dev.Provider{Name: "Example"}})
+	Register("example10", &TPForm{})
+	Icons["example9"] = "/static/example.svg"
+
+	infos := make(map[string]*ProviderInfo)
+	for _, p := range ListProviders(nil) {
+		infos[p.Key] = p
+	}
+	if p := infos["example9"]; p == nil || p.Name != "Example" || p.StartURL != "/-/auth/example9" ||
+		p.Icon != "/static/example.svg" || p.Form {
+		t.Errorf(`ListProviders: %+v, want example9`, p)
+	}
+	if p := infos["example10"]; p == nil || p.Name != "example10" || !p.Form {
+		t.Errorf(`ListProviders: %+v, want the example10 form`, p)
+	}
+	reply := new(Reply)
+	r, _ := http.NewRequest("POST", "http://localhost:8080/-/rpc", nil)
+	if err := new(Service).Providers(nil, r, new(Args), reply); err != nil || len(reply.Providers) != len(infos) {
+		t.Errorf(`Providers: %v, %v, want the providers`, reply.Providers, err)
+	}
+
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/login?error=login_failed&next=/account%3Ftab%3D1", nil)
+	w := httptest.NewRecorder()
+	LoginHandler(w, r)
+	b := w.Body.String()
+	if h := w.Header().Get("X-Frame-Options"); h != "DENY" {
+		t.Errorf(`X-Frame-Options: %v, want DENY`, h)
+	}
+	for _, want := range []string{
+		`href="/-/auth/example9?next=%2faccount%3ftab%3d1"`,
+		`<img src="/static/example.svg"`,
+		`action="/-/auth/example10"`,
+		`name="next" value="/account?tab=1"`,
+		LoginErrors["login_failed"],
+	} {
+		if !strings.Contains(b, want) {
+			t.Errorf(`body: %v, want %v`, b, want)
+		}
+	}
+
+	// Unknown errors and other sites are not shown.
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/login?error=<b>&next=//evil.example.com", nil)
+	w = httptest.NewRecorder()
+	LoginHandler(w, r)
+	if b = w.Body.String(); strings.Contains(b, "evil") || strings.Contains(b, `role="alert"`) {
+		t.Errorf(`body: %v, want neither the error nor next`, b)
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/config loads the configuration of auth and its providers,
+i.e. their client IDs, secrets, scopes and URLs, from environment
+variables, a JSON or YAML file, or an "AuthConfig" datastore entity of
+auth/config/datastore, and registers the providers. A Loader merges its
+Sources in order, so that e.g. the environment can override the secrets
+of a file:
+
+  var loader = config.NewLoader(config.File("auth.yaml"), config.Env("AUTH_"),
+    datastore.Source{})
+
+  func init() {
+    if _, err := loader.Load(nil); err != nil {
+      panic(err)
+    }
+    // Load the datastore Source, which needs a request, and reload the
+    // configuration without a redeploy, e.g. from a cron job.
+    http.Handle("/_ah/warmup", loader)
+    http.Handle("/-/auth/config/reload", auth.RequireRole("admin", loader))
+  }
+
+A reload only updates the instance that serves it. For every instance
+to pick up a saved Config, set the Loader's Interval and serve the app
+through its Handler:
+
+  loader.Interval = time.Minute
+  http.Handle("/", loader.Handler(app))
+
+with auth.yaml:
+
+  successURL: /home
+  providers:
+    google:
+      clientId: 1234.apps.googleusercontent.com
+      scope: openid email profile
+      hostedDomains: [example.com]
+    github:
+      clientId: abcd
+      scope: "user:email read:org"
+      orgs: [example]
+
+and the secrets in the environment, e.g. AUTH_GOOGLE_CLIENT_SECRET and
+AUTH_GITHUB_CLIENT_SECRET.
+
+Behind a proxy or load balancer, set the public origin of the redirect
+URIs, see auth/origin:
+
+  publicURL: https://example.com
+
+or, with tenants on their own hosts, trust the proxy's Forwarded headers
+with trustProxy: true.
+
+The Tenants of auth/tenant, e.g. customer-branded hostnames, have their
+own hosts, URLs and providers:
+
+  tenants:
+    acme:
+      hosts: [login.acme.com]
+      successURL: /dashboard
+      providers:
+        google:
+          clientId: 5678.apps.googleusercontent.com
+*/
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/apple"
+	"github.com/gaego/auth/dev"
+	"github.com/gaego/auth/facebook"
+	"github.com/gaego/auth/github"
+	"github.com/gaego/auth/google"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/origin"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/twitter"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidURL       = errors.New("auth/config: the URLs must be absolute paths, e.g. /-/auth/")
+	ErrInvalidPublicURL = errors.New("auth/config: the publicURL must be an http or https URL without a query, e.g. https://example.com")
+)
+
+// Config is the configuration of auth and its providers. Empty fields
+// keep the defaults of auth.
+type Config struct {
+	// PublicURL is the public origin of the app, see origin.URL, e.g.
+	// "https://example.com". TrustProxy sets origin.TrustProxy.
+	PublicURL  string `json:"publicURL,omitempty" yaml:"publicURL,omitempty"`
+	TrustProxy bool   `json:"trustProxy,omitempty" yaml:"trustProxy,omitempty"`
+	BaseURL    string `json:"baseURL,omitempty" yaml:"baseURL,omitempty"`
+	LoginURL   string `json:"loginURL,omitempty" yaml:"loginURL,omitempty"`
+	LogoutURL  string `json:"logoutURL,omitempty" yaml:"logoutURL,omitempty"`
+	SuccessURL string `json:"successURL,omitempty" yaml:"successURL,omitempty"`
+	// Providers are registered under their keys, e.g. "google" at
+	// /-/auth/google.
+	Providers map[string]*Provider `json:"providers,omitempty" yaml:"providers,omitempty"`
+	// Tenants are the Tenants of auth/tenant by their names. They are
+	// chosen by the Host header of the request.
+	Tenants map[string]*Tenant `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+}
+
+// Tenant is the configuration of a Tenant, e.g. of a customer-branded
+// hostname.
+type Tenant struct {
+	// Hosts are the Host headers of the Tenant's requests, e.g.
+	// "login.acme.com".
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	// Namespace is the datastore namespace. It defaults to the name.
+	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	LoginURL   string `json:"loginURL,omitempty" yaml:"loginURL,omitempty"`
+	SuccessURL string `json:"successURL,omitempty" yaml:"successURL,omitempty"`
+	// Providers are the Tenant's own providers; those of the Config are
+	// not available to it.
+	Providers map[string]*Provider `json:"providers,omitempty" yaml:"providers,omitempty"`
+}
+
+// Provider is the configuration of a provider.
+type Provider struct {
+	// Type is the provider's package, e.g. "google", "github",
+	// "facebook", "apple", "twitter" or "dev". It defaults to the key.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// ClientID and ClientSecret are the consumer key and secret of
+	// Twitter.
+	ClientID     string `json:"clientId,omitempty" yaml:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty" yaml:"clientSecret,omitempty"`
+	Scope        string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	// AuthURL and TokenURL replace the provider's endpoints, e.g. for
+	// GitHub Enterprise.
+	AuthURL      string   `json:"authURL,omitempty" yaml:"authURL,omitempty"`
+	TokenURL     string   `json:"tokenURL,omitempty" yaml:"tokenURL,omitempty"`
+	EmailDomains []string `json:"emailDomains,omitempty" yaml:"emailDomains,omitempty"`
+	// HostedDomains, Audiences and OpenIDRealm are those of Google.
+	HostedDomains []string `json:"hostedDomains,omitempty" yaml:"hostedDomains,omitempty"`
+	Audiences     []string `json:"audiences,omitempty" yaml:"audiences,omitempty"`
+	OpenIDRealm   string   `json:"openIDRealm,omitempty" yaml:"openIDRealm,omitempty"`
+	// Orgs and Teams are those of GitHub.
+	Orgs  []string `json:"orgs,omitempty" yaml:"orgs,omitempty"`
+	Teams []string `json:"teams,omitempty" yaml:"teams,omitempty"`
+	// TeamID, KeyID and PrivateKey, the PEM encoded .p8 key, are those of
+	// Apple, which has no ClientSecret.
+	TeamID     string `json:"teamId,omitempty" yaml:"teamId,omitempty"`
+	KeyID      string `json:"keyId,omitempty" yaml:"keyId,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
+}
+
+// field is a setting of a Provider and the suffix of its environment
+// variable, e.g. CLIENT_ID. Lists are separated by commas.
+type field struct {
+	env string
+	v   interface{} // *string or *[]string
+}
+
+func (p *Provider) fields() []field {
+	return []field{
+		{"TYPE", &p.Type},
+		{"CLIENT_ID", &p.ClientID},
+		{"CLIENT_SECRET", &p.ClientSecret},
+		{"SCOPE", &p.Scope},
+		{"AUTH_URL", &p.AuthURL},
+		{"TOKEN_URL", &p.TokenURL},
+		{"EMAIL_DOMAINS", &p.EmailDomains},
+		{"HOSTED_DOMAINS", &p.HostedDomains},
+		{"AUDIENCES", &p.Audiences},
+		{"OPENID_REALM", &p.OpenIDRealm},
+		{"ORGS", &p.Orgs},
+		{"TEAMS", &p.Teams},
+		{"TEAM_ID", &p.TeamID},
+		{"KEY_ID", &p.KeyID},
+		{"PRIVATE_KEY", &p.PrivateKey},
+	}
+}
+
+// merge sets the fields of c that are set in o.
+func (c *Config) merge(o *Config) {
+	set(&c.PublicURL, o.PublicURL)
+	if o.TrustProxy {
+		c.TrustProxy = true
+	}
+	set(&c.BaseURL, o.BaseURL)
+	set(&c.LoginURL, o.LoginURL)
+	set(&c.LogoutURL, o.LogoutURL)
+	set(&c.SuccessURL, o.SuccessURL)
+	mergeProviders(&c.Providers, o.Providers)
+	for name, ot := range o.Tenants {
+		if ot == nil {
+			continue
+		}
+		if c.Tenants == nil {
+			c.Tenants = make(map[string]*Tenant)
+		}
+		t := c.Tenants[name]
+		if t == nil {
+			t = new(Tenant)
+			c.Tenants[name] = t
+		}
+		if len(ot.Hosts) > 0 {
+			t.Hosts = ot.Hosts
+		}
+		set(&t.Namespace, ot.Namespace)
+		set(&t.LoginURL, ot.LoginURL)
+		set(&t.SuccessURL, ot.SuccessURL)
+		mergeProviders(&t.Providers, ot.Providers)
+	}
+}
+
+// mergeProviders sets the fields of the providers of ps that are set in
+// o.
+func mergeProviders(ps *map[string]*Provider, o map[string]*Provider) {
+	for k, op := range o {
+		if op == nil {
+			continue
+		}
+		if *ps == nil {
+			*ps = make(map[string]*Provider)
+		}
+		p := (*ps)[k]
+		if p == nil {
+			p = new(Provider)
+			(*ps)[k] = p
+		}
+		fs, ofs := p.fields(), op.fields()
+		for i, f := range fs {
+			switch v := f.v.(type) {
+			case *string:
+				set(v, *ofs[i].v.(*string))
+			case *[]string:
+				if ov := *ofs[i].v.(*[]string); len(ov) > 0 {
+					*v = ov
+				}
+			}
+		}
+	}
+}
+
+func set(v *string, s string) {
+	if s != "" {
+		*v = s
+	}
+}
+
+// Validate returns an error for the first invalid setting.
+func (c *Config) Validate() error {
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" ||
+			u.RawQuery != "" || u.Fragment != "" {
+			return ErrInvalidPublicURL
+		}
+	}
+	for _, u := range []string{c.BaseURL, c.LoginURL, c.LogoutURL, c.SuccessURL} {
+		if u != "" && !strings.HasPrefix(u, "/") {
+			return ErrInvalidURL
+		}
+	}
+	if c.BaseURL != "" && !strings.HasSuffix(c.BaseURL, "/") {
+		return ErrInvalidURL
+	}
+	if _, err := newProviders(c.Providers); err != nil {
+		return err
+	}
+	hosts := make(map[string]string)
+	for _, name := range c.tenantNames() {
+		t := c.Tenants[name]
+		tt := &tenant.Tenant{Name: name, Namespace: t.Namespace}
+		if err := tt.Validate(); err != nil {
+			return fmt.Errorf("auth/config: tenant %q: %v", name, err)
+		}
+		for _, u := range []string{t.LoginURL, t.SuccessURL} {
+			if u != "" && !strings.HasPrefix(u, "/") {
+				return ErrInvalidURL
+			}
+		}
+		if len(t.Hosts) == 0 {
+			return fmt.Errorf("auth/config: tenant %q: hosts are required", name)
+		}
+		for _, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if other, ok := hosts[h]; ok {
+				return fmt.Errorf("auth/config: host %q is used by tenants %q and %q", h, other, name)
+			}
+			hosts[h] = name
+		}
+		if _, err := newProviders(t.Providers); err != nil {
+			return fmt.Errorf("auth/config: tenant %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// keys returns the sorted keys of the providers.
+func keys(ps map[string]*Provider) []string {
+	var ks []string
+	for k := range ps {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// tenantNames returns the sorted names of the Tenants.
+func (c *Config) tenantNames() []string {
+	var ns []string
+	for n := range c.Tenants {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// Authenticater is implemented by the providers, see auth.Register.
+type Authenticater interface {
+	Authenticate(http.ResponseWriter, *http.Request) (*profile.Profile, string, error)
+}
+
+// New creates the provider of the key.
+func (p *Provider) New(key string) (Authenticater, error) {
+	typ := p.Type
+	if typ == "" {
+		typ = key
+	}
+	missing := func(name string) error {
+		return fmt.Errorf("auth/config: provider %q: %s is required", key, name)
+	}
+	if typ != "dev" && p.ClientID == "" {
+		return nil, missing("clientId")
+	}
+	if typ != "dev" && typ != "apple" && p.ClientSecret == "" {
+		return nil, missing("clientSecret")
+	}
+	var op *oauth2.Provider
+	var a Authenticater
+	switch typ {
+	case "google":
+		g := google.New(p.ClientID, p.ClientSecret, p.Scope)
+		g.HostedDomains = p.HostedDomains
+		g.Audiences = p.Audiences
+		g.OpenIDRealm = p.OpenIDRealm
+		op, a = &g.Provider, g
+	case "github":
+		g := github.New(p.ClientID, p.ClientSecret, p.Scope)
+		g.Orgs, g.Teams = p.Orgs, p.Teams
+		op, a = &g.Provider, g
+	case "facebook":
+		f := facebook.New(p.ClientID, p.ClientSecret, p.Scope)
+		op, a = &f.Provider, f
+	case "apple":
+		if p.TeamID == "" {
+			return nil, missing("teamId")
+		}
+		if p.KeyID == "" {
+			return nil, missing("keyId")
+		}
+		pk, err := jwt.ParseECPrivateKey([]byte(p.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("auth/config: provider %q: privateKey: %v", key, err)
+		}
+		ap := apple.New(p.ClientID, p.TeamID, p.KeyID, pk, p.Scope)
+		op, a = &ap.Provider, ap
+	case "twitter":
+		a = twitter.New(p.ClientID, p.ClientSecret)
+	case "dev":
+		a = dev.New()
+	default:
+		return nil, fmt.Errorf("auth/config: provider %q: unknown type %q", key, typ)
+	}
+	if op != nil {
+		set(&op.AuthURL, p.AuthURL)
+		set(&op.TokenURL, p.TokenURL)
+		op.EmailDomains = p.EmailDomains
+	}
+	return a, nil
+}
+
+// newProviders creates the providers by their keys.
+func newProviders(ps map[string]*Provider) (map[string]Authenticater, error) {
+	as := make(map[string]Authenticater)
+	for _, k := range keys(ps) {
+		a, err := ps[k].New(k)
+		if err != nil {
+			return nil, err
+		}
+		as[k] = a
+	}
+	return as, nil
+}
+
+// apply sets the URLs of auth, and registers the providers and the
+// Tenants. The URLs that aren't configured are those of defaults. The
+// BaseURL is only set without an old Config, i.e. on the first load, and
+// the providers of old that are no longer configured are unregistered.
+func (c *Config) apply(old, defaults *Config) error {
+	ps, err := newProviders(c.Providers)
+	if err != nil {
+		return err
+	}
+	ts := make(map[string]*tenant.Tenant)
+	tps := make(map[string]map[string]Authenticater)
+	hosts := make(map[string]*tenant.Tenant)
+	for _, name := range c.tenantNames() {
+		t := c.Tenants[name]
+		if tps[name], err = newProviders(t.Providers); err != nil {
+			return err
+		}
+		ts[name] = &tenant.Tenant{
+			Name:       name,
+			Namespace:  t.Namespace,
+			LoginURL:   t.LoginURL,
+			SuccessURL: t.SuccessURL,
+		}
+		for _, h := range t.Hosts {
+			hosts[h] = ts[name]
+		}
+	}
+	if old == nil {
+		set(&auth.BaseURL, c.BaseURL)
+	}
+	// Requests are served while the Config is reloaded, so the settings
+	// are swapped atomically rather than assigned.
+	origin.Set(c.PublicURL, c.TrustProxy)
+	login, logout, success := defaults.LoginURL, defaults.LogoutURL, defaults.SuccessURL
+	set(&login, c.LoginURL)
+	set(&logout, c.LogoutURL)
+	set(&success, c.SuccessURL)
+	auth.SetURLs(login, logout, success)
+	for k, a := range ps {
+		auth.Register(k, a)
+	}
+	for name, as := range tps {
+		for k, a := range as {
+			auth.RegisterTenant(ts[name], k, a)
+		}
+	}
+	if len(ts) > 0 {
+		tenant.SetResolver(tenant.ByHost(hosts))
+	}
+	if old == nil {
+		return nil
+	}
+	for k := range old.Providers {
+		if _, ok := ps[k]; !ok {
+			auth.Unregister(k)
+		}
+	}
+	for name, ot := range old.Tenants {
+		for k := range ot.Providers {
+			if _, ok := tps[name][k]; !ok {
+				auth.UnregisterTenant(&tenant.Tenant{Name: name}, k)
+			}
+		}
+	}
+	if len(ts) == 0 && len(old.Tenants) > 0 {
+		tenant.SetResolver(nil)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/github"
+	"github.com/gaego/auth/google"
+	"github.com/gaego/auth/tenant"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, data string) File {
+	f := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(f, []byte(data), 0600); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	return File(f)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	defer os.RemoveAll(dir)
+
+	y := writeFile(t, dir, "auth.yaml", `
+successURL: /home
+providers:
+  google:
+    clientId: "1234"
+    hostedDomains: [example.com]
+`)
+	j := writeFile(t, dir, "auth.json", `{"successURL": "/home",
+		"providers": {"google": {"clientId": "1234", "hostedDomains": ["example.com"]}}}`)
+	for _, f := range []File{y, j} {
+		c, err := f.Load(nil)
+		if err != nil {
+			t.Fatalf(`%v: err: %v, want nil`, f, err)
+		}
+		if c.SuccessURL != "/home" {
+			t.Errorf(`%v: c.SuccessURL: %v, want "/home"`, f, c.SuccessURL)
+		}
+		p := c.Providers["google"]
+		if p == nil || p.ClientID != "1234" || len(p.HostedDomains) != 1 {
+			t.Errorf(`%v: c.Providers["google"]: %+v, want clientId and hostedDomains`, f, p)
+		}
+	}
+
+	// Typos are errors.
+	bad := writeFile(t, dir, "bad.yaml", "providers:\n  google:\n    clientID: 1234\n")
+	if _, err = bad.Load(nil); err == nil {
+		t.Errorf(`err: nil, want an error`)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"TEST_AUTH_LOGIN_URL":              "/login",
+		"TEST_AUTH_PROVIDERS":              "github",
+		"TEST_AUTH_GITHUB_CLIENT_ID":       "abcd",
+		"TEST_AUTH_GITHUB_CLIENT_SECRET":   "secret",
+		"TEST_AUTH_GITHUB_TEAMS":           "example/ops, example/engineering",
+		"TEST_AUTH_GOOGLE_CLIENT_SECRET":   "secret2",
+		"TEST_AUTH_FACEBOOK_CLIENT_SECRET": "secret3",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	base := &Config{Providers: map[string]*Provider{
+		"google": {ClientID: "1234"},
+	}}
+	c := Env("TEST_AUTH_").load(base)
+	if c.LoginURL != "/login" {
+		t.Errorf(`c.LoginURL: %v, want "/login"`, c.LoginURL)
+	}
+	if x := c.Providers["github"].Teams; len(x) != 2 || x[1] != "example/engineering" {
+		t.Errorf(`Teams: %v, want [example/ops example/engineering]`, x)
+	}
+	// Only the listed providers and those of the other Sources.
+	if _, ok := c.Providers["facebook"]; ok {
+		t.Errorf(`c.Providers["facebook"] is set, want it unset`)
+	}
+	base.merge(c)
+	if p := base.Providers["google"]; p.ClientID != "1234" || p.ClientSecret != "secret2" {
+		t.Errorf(`c.Providers["google"]: %+v, want the clientId and the secret`, p)
+	}
+
+	// The secrets of the Tenants' providers.
+	os.Setenv("TEST_AUTH_ACME_CORP_GOOGLE_CLIENT_SECRET", "secret4")
+	defer os.Unsetenv("TEST_AUTH_ACME_CORP_GOOGLE_CLIENT_SECRET")
+	base.Tenants = map[string]*Tenant{"acme-corp": {
+		Hosts:     []string{"login.acme.com"},
+		Providers: map[string]*Provider{"google": {ClientID: "5678"}},
+	}}
+	base.merge(Env("TEST_AUTH_").load(base))
+	tp := base.Tenants["acme-corp"]
+	if p := tp.Providers["google"]; p.ClientID != "5678" || p.ClientSecret != "secret4" {
+		t.Errorf(`Providers["google"]: %+v, want the clientId and the secret`, p)
+	}
+	if len(tp.Hosts) != 1 {
+		t.Errorf(`Hosts: %v, want [login.acme.com]`, tp.Hosts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		c  *Config
+		ok bool
+	}{
+		{&Config{LoginURL: "/login"}, true},
+		{&Config{LoginURL: "http://example.com/login"}, false},
+		{&Config{BaseURL: "/auth"}, false},
+		{&Config{PublicURL: "https://example.com/app"}, true},
+		{&Config{PublicURL: "example.com"}, false},
+		{&Config{PublicURL: "https://example.com/?a=b"}, false},
+		{&Config{Providers: map[string]*Provider{"dev": {}}}, true},
+		{&Config{Providers: map[string]*Provider{"google": {ClientID: "1234"}}}, false},
+		{&Config{Providers: map[string]*Provider{"google": {ClientID: "1234", ClientSecret: "s"}}}, true},
+		{&Config{Providers: map[string]*Provider{"corp": {Type: "google", ClientID: "1234", ClientSecret: "s"}}}, true},
+		{&Config{Providers: map[string]*Provider{"corp": {ClientID: "1234", ClientSecret: "s"}}}, false},
+		{&Config{Providers: map[string]*Provider{"apple": {ClientID: "com.example", TeamID: "T"}}}, false},
+		{&Config{Tenants: map[string]*Tenant{"acme": {Hosts: []string{"login.acme.com"}}}}, true},
+		{&Config{Tenants: map[string]*Tenant{"acme": {}}}, false},
+		{&Config{Tenants: map[string]*Tenant{"Acme Corp": {Hosts: []string{"login.acme.com"}}}}, false},
+		{&Config{Tenants: map[string]*Tenant{
+			"acme":   {Hosts: []string{"login.acme.com"}},
+			"acme-2": {Hosts: []string{"LOGIN.acme.com"}},
+		}}, false},
+		{&Config{Tenants: map[string]*Tenant{"acme": {Hosts: []string{"login.acme.com"},
+			Providers: map[string]*Provider{"google": {ClientID: "1234"}}}}}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.c.Validate(); (err == nil) != tt.ok {
+			t.Errorf(`%d: err: %v, want ok %v`, i, err, tt.ok)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	p := &Provider{ClientID: "1234", ClientSecret: "s", Scope: "openid",
+		HostedDomains: []string{"example.com"}, EmailDomains: []string{"example.com"}}
+	a, err := p.New("google")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	g, ok := a.(*google.Provider)
+	if !ok {
+		t.Fatalf(`a: %T, want *google.Provider`, a)
+	}
+	if len(g.HostedDomains) != 1 || len(g.EmailDomains) != 1 || g.ClientID != "1234" {
+		t.Errorf(`g: %+v, want the settings`, g)
+	}
+	p = &Provider{ClientID: "1234", ClientSecret: "s", Orgs: []string{"example"},
+		AuthURL: "https://github.example.com/login/oauth/authorize"}
+	a, err = p.New("github")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if gh := a.(*github.Provider); gh.AuthURL != p.AuthURL || gh.Orgs[0] != "example" {
+		t.Errorf(`gh: %+v, want the settings`, gh)
+	}
+}
+
+type source struct{ c *Config }
+
+func (s *source) Load(r *http.Request) (*Config, error) { return s.c, nil }
+
+func TestLoader(t *testing.T) {
+	s := &source{&Config{SuccessURL: "/home", Providers: map[string]*Provider{
+		"google": {ClientID: "1234", ClientSecret: "secret"},
+	}}}
+	l := NewLoader(s)
+	defer auth.SetURLs(auth.URLs())
+	if _, err := l.Load(nil); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if _, _, x := auth.URLs(); x != "/home" {
+		t.Errorf(`SuccessURL: %v, want "/home"`, x)
+	}
+	if _, ok := l.Config().Providers["google"]; !ok {
+		t.Errorf(`l.Config().Providers["google"] is unset, want it set`)
+	}
+
+	// An invalid Config keeps the previous one.
+	s.c = &Config{Providers: map[string]*Provider{"google": {}}}
+	if _, err := l.Load(nil); err == nil {
+		t.Errorf(`err: nil, want an error`)
+	}
+	if l.Config().SuccessURL != "/home" {
+		t.Errorf(`l.Config().SuccessURL: %v, want "/home"`, l.Config().SuccessURL)
+	}
+	s.c = &Config{BaseURL: "/auth/"}
+	if _, err := l.Load(nil); err == nil {
+		t.Errorf(`err: nil, want an error`)
+	}
+
+	// Tenants.
+	s.c = &Config{Tenants: map[string]*Tenant{"acme": {
+		Hosts:      []string{"login.acme.com"},
+		SuccessURL: "/dashboard",
+	}}}
+	if _, err := l.Load(nil); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	r, _ := http.NewRequest("GET", "http://login.acme.com/", nil)
+	if x := tenant.Current(r); x == nil || x.Name != "acme" || x.SuccessURL != "/dashboard" {
+		t.Errorf(`tenant.Current: %+v, want acme`, x)
+	}
+	// The SuccessURL is no longer configured.
+	if _, _, x := auth.URLs(); x != "/" {
+		t.Errorf(`SuccessURL: %v, want the default "/"`, x)
+	}
+
+	// Reloading without the provider removes it.
+	s.c = &Config{}
+	w := httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://localhost:8080/-/auth/config/reload", nil)
+	l.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusNoContent)
+	}
+	r, _ = http.NewRequest("GET", "http://login.acme.com/", nil)
+	if x := tenant.Current(r); x != nil {
+		t.Errorf(`tenant.Current: %+v, want nil`, x)
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google", nil)
+	http.DefaultServeMux.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusNotFound)
+	}
+}
+
+func TestLoaderHandler(t *testing.T) {
+	s := &source{&Config{SuccessURL: "/home"}}
+	l := NewLoader(s)
+	defer auth.SetURLs(auth.URLs())
+	if _, err := l.Load(nil); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() {
+		r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// Without an Interval the Config is kept.
+	s.c = &Config{SuccessURL: "/dashboard"}
+	serve()
+	if _, _, x := auth.URLs(); x != "/home" {
+		t.Errorf(`SuccessURL: %v, want "/home"`, x)
+	}
+
+	// Another instance saved a new Config.
+	l.Interval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	serve()
+	if _, _, x := auth.URLs(); x != "/dashboard" {
+		t.Errorf(`SuccessURL: %v, want "/dashboard"`, x)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	c := new(Config)
+	if err := DecodeJSON([]byte(`{"successURL": "/home"}`), c); err != nil || c.SuccessURL != "/home" {
+		t.Errorf(`SuccessURL: %v, %v, want "/home"`, c.SuccessURL, err)
+	}
+	if err := DecodeJSON([]byte(`{"sucessURL": "/home"}`), c); err == nil {
+		t.Errorf(`err: nil, want an error for the misspelled setting`)
+	}
+}
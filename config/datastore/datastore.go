@@ -0,0 +1,59 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/config/datastore provides a Source of auth/config that
+loads the Config from the App Engine datastore:
+
+  var loader = config.NewLoader(config.File("auth.yaml"), config.Env("AUTH_"),
+    datastore.Source{})
+*/
+package datastore
+
+import (
+	"appengine/datastore"
+	"encoding/json"
+	"github.com/gaego/auth/config"
+	"github.com/gaego/context"
+	"net/http"
+)
+
+// Source loads the Config from the "AuthConfig" entity with the key
+// "auth", whose Config property is the Config as JSON. It is skipped
+// when loading without a request.
+type Source struct{}
+
+// entity is the "AuthConfig" datastore entity.
+type entity struct {
+	Config []byte `datastore:",noindex"`
+}
+
+func (Source) Load(r *http.Request) (*config.Config, error) {
+	cfg := new(config.Config)
+	if r == nil {
+		return cfg, nil
+	}
+	c := context.NewContext(r)
+	e := new(entity)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthConfig", "auth", 0, nil), e)
+	if err == datastore.ErrNoSuchEntity {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg, config.DecodeJSON(e.Config, cfg)
+}
+
+// Save saves the Config, to be loaded at the next reload of each
+// instance, see config.Loader.Interval.
+func (Source) Save(r *http.Request, cfg *config.Config) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	c := context.NewContext(r)
+	_, err = datastore.Put(c, datastore.NewKey(c, "AuthConfig", "auth", 0, nil), &entity{b})
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/logging"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Loader loads a Config from its Sources and applies it to auth.
+type Loader struct {
+	// Sources are merged in order; the settings of later Sources
+	// override those of earlier ones.
+	Sources []Source
+	// Interval is the age of the Config after which Handler reloads it,
+	// so that a Config saved to the datastore Source, or reloaded by
+	// ServeHTTP on one instance, reaches every instance. Zero never
+	// reloads.
+	Interval time.Duration
+
+	mu       sync.Mutex
+	cfg      *Config
+	defaults *Config // the URLs of auth before the first Load
+	loaded   time.Time
+	loading  bool
+}
+
+// NewLoader creates a Loader of the Sources.
+func NewLoader(sources ...Source) *Loader {
+	return &Loader{Sources: sources}
+}
+
+// Load loads and validates the Config, sets the URLs of auth and
+// registers the providers. Providers that are no longer configured are
+// unregistered, and URLs that are no longer configured return to those
+// of auth before the first Load. The BaseURL is only set by the first Load, before the
+// providers' URLs are registered. If the Config is invalid, the
+// previous one stays in effect. The request may be nil, e.g. in init.
+func (l *Loader) Load(r *http.Request) (*Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg := new(Config)
+	for _, s := range l.Sources {
+		var c *Config
+		var err error
+		if e, ok := s.(Env); ok {
+			// The environment may set the secrets of the other
+			// Sources' providers.
+			c = e.load(cfg)
+		} else if c, err = s.Load(r); err != nil {
+			return nil, err
+		}
+		cfg.merge(c)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if l.cfg != nil && cfg.BaseURL != l.cfg.BaseURL {
+		return nil, fmt.Errorf("auth/config: the BaseURL can't be changed from %q to %q without a restart",
+			l.cfg.BaseURL, cfg.BaseURL)
+	}
+	if l.defaults == nil {
+		l.defaults = new(Config)
+		l.defaults.LoginURL, l.defaults.LogoutURL, l.defaults.SuccessURL = auth.URLs()
+	}
+	if err := cfg.apply(l.cfg, l.defaults); err != nil {
+		return nil, err
+	}
+	l.cfg = cfg
+	l.loaded = time.Now()
+	return cfg, nil
+}
+
+// Handler returns a Handler that reloads the Config before serving h
+// when it is older than Interval. One request reloads it while the
+// others are served with the current Config. A failed reload is logged
+// and retried after Interval.
+func (l *Loader) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.stale() {
+			_, err := l.Load(r)
+			l.mu.Lock()
+			l.loading = false
+			if err != nil {
+				l.loaded = time.Now()
+			}
+			l.mu.Unlock()
+			if err != nil {
+				logging.NewLogger(r).Errorf("auth/config: %v", err)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// stale reports whether the Config is older than Interval and no other
+// request is reloading it, in which case the caller must reload it.
+func (l *Loader) stale() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Interval <= 0 || l.loading || time.Since(l.loaded) < l.Interval {
+		return false
+	}
+	l.loading = true
+	return true
+}
+
+// Config returns the last loaded Config, or nil.
+func (l *Loader) Config() *Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// ServeHTTP reloads the Config, e.g. for the warmup request or a cron
+// job. It should only be reachable by admins.
+func (l *Loader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := l.Load(r); err != nil {
+		logging.NewLogger(r).Errorf("auth/config: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Source is implemented by the types that a Config is loaded from.
+type Source interface {
+	// Load returns the Config. The request is nil when the Config is
+	// loaded outside of a request, e.g. in init.
+	Load(r *http.Request) (*Config, error)
+}
+
+// Env loads the Config from the environment variables with the prefix,
+// e.g. "AUTH_":
+//
+//   AUTH_PUBLIC_URL=https://example.com
+//   AUTH_SUCCESS_URL=/home
+//   AUTH_PROVIDERS=google,github
+//   AUTH_GOOGLE_CLIENT_ID=1234.apps.googleusercontent.com
+//   AUTH_GOOGLE_CLIENT_SECRET=...
+//   AUTH_GOOGLE_HOSTED_DOMAINS=example.com,example.org
+//
+// The providers are those listed in AUTH_PROVIDERS, and those of the
+// other Sources. The variables of a provider are those of its key in
+// upper case, e.g. AUTH_GOOGLE_, followed by the settings of Provider,
+// e.g. CLIENT_ID, TEAM_ID or EMAIL_DOMAINS. Lists are separated by
+// commas. The providers of the Tenants of the other Sources are
+// prefixed with the Tenant's name, e.g. AUTH_ACME_GOOGLE_CLIENT_SECRET.
+type Env string
+
+func (e Env) Load(r *http.Request) (*Config, error) {
+	return e.load(nil), nil
+}
+
+// load returns the Config of the environment for the providers in
+// PROVIDERS and, to override their settings, those of base.
+func (e Env) load(base *Config) *Config {
+	prefix := string(e)
+	trust, _ := strconv.ParseBool(os.Getenv(prefix + "TRUST_PROXY"))
+	c := &Config{
+		PublicURL:  os.Getenv(prefix + "PUBLIC_URL"),
+		TrustProxy: trust,
+		BaseURL:    os.Getenv(prefix + "BASE_URL"),
+		LoginURL:   os.Getenv(prefix + "LOGIN_URL"),
+		LogoutURL:  os.Getenv(prefix + "LOGOUT_URL"),
+		SuccessURL: os.Getenv(prefix + "SUCCESS_URL"),
+	}
+	ks := split(os.Getenv(prefix + "PROVIDERS"))
+	if base != nil {
+		ks = append(ks, keys(base.Providers)...)
+	}
+	c.Providers = loadProviders(prefix, ks)
+	if base == nil {
+		return c
+	}
+	for _, name := range base.tenantNames() {
+		tp := prefix + envName(name) + "_"
+		if c.Tenants == nil {
+			c.Tenants = make(map[string]*Tenant)
+		}
+		c.Tenants[name] = &Tenant{
+			Providers: loadProviders(tp, keys(base.Tenants[name].Providers)),
+		}
+	}
+	return c
+}
+
+// loadProviders returns the providers of the keys from the variables
+// with the prefix.
+func loadProviders(prefix string, ks []string) map[string]*Provider {
+	var ps map[string]*Provider
+	for _, k := range ks {
+		p := new(Provider)
+		for _, f := range p.fields() {
+			v := os.Getenv(prefix + envName(k) + "_" + f.env)
+			switch f := f.v.(type) {
+			case *string:
+				*f = v
+			case *[]string:
+				*f = split(v)
+			}
+		}
+		if ps == nil {
+			ps = make(map[string]*Provider)
+		}
+		ps[k] = p
+	}
+	return ps
+}
+
+// envName returns the name in an environment variable, e.g. ACME_CORP
+// for "acme-corp".
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// split splits a comma separated list.
+func split(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// File loads the Config from a JSON file or, if its name ends in .yaml
+// or .yml, a YAML file.
+type File string
+
+func (f File) Load(r *http.Request) (*Config, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	switch strings.ToLower(filepath.Ext(string(f))) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, c)
+	default:
+		err = DecodeJSON(b, c)
+	}
+	return c, err
+}
+
+// DecodeJSON decodes the Config from JSON, rejecting unknown settings,
+// e.g. misspelled ones.
+func DecodeJSON(b []byte, c *Config) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(c)
+}
@@ -23,6 +23,12 @@ func New() *Provider {
 	return &Provider{"Dev", "http://localhost:8080"}
 }
 
+// Describe returns the Name and URL of the Provider, for the login page
+// of auth.
+func (p *Provider) Describe() (name, url string) {
+	return p.Name, p.URL
+}
+
 // Authenticate process the request and returns a populated Profile.
 // If the Authenticate method can not authenticate the User based on the
 // request, an error or a redirect URL wll be return.
@@ -8,13 +8,26 @@ Package auth/facebook provides Facebook authentication
 package facebook
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gaego/auth/metrics"
 	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 )
 
 const (
-	CLIENT_ID     = "343417275669983"
-	CLIENT_SECRET = "fec59504f33b238a5d7b5f3b35bd958a"
-	PROFILE_URL   = "https://graph.facebook.com/me"
+	PROFILE_URL     = "https://graph.facebook.com/me"
+	DEBUG_TOKEN_URL = "https://graph.facebook.com/debug_token"
+)
+
+var (
+	ErrInvalidToken = errors.New("auth/facebook: the access token is invalid")
+	ErrInvalidApp   = errors.New("auth/facebook: the access token was issued to another app")
 )
 
 type Provider struct {
@@ -34,3 +47,138 @@ func New(clientID, clientSecret, scope string) *Provider {
 		},
 	}
 }
+
+// User represents the response of the Graph API /me endpoint.
+type User struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Link      string `json:"link"`
+}
+
+// Person converts the Facebook user to a Person.
+func (u *User) Person() *person.Person {
+	per := &person.Person{
+		ID:          u.ID,
+		DisplayName: u.Name,
+		Email:       u.Email,
+		URL:         u.Link,
+		Name: &person.PersonName{
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+	}
+	if u.Email != "" {
+		per.Emails = []*person.PersonEmails{
+			&person.PersonEmails{Primary: true, Type: "account", Value: u.Email},
+		}
+	}
+	return per
+}
+
+// Authenticate process the request and returns a populated Profile.
+// If the Authenticate method can not authenticate the User based on the
+// request, an error or a redirect URL wll be return.
+func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
+	up *profile.Profile, redirectURL string, err error) {
+
+	if !oauth2.IsCallback(r) {
+		return nil, p.Start(w, r), nil
+	}
+	t, err := p.Callback(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	up, err = p.profile(r, t.AccessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	// Facebook only returns verified email addresses.
+	if err = p.CheckEmail(up.Person.Email, true); err != nil {
+		return nil, "", err
+	}
+	return up, "", nil
+}
+
+// AuthenticateToken verifies the access token posted by a native app in
+// the "access_token" form value and returns a populated Profile. The
+// token must have been issued to this app.
+func (p *Provider) AuthenticateToken(w http.ResponseWriter, r *http.Request) (
+	*profile.Profile, error) {
+
+	token := r.FormValue("access_token")
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	var dt struct {
+		Data struct {
+			AppID   string `json:"app_id"`
+			IsValid bool   `json:"is_valid"`
+			UserID  string `json:"user_id"`
+		} `json:"data"`
+	}
+	v := url.Values{
+		"input_token":  {token},
+		"access_token": {p.ClientID + "|" + p.ClientSecret},
+	}
+	if _, err := p.get(r, DEBUG_TOKEN_URL+"?"+v.Encode(), &dt); err != nil {
+		return nil, err
+	}
+	if !dt.Data.IsValid {
+		return nil, ErrInvalidToken
+	}
+	if dt.Data.AppID != p.ClientID {
+		return nil, ErrInvalidApp
+	}
+	up, err := p.profile(r, token)
+	if err != nil {
+		return nil, err
+	}
+	if up.ID != dt.Data.UserID {
+		return nil, ErrInvalidToken
+	}
+	if err = p.CheckEmail(up.Person.Email, true); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// profile fetches the User with the access token and creates the
+// Profile.
+func (p *Provider) profile(r *http.Request, token string) (*profile.Profile, error) {
+	v := url.Values{
+		"fields":       {"id,name,first_name,last_name,email,link"},
+		"access_token": {token},
+	}
+	u := new(User)
+	m := metrics.Time(metrics.ProfileFetch, metrics.Provider(r, p.Name))
+	body, err := p.get(r, PROFILE_URL+"?"+v.Encode(), u)
+	m.Done(err)
+	if err != nil {
+		return nil, err
+	}
+	up := profile.New(p.Name, p.URL)
+	up.ID = u.ID
+	up.Person = u.Person()
+	up.PersonRawJSON = body
+	return up, nil
+}
+
+// get fetches a Graph API url and decodes the JSON response into v.
+func (p *Provider) get(r *http.Request, url string, v interface{}) ([]byte, error) {
+	res, err := p.Client(r).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth/facebook: graph API returned %s: %s", res.Status, body)
+	}
+	return body, json.Unmarshal(body, v)
+}
@@ -0,0 +1,99 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/fetch provides the HTTP clients providers use to call
+token, user info and key endpoints.
+
+Requests are made with the context of the incoming request, so they are
+cancelled with it and honour its deadline. The RoundTripper is
+http.DefaultTransport unless the provider sets its own Transport or
+NewTransport is replaced. On the first generation App Engine runtime
+import auth/fetch/urlfetch to use the URL Fetch service:
+
+  import _ "github.com/gaego/auth/fetch/urlfetch"
+
+Only these outbound requests use the context.Context of the request. The
+datastore operations and logs of the auth packages still use the App
+Engine context of auth/namespace.NewContext.
+*/
+package fetch
+
+import (
+	"context"
+	"github.com/gaego/auth/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+// NewTransport returns the RoundTripper used for requests made during r
+// by providers that do not set a Transport.
+var NewTransport = func(r *http.Request) http.RoundTripper {
+	return http.DefaultTransport
+}
+
+// Context returns the context of r, or a background context when there
+// is no request.
+func Context(r *http.Request) context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.Context()
+}
+
+// WithTimeout returns a copy of r whose context is cancelled after d.
+// The returned cancel func should be deferred by the caller. A zero d
+// returns r unchanged.
+func WithTimeout(r *http.Request, d time.Duration) (*http.Request, context.CancelFunc) {
+	if d <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(Context(r), d)
+	return r.WithContext(ctx), cancel
+}
+
+// Transport returns t, or NewTransport(r) when t is nil, wrapped so
+// that each request carries the context of r. Each request is traced as
+// a child of the span of r, and the trace context is propagated in its
+// headers.
+func Transport(r *http.Request, t http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		t = NewTransport(r)
+	}
+	return &contextTransport{ctx: Context(r), base: t}
+}
+
+// Client returns an *http.Client that uses Transport(r, t).
+func Client(r *http.Request, t http.RoundTripper) *http.Client {
+	return &http.Client{Transport: Transport(r, t)}
+}
+
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The query is left out of the span as it may hold tokens.
+	ctx, span := tracing.Tracer.Start(t.ctx, "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path),
+		))
+	req = req.WithContext(ctx)
+	// A RoundTripper must not modify the caller's request.
+	req.Header = req.Header.Clone()
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	res, err := t.base.RoundTrip(req)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	}
+	tracing.End(span, err)
+	return res, err
+}
@@ -0,0 +1,119 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fetch
+
+import (
+	"context"
+	"github.com/gaego/auth/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	n int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// Injected transport.
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/example", nil)
+	ct := &countingTransport{}
+	res, err := Client(r, ct).Get(srv.URL)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	res.Body.Close()
+	if ct.n != 1 {
+		t.Errorf(`ct.n: %v, want 1`, ct.n)
+	}
+
+	// Default transport.
+
+	if res, err = Client(nil, nil).Get(srv.URL); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	res.Body.Close()
+
+	// Cancelled request.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Client(r.WithContext(ctx), ct).Get(srv.URL); err == nil {
+		t.Errorf(`err: nil, want context canceled`)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/example", nil)
+	r2, cancel := WithTimeout(r, 0)
+	cancel()
+	if r2 != r {
+		t.Errorf(`WithTimeout(r, 0) should return r`)
+	}
+	r2, cancel = WithTimeout(r, time.Minute)
+	defer cancel()
+	if _, ok := r2.Context().Deadline(); !ok {
+		t.Errorf(`r2.Context() should have a deadline`)
+	}
+}
+
+func TestTransport_Trace(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	defer func(tr trace.Tracer) { tracing.Tracer = tr }(tracing.Tracer)
+	tracing.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)).Tracer("test")
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer srv.Close()
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r, span := tracing.Start(r, "auth.handler")
+	req, _ := http.NewRequest("GET", srv.URL+"/token?code=secret", nil)
+	if _, err := Client(r, nil).Do(req); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	span.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf(`len(spans): %v, want 2`, len(spans))
+	}
+	c := spans[0]
+	if c.SpanKind != trace.SpanKindClient || c.Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf(`span: %v, want a client span child of auth.handler`, c)
+	}
+	if traceparent == "" {
+		t.Errorf(`traceparent: "", want the trace context`)
+	}
+	if req.Header.Get("Traceparent") != "" {
+		t.Errorf(`req.Header was modified`)
+	}
+	for _, a := range c.Attributes {
+		if a.Key == "http.url" && a.Value.AsString() != srv.URL+"/token" {
+			t.Errorf(`http.url: %v, want %v`, a.Value.AsString(), srv.URL+"/token")
+		}
+	}
+}
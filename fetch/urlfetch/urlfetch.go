@@ -0,0 +1,24 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/fetch/urlfetch makes providers use the App Engine URL Fetch
+service. It is imported for its side effect:
+
+  import _ "github.com/gaego/auth/fetch/urlfetch"
+*/
+package urlfetch
+
+import (
+	"appengine/urlfetch"
+	"github.com/gaego/auth/fetch"
+	"github.com/gaego/context"
+	"net/http"
+)
+
+func init() {
+	fetch.NewTransport = func(r *http.Request) http.RoundTripper {
+		return &urlfetch.Transport{Context: context.NewContext(r)}
+	}
+}
@@ -4,20 +4,49 @@
 
 /*
 Package auth/github provides Github authentication
+
+With the "read:org" scope the Profiles have the "orgs" claim, the logins
+of the User's organizations, and the "teams" claim, the teams as
+"org/team-slug", for the Rules of auth/profile. The logins may also be
+restricted to the members of organizations or teams:
+
+  p := github.New(clientID, clientSecret, "user:email read:org")
+  p.Orgs = []string{"example"}
 */
 package github
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gaego/auth/metrics"
 	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
 const (
-	CLIENT_ID     = "dbac99a147b10e6bc813"
-	CLIENT_SECRET = "5f6e11429eeef14d0fe79721ee53459963e306f5"
+	API_URL = "https://api.github.com"
+	// perPage is the most items of a page of the API's lists.
+	perPage = 100
+)
+
+var (
+	ErrNotMember = errors.New("auth/github: the account is not a member of an allowed organization or team")
 )
 
 type Provider struct {
 	oauth2.Provider
+	// Orgs and Teams, if set, restrict the logins to the members of one
+	// of the organizations, e.g. "example", or teams, e.g.
+	// "example/engineering". They need the "read:org" scope. The
+	// EmailDomains of the Provider need the "user:email" scope.
+	Orgs  []string
+	Teams []string
 }
 
 func New(clientID, clientSecret, scope string) *Provider {
@@ -27,9 +56,205 @@ func New(clientID, clientSecret, scope string) *Provider {
 			URL:          "http://github.com",
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
-			Scope:        "",
+			Scope:        scope,
 			AuthURL:      "https://github.com/login/oauth/authorize",
 			TokenURL:     "https://github.com/login/oauth/access_token",
 		},
 	}
 }
+
+// User represents the response of the API's /user endpoint.
+type User struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+}
+
+// Person converts the GitHub user to a Person.
+func (u *User) Person() *person.Person {
+	per := &person.Person{
+		ID:          strconv.FormatInt(u.ID, 10),
+		DisplayName: u.Name,
+		Nickname:    u.Login,
+		Email:       u.Email,
+		URL:         u.HTMLURL,
+	}
+	if per.DisplayName == "" {
+		per.DisplayName = u.Login
+	}
+	if u.AvatarURL != "" {
+		per.Image = &person.PersonImage{URL: u.AvatarURL}
+	}
+	if u.Email != "" {
+		per.Emails = []*person.PersonEmails{
+			&person.PersonEmails{Primary: true, Type: "account", Value: u.Email},
+		}
+	}
+	return per
+}
+
+// Authenticate process the request and returns a populated Profile.
+// If the Authenticate method can not authenticate the User based on the
+// request, an error or a redirect URL wll be return.
+func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
+	up *profile.Profile, redirectURL string, err error) {
+
+	if !oauth2.IsCallback(r) {
+		return nil, p.Start(w, r), nil
+	}
+	t, err := p.Callback(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	up, err = p.profile(r, t.AccessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = p.allow(r, t.AccessToken, up); err != nil {
+		return nil, "", err
+	}
+	return up, "", nil
+}
+
+// allow returns an error unless the account of the Profile may log in.
+func (p *Provider) allow(r *http.Request, token string, up *profile.Profile) error {
+	if len(p.Orgs) > 0 || len(p.Teams) > 0 {
+		if !contains(up.Claim("orgs"), p.Orgs) && !contains(up.Claim("teams"), p.Teams) {
+			return ErrNotMember
+		}
+	}
+	if len(p.EmailDomains) == 0 {
+		return nil
+	}
+	// The public email address of the User may not be verified.
+	var emails []struct {
+		Email    string `json:"email"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.list(r, token, "/user/emails", &emails); err != nil {
+		return err
+	}
+	for _, e := range emails {
+		if p.CheckEmail(e.Email, e.Verified) == nil {
+			return nil
+		}
+	}
+	return oauth2.ErrEmailDomainNotAllowed
+}
+
+// contains reports whether any of the values is one of want.
+func contains(values, want []string) bool {
+	for _, v := range values {
+		for _, w := range want {
+			if strings.EqualFold(v, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasScope reports whether the Provider requests the scope.
+func (p *Provider) hasScope(scope string) bool {
+	for _, s := range strings.FieldsFunc(p.Scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	}) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// profile fetches the User, and with the "read:org" scope its
+// organizations and teams, with the access token and creates the
+// Profile.
+func (p *Provider) profile(r *http.Request, token string) (*profile.Profile, error) {
+	u := new(User)
+	m := metrics.Time(metrics.ProfileFetch, metrics.Provider(r, p.Name))
+	body, err := p.get(r, token, "/user", u)
+	m.Done(err)
+	if err != nil {
+		return nil, err
+	}
+	up := profile.New(p.Name, p.URL)
+	up.ID = strconv.FormatInt(u.ID, 10)
+	up.Person = u.Person()
+	up.PersonRawJSON = body
+	if !p.hasScope("read:org") && len(p.Orgs) == 0 && len(p.Teams) == 0 {
+		return up, nil
+	}
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err = p.list(r, token, "/user/orgs", &orgs); err != nil {
+		return nil, err
+	}
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err = p.list(r, token, "/user/teams", &teams); err != nil {
+		return nil, err
+	}
+	os := make([]string, 0, len(orgs))
+	for _, o := range orgs {
+		os = append(os, o.Login)
+	}
+	ts := make([]string, 0, len(teams))
+	for _, t := range teams {
+		ts = append(ts, t.Organization.Login+"/"+t.Slug)
+	}
+	up.SetClaim("orgs", os...)
+	up.SetClaim("teams", ts...)
+	return up, nil
+}
+
+// list fetches every page of a list of the API and decodes the items
+// into v, a pointer to a slice.
+func (p *Provider) list(r *http.Request, token, path string, v interface{}) error {
+	var items []json.RawMessage
+	for page := 1; ; page++ {
+		var ps []json.RawMessage
+		if _, err := p.get(r, token, fmt.Sprintf("%s?per_page=%d&page=%d", path, perPage, page), &ps); err != nil {
+			return err
+		}
+		items = append(items, ps...)
+		if len(ps) < perPage {
+			break
+		}
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// get fetches a path of the API and decodes the JSON response into v.
+func (p *Provider) get(r *http.Request, token, path string, v interface{}) ([]byte, error) {
+	req, err := http.NewRequest("GET", API_URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	res, err := p.Client(r).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth/github: API returned %s: %s", res.Status, body)
+	}
+	return body, json.Unmarshal(body, v)
+}
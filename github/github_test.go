@@ -0,0 +1,82 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package github
+
+import (
+	"fmt"
+	"github.com/gaego/auth/profile"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUserPerson(t *testing.T) {
+	u := &User{ID: 12345, Login: "octocat", Email: "octocat@example.org"}
+	per := u.Person()
+	if per.ID != "12345" {
+		t.Errorf(`per.ID: %v, want "12345"`, per.ID)
+	}
+	if per.DisplayName != "octocat" {
+		t.Errorf(`per.DisplayName: %v, want "octocat"`, per.DisplayName)
+	}
+	if x := per.Emails[0].Value; x != "octocat@example.org" {
+		t.Errorf(`per.Emails[0].Value: %v, want "octocat@example.org"`, x)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p := New("12345", "secret", "read:org")
+	up := profile.New(p.Name, p.URL)
+	up.SetClaim("orgs", "example")
+	up.SetClaim("teams", "example/ops")
+	if err := p.allow(nil, "", up); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	p.Orgs = []string{"other"}
+	if err := p.allow(nil, "", up); err != ErrNotMember {
+		t.Errorf(`err: %v, want %v`, err, ErrNotMember)
+	}
+	p.Teams = []string{"Example/ops"}
+	if err := p.allow(nil, "", up); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	p.Teams = []string{"example/engineering"}
+	if err := p.allow(nil, "", up); err != ErrNotMember {
+		t.Errorf(`err: %v, want %v`, err, ErrNotMember)
+	}
+}
+
+// pages serves lists of the API with n orgs, perPage per page.
+type pages int
+
+func (n pages) RoundTrip(req *http.Request) (*http.Response, error) {
+	var page int
+	fmt.Sscan(req.URL.Query().Get("page"), &page)
+	var items []string
+	for i := (page - 1) * perPage; i < int(n) && i < page*perPage; i++ {
+		items = append(items, fmt.Sprintf(`{"login": "org%d"}`, i))
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("[" + strings.Join(items, ",") + "]")),
+		Request:    req,
+	}, nil
+}
+
+func TestList(t *testing.T) {
+	p := New("12345", "secret", "read:org")
+	p.Transport = pages(perPage + 1)
+	r, _ := http.NewRequest("GET", "https://example.com/-/auth/github/callback", nil)
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := p.list(r, "token", "/user/orgs", &orgs); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if len(orgs) != perPage+1 || orgs[perPage].Login != fmt.Sprintf("org%d", perPage) {
+		t.Errorf(`len(orgs): %v, want %v`, len(orgs), perPage+1)
+	}
+}
@@ -11,13 +11,58 @@ Package auth/google provides Google authentication
 package google
 
 import (
+	"errors"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/logging"
 	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
+const (
+	KEYS_URL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// LegacyProviderName is the name under which the auth/appengine_openid
+// provider saved its Profiles.
+const LegacyProviderName = "AppEngineOpenID"
+
+var (
+	ErrMissingIDToken   = errors.New("auth/google: the token response did not include an id_token")
+	ErrInvalidIssuer    = errors.New("auth/google: the ID token was not issued by Google")
+	ErrDomainNotAllowed = errors.New("auth/google: the account is not in an allowed Google Workspace domain")
+)
+
+// keys is the cache of Google's published signing keys.
+var keys = jwt.NewRemoteKeySet(KEYS_URL)
+
 type Provider struct {
 	oauth2.Provider
+	// OpenIDRealm is the OpenID 2.0 realm that was used with the App
+	// Engine OpenID provider, e.g. "http://example.com/". When set the
+	// realm is sent with the authorization request and Google includes
+	// the user's legacy OpenID identifier in the ID token. Profiles
+	// saved by auth/appengine_openid under that identifier are then
+	// attached to the same User.
+	OpenIDRealm string
+	// Audiences are the additional OAuth client IDs, e.g. those of the
+	// Android and iOS apps, that are accepted in ID tokens posted to the
+	// token endpoint. The provider's ClientID is always accepted.
+	Audiences []string
+	// HostedDomains, if set, restrict the logins to the Google Workspace
+	// accounts of the domains, e.g. "example.com". A single domain is
+	// sent as the hd parameter, so that Google only offers its accounts.
+	// The hd claim of the ID token is always verified, as the parameter
+	// can be changed by the user.
+	HostedDomains []string
 }
 
+// New creates a new Google provider. The scope should include "openid",
+// e.g. "openid email profile".
 func New(clientID, clientSecret, scope string) *Provider {
 	return &Provider{
 		Provider: oauth2.Provider{
@@ -32,29 +77,181 @@ func New(clientID, clientSecret, scope string) *Provider {
 	}
 }
 
-// func init() {
-// 	defaultCnfg = map[string]string{
-// 		"BaseURL":    baseURL,
-// 		"LoginURL":   loginURL,
-// 		"LogoutURL":  loginURL,
-// 		"SuccessURL": successURL,
-// 	}
-// }
-// 
-// // setConfig retrieves the global config for the "auth" key and sets
-// // local variables based on the response.
-// func setConfig(c appengine.Context) {
-// 	// TODO(kylefinley): This doesn't work. The cnft needs to be set before
-// 	// a context/request is available.
-// 	cnfg, err := config.GetOrInsert(c, "auth", defaultCnfg)
-// 	if err != nil {
-// 		panic("auth: an error occured while setting the config")
-// 	}
-// 	baseURL = cnfg.Values["BaseURL"]
-// 	loginURL = cnfg.Values["LoginURL"]
-// 	logoutURL = cnfg.Values["LogoutURL"]
-// 	successURL = cnfg.Values["SuccessURL"]
-// }
+// Claims represents the claims of a Google ID token.
+type Claims struct {
+	jwt.StandardClaims
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+	HostedDomain  string `json:"hd"`
+	// OpenID is the user's OpenID 2.0 identifier. It is only present
+	// when the openid.realm parameter was sent.
+	OpenID string `json:"openid_id"`
+}
+
+// Person converts the claims to a Person.
+func (cl *Claims) Person() *person.Person {
+	per := &person.Person{
+		DisplayName: cl.Name,
+		Email:       cl.Email,
+		Name: &person.PersonName{
+			GivenName:  cl.GivenName,
+			FamilyName: cl.FamilyName,
+		},
+	}
+	if cl.Email != "" {
+		per.Emails = []*person.PersonEmails{
+			&person.PersonEmails{Primary: true, Type: "account", Value: cl.Email},
+		}
+	}
+	if cl.Picture != "" {
+		per.Image = &person.PersonImage{URL: cl.Picture}
+	}
+	return per
+}
+
+// start returns the authorization URL including the OpenID realm and
+// the hosted domain.
+func (p *Provider) start(w http.ResponseWriter, r *http.Request) string {
+	u := p.Start(w, r)
+	if p.OpenIDRealm != "" {
+		u += "&openid.realm=" + url.QueryEscape(p.OpenIDRealm)
+	}
+	if len(p.HostedDomains) == 1 {
+		u += "&hd=" + url.QueryEscape(p.HostedDomains[0])
+	} else if len(p.HostedDomains) > 1 {
+		// Only offer Google Workspace accounts.
+		u += "&hd=*"
+	}
+	return u
+}
+
+// allow returns an error unless the account of the claims may log in.
+func (p *Provider) allow(cl *Claims) error {
+	if len(p.HostedDomains) > 0 {
+		ok := false
+		for _, d := range p.HostedDomains {
+			ok = ok || strings.EqualFold(d, cl.HostedDomain)
+		}
+		if !ok {
+			return ErrDomainNotAllowed
+		}
+	}
+	return p.CheckEmail(cl.Email, cl.EmailVerified)
+}
+
+// Authenticate process the request and returns a populated Profile.
+// If the Authenticate method can not authenticate the User based on the
+// request, an error or a redirect URL wll be return.
+func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
+	up *profile.Profile, redirectURL string, err error) {
+
+	if !oauth2.IsCallback(r) {
+		return nil, p.start(w, r), nil
+	}
+	t, err := p.Callback(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	// The ID token was received directly from the token endpoint over
+	// TLS so its signature does not need to be verified.
+	raw := t.Extra["id_token"]
+	if raw == "" {
+		return nil, "", ErrMissingIDToken
+	}
+	tok, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	cl := new(Claims)
+	if err = tok.Claims(cl); err != nil {
+		return nil, "", err
+	}
+	if err = p.allow(cl); err != nil {
+		return nil, "", err
+	}
+	return p.profile(r, tok, cl), "", nil
+}
+
+// AuthenticateToken verifies the ID token posted by a native app in the
+// "id_token" form value and returns a populated Profile.
+func (p *Provider) AuthenticateToken(w http.ResponseWriter, r *http.Request) (
+	*profile.Profile, error) {
+
+	tok, err := jwt.Parse(r.FormValue("id_token"))
+	if err != nil {
+		return nil, err
+	}
+	if err = keys.Verify(p.Client(r), tok); err != nil {
+		return nil, err
+	}
+	cl := new(Claims)
+	if err = tok.Claims(cl); err != nil {
+		return nil, err
+	}
+	if cl.Issuer != "accounts.google.com" && cl.Issuer != "https://accounts.google.com" {
+		return nil, ErrInvalidIssuer
+	}
+	if err = cl.Validate("", "", time.Now()); err != nil {
+		return nil, err
+	}
+	if !p.validAudience(cl.Audience) {
+		return nil, jwt.ErrInvalidAudience
+	}
+	if err = p.allow(cl); err != nil {
+		return nil, err
+	}
+	return p.profile(r, tok, cl), nil
+}
+
+// validAudience reports whether the token was issued to one of the
+// provider's clients.
+func (p *Provider) validAudience(aud jwt.Audience) bool {
+	if aud.Contains(p.ClientID) {
+		return true
+	}
+	for _, a := range p.Audiences {
+		if aud.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// profile creates the Profile for the claims of a Google ID token.
+func (p *Provider) profile(r *http.Request, tok *jwt.Token, cl *Claims) *profile.Profile {
+	up := profile.New(p.Name, p.URL)
+	up.ID = cl.Subject
+	up.Person = cl.Person()
+	up.PersonRawJSON = tok.Payload
+	if cl.HostedDomain != "" {
+		up.SetClaim("hd", cl.HostedDomain)
+	}
+	linkLegacy(r, up, cl.OpenID)
+	return up
+}
+
+// linkLegacy sets the UserID of up to that of the Profile saved by the
+// App Engine OpenID provider for openID. Profiles that have already been
+// saved keep their UserID.
+func linkLegacy(r *http.Request, up *profile.Profile, openID string) {
+	if openID == "" {
+		return
+	}
+	if p, err := profile.DefaultStore.Get(r, up.AuthID()); err == nil && p.UserID != "" {
+		return
+	}
+	lp, err := profile.DefaultStore.Get(r, profile.GenAuthID(LegacyProviderName, openID))
+	if err != nil || lp.UserID == "" {
+		// No legacy account; a new User will be created.
+		return
+	}
+	logging.NewLogger(r).Infof("auth/google: linking %v to legacy profile %v", up.ID, openID)
+	up.UserID = lp.UserID
+}
 
 // func (p *UserProfile) PersonRaw(c appengine.Context) interface{} {
 // 
@@ -3,3 +3,172 @@
 // license that can be found in the LICENSE file.
 
 package google
+
+import (
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/oauth2"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func tearDown() {
+	context.Close()
+}
+
+func TestStart(t *testing.T) {
+	p := New("12345", "secret", "openid email")
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google", nil)
+	if x := p.start(httptest.NewRecorder(), r); strings.Contains(x, "openid.realm") {
+		t.Errorf(`start: %v, should not contain "openid.realm"`, x)
+	}
+	p.OpenIDRealm = "http://localhost:8080/"
+	if x := p.start(httptest.NewRecorder(), r); !strings.Contains(x, "&openid.realm=http%3A%2F%2Flocalhost%3A8080%2F") {
+		t.Errorf(`start: %v, want "openid.realm"`, x)
+	}
+}
+
+func TestCallback_State(t *testing.T) {
+	p := New("12345", "secret", "openid email")
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google", nil)
+	w := httptest.NewRecorder()
+	u, _ := url.Parse(p.start(w, r))
+	state := u.Query().Get("state")
+	cs := w.Result().Cookies()
+	if len(cs) != 1 || cs[0].Value != state || state == "" {
+		t.Fatalf(`cookies: %v, want the state %q`, cs, state)
+	}
+	if x := cs[0].Path; x != "/-/auth/google/callback" {
+		t.Errorf(`Path: %v, want "/-/auth/google/callback"`, x)
+	}
+	if x := cs[0].SameSite; x != http.SameSiteLaxMode {
+		t.Errorf(`SameSite: %v, want %v`, x, http.SameSiteLaxMode)
+	}
+	for _, q := range []string{"", "?state=" + state, "?state=other"} {
+		r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback"+q, nil)
+		if q != "?state="+state {
+			r.AddCookie(&http.Cookie{Name: cs[0].Name, Value: cs[0].Value})
+		}
+		if _, err := p.Callback(httptest.NewRecorder(), r); err != oauth2.ErrInvalidState {
+			t.Errorf(`%q err: %v, want %v`, q, err, oauth2.ErrInvalidState)
+		}
+	}
+	r, _ = http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback?state="+state, nil)
+	r.AddCookie(&http.Cookie{Name: cs[0].Name, Value: cs[0].Value})
+	w = httptest.NewRecorder()
+	if _, err := p.Callback(w, r); err != oauth2.ErrMissingCode {
+		t.Errorf(`err: %v, want %v`, err, oauth2.ErrMissingCode)
+	}
+	if cs = w.Result().Cookies(); len(cs) != 1 || cs[0].MaxAge >= 0 {
+		t.Errorf(`cookies: %v, want the state removed`, cs)
+	}
+}
+
+func TestClaimsPerson(t *testing.T) {
+	cl := &Claims{
+		Email:      "test@example.org",
+		GivenName:  "Barack",
+		FamilyName: "Obama",
+	}
+	cl.Subject = "12345"
+	per := cl.Person()
+	if x := per.Name.GivenName; x != "Barack" {
+		t.Errorf(`per.Name.GivenName: %v, want "Barack"`, x)
+	}
+	if x := per.Emails[0].Value; x != "test@example.org" {
+		t.Errorf(`per.Emails[0].Value: %v, want "test@example.org"`, x)
+	}
+}
+
+func TestLinkLegacy(t *testing.T) {
+	defer func(s profile.Store) { profile.DefaultStore = s }(profile.DefaultStore)
+	profile.DefaultStore = profile.NewMemoryStore()
+
+	// Legacy Profile.
+
+	lp := profile.New(LegacyProviderName, "gmail.com")
+	lp.ID = "https://www.google.com/accounts/o8/id?id=AItOawl"
+	lp.UserID = "1"
+	if err := profile.DefaultStore.Put(nil, lp); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	// No openid_id.
+
+	up := profile.New("Google", "https://plus.google.com")
+	up.ID = "1001"
+	linkLegacy(nil, up, "")
+	if up.UserID != "" {
+		t.Errorf(`up.UserID: %v, want ""`, up.UserID)
+	}
+
+	// Matching openid_id.
+
+	linkLegacy(nil, up, lp.ID)
+	if up.UserID != "1" {
+		t.Errorf(`up.UserID: %v, want "1"`, up.UserID)
+	}
+
+	// Existing Google Profile keeps its User.
+
+	up.UserID = "2"
+	if err := profile.DefaultStore.Put(nil, up); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	up = profile.New("Google", "https://plus.google.com")
+	up.ID = "1001"
+	linkLegacy(nil, up, lp.ID)
+	if up.UserID != "" {
+		t.Errorf(`up.UserID: %v, want ""`, up.UserID)
+	}
+}
+
+func TestValidAudience(t *testing.T) {
+	p := New("web", "secret", "openid email")
+	p.Audiences = []string{"android"}
+	if !p.validAudience(jwt.Audience{"web"}) {
+		t.Errorf(`validAudience("web"): false, want true`)
+	}
+	if !p.validAudience(jwt.Audience{"android"}) {
+		t.Errorf(`validAudience("android"): false, want true`)
+	}
+	if p.validAudience(jwt.Audience{"other"}) {
+		t.Errorf(`validAudience("other"): true, want false`)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p := New("web", "secret", "openid email")
+	cl := &Claims{Email: "test@example.org", EmailVerified: true}
+	if err := p.allow(cl); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	p.HostedDomains = []string{"example.org"}
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google", nil)
+	if x := p.start(httptest.NewRecorder(), r); !strings.Contains(x, "&hd=example.org") {
+		t.Errorf(`start: %v, want "hd"`, x)
+	}
+	if err := p.allow(cl); err != ErrDomainNotAllowed {
+		t.Errorf(`err: %v, want %v`, err, ErrDomainNotAllowed)
+	}
+	cl.HostedDomain = "example.org"
+	if err := p.allow(cl); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	p.EmailDomains = []string{"example.com"}
+	if err := p.allow(cl); err != oauth2.ErrEmailDomainNotAllowed {
+		t.Errorf(`err: %v, want %v`, err, oauth2.ErrEmailDomainNotAllowed)
+	}
+	p.EmailDomains = []string{"EXAMPLE.org"}
+	if err := p.allow(cl); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	cl.EmailVerified = false
+	if err := p.allow(cl); err != oauth2.ErrEmailDomainNotAllowed {
+		t.Errorf(`err: %v, want %v`, err, oauth2.ErrEmailDomainNotAllowed)
+	}
+}
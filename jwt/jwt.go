@@ -0,0 +1,268 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/jwt provides parsing, signing and verification of JSON Web
+Tokens such as the OpenID Connect ID tokens returned by providers.
+
+Only the RS256 and ES256 algorithms are supported.
+*/
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed          = errors.New("auth/jwt: token is malformed")
+	ErrUnsupportedAlg     = errors.New("auth/jwt: unsupported signing algorithm")
+	ErrInvalidSignature   = errors.New("auth/jwt: invalid signature")
+	ErrInvalidIssuer      = errors.New("auth/jwt: invalid issuer")
+	ErrInvalidAudience    = errors.New("auth/jwt: invalid audience")
+	ErrExpired            = errors.New("auth/jwt: token has expired")
+	ErrNotYetValid        = errors.New("auth/jwt: token is not valid yet")
+	ErrInvalidKey         = errors.New("auth/jwt: invalid key")
+	ErrKeyNotFound        = errors.New("auth/jwt: signing key not found")
+	ErrUnsupportedKeyType = errors.New("auth/jwt: unsupported key type")
+)
+
+// Leeway is the clock skew allowed when validating the time based
+// claims.
+var Leeway = time.Minute
+
+// Token is a parsed, but not verified, JSON Web Token.
+type Token struct {
+	// Raw is the token as it was received.
+	Raw string
+	// Header is the decoded JOSE header.
+	Header Header
+	// Payload is the decoded JSON claims set.
+	Payload []byte
+	// Signature is the decoded signature.
+	Signature []byte
+}
+
+// Header represents the JOSE header of a token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Parse splits a compact serialized token into its parts and decodes
+// them. The signature is not verified.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	t := &Token{Raw: raw}
+	h, err := DecodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err = json.Unmarshal(h, &t.Header); err != nil {
+		return nil, ErrMalformed
+	}
+	if t.Payload, err = DecodeSegment(parts[1]); err != nil {
+		return nil, ErrMalformed
+	}
+	if t.Signature, err = DecodeSegment(parts[2]); err != nil {
+		return nil, ErrMalformed
+	}
+	return t, nil
+}
+
+// Claims decodes the payload of the token into v.
+func (t *Token) Claims(v interface{}) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+// SigningInput returns the part of the token covered by the signature.
+func (t *Token) SigningInput() string {
+	return t.Raw[:strings.LastIndex(t.Raw, ".")]
+}
+
+// DecodeSegment decodes a base64url segment with or without padding.
+func DecodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// EncodeSegment encodes b as an unpadded base64url segment.
+func EncodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Audience is the "aud" claim. It may be sent as either a string or an
+// array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the claim.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = Audience(l)
+	return nil
+}
+
+// MarshalJSON encodes a single audience as a string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// StandardClaims are the registered claims of RFC 7519. It may be
+// embedded in provider specific claim types.
+type StandardClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks the issuer, audience and time based claims. Empty iss
+// or aud arguments are not checked.
+func (cl *StandardClaims) Validate(iss, aud string, now time.Time) error {
+	if iss != "" && cl.Issuer != iss {
+		return ErrInvalidIssuer
+	}
+	if aud != "" && !cl.Audience.Contains(aud) {
+		return ErrInvalidAudience
+	}
+	if cl.ExpiresAt != 0 && now.After(time.Unix(cl.ExpiresAt, 0).Add(Leeway)) {
+		return ErrExpired
+	}
+	if cl.NotBefore != 0 && now.Add(Leeway).Before(time.Unix(cl.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	return nil
+}
+
+// Sign returns the compact serialization of claims signed with key.
+// The algorithm is chosen from the key type: ES256 for *ecdsa.PrivateKey
+// and RS256 for *rsa.PrivateKey.
+func Sign(claims interface{}, kid string, key crypto.PrivateKey) (string, error) {
+	h := Header{KeyID: kid, Type: "JWT"}
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		h.Algorithm = "ES256"
+	case *rsa.PrivateKey:
+		h.Algorithm = "RS256"
+	default:
+		return "", ErrUnsupportedKeyType
+	}
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := EncodeSegment(hb) + "." + EncodeSegment(cb)
+	sum := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed width concatenation of r and s.
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			return "", err
+		}
+	}
+	return input + "." + EncodeSegment(sig), nil
+}
+
+// Verify checks the signature of the token with the public key.
+func (t *Token) Verify(key crypto.PublicKey) error {
+	sum := sha256.Sum256([]byte(t.SigningInput()))
+	switch t.Header.Algorithm {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], t.Signature) != nil {
+			return ErrInvalidSignature
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if len(t.Signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(t.Signature[:32])
+		s := new(big.Int).SetBytes(t.Signature[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlg
+	}
+	return nil
+}
+
+// ParseECPrivateKey parses a PEM encoded PKCS#8 EC private key, such as
+// the .p8 keys issued by Apple.
+func ParseECPrivateKey(b []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if k, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	ek, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	return ek, nil
+}
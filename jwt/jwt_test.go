@@ -0,0 +1,135 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	h := EncodeSegment([]byte(`{"alg":"RS256","kid":"1"}`))
+	p := EncodeSegment([]byte(`{"sub":"12345","email":"test@example.org"}`))
+	s := EncodeSegment([]byte("signature"))
+
+	tok, err := Parse(h + "." + p + "." + s)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if x := tok.Header.Algorithm; x != "RS256" {
+		t.Errorf(`tok.Header.Algorithm: %v, want "RS256"`, x)
+	}
+	if x := tok.Header.KeyID; x != "1" {
+		t.Errorf(`tok.Header.KeyID: %v, want "1"`, x)
+	}
+	if x := string(tok.Signature); x != "signature" {
+		t.Errorf(`tok.Signature: %v, want "signature"`, x)
+	}
+	if x := tok.SigningInput(); x != h+"."+p {
+		t.Errorf(`tok.SigningInput(): %v, want %v`, x, h+"."+p)
+	}
+	var cl struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+	if err = tok.Claims(&cl); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if cl.Subject != "12345" {
+		t.Errorf(`cl.Subject: %v, want "12345"`, cl.Subject)
+	}
+
+	// Malformed.
+
+	if _, err = Parse("abc.def"); err != ErrMalformed {
+		t.Errorf(`err: %v, want %v`, err, ErrMalformed)
+	}
+	if _, err = Parse("!!!." + p + "." + s); err != ErrMalformed {
+		t.Errorf(`err: %v, want %v`, err, ErrMalformed)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rk, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	for _, key := range []crypto.Signer{ek, rk} {
+		cl := &StandardClaims{
+			Issuer:    "https://example.com",
+			Subject:   "12345",
+			Audience:  Audience{"client"},
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}
+		raw, err := Sign(cl, "1", key)
+		if err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		tok, err := Parse(raw)
+		if err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		if err = tok.Verify(key.Public()); err != nil {
+			t.Errorf(`%v err: %v, want nil`, tok.Header.Algorithm, err)
+		}
+
+		// Through a KeySet.
+
+		k, err := NewKey("1", key.Public())
+		if err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		ks := &KeySet{Keys: []*Key{k}}
+		if err = ks.Verify(tok); err != nil {
+			t.Errorf(`%v err: %v, want nil`, tok.Header.Algorithm, err)
+		}
+
+		// Tampered.
+
+		tok.Raw = tok.Raw[:strings.Index(tok.Raw, ".")] + "." +
+			EncodeSegment([]byte(`{"sub":"admin"}`)) + "." +
+			tok.Raw[strings.LastIndex(tok.Raw, ".")+1:]
+		if err = tok.Verify(key.Public()); err != ErrInvalidSignature {
+			t.Errorf(`%v err: %v, want %v`, tok.Header.Algorithm, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	cl := &StandardClaims{
+		Issuer:    "https://example.com",
+		Audience:  Audience{"a", "b"},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+	if err := cl.Validate("https://example.com", "b", now); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if err := cl.Validate("https://evil.com", "b", now); err != ErrInvalidIssuer {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidIssuer)
+	}
+	if err := cl.Validate("https://example.com", "c", now); err != ErrInvalidAudience {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidAudience)
+	}
+	if err := cl.Validate("", "", now.Add(2*time.Hour)); err != ErrExpired {
+		t.Errorf(`err: %v, want %v`, err, ErrExpired)
+	}
+
+	// Audience as a string.
+
+	var cl2 StandardClaims
+	if err := json.Unmarshal([]byte(`{"aud":"client"}`), &cl2); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if !cl2.Audience.Contains("client") {
+		t.Errorf(`cl2.Audience: %v, want "client"`, cl2.Audience)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Key is a JSON Web Key as described in RFC 7517. Only the fields of RSA
+// and P-256 public keys are supported.
+type Key struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// PublicKey returns the *rsa.PublicKey or *ecdsa.PublicKey represented
+// by the Key.
+func (k *Key) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := DecodeSegment(k.N)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		e, err := DecodeSegment(k.E)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, ErrUnsupportedKeyType
+		}
+		x, err := DecodeSegment(k.X)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		y, err := DecodeSegment(k.Y)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+// NewKey returns the JSON Web Key for an *rsa.PublicKey or
+// *ecdsa.PublicKey.
+func NewKey(kid string, pub crypto.PublicKey) (*Key, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &Key{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         EncodeSegment(k.N.Bytes()),
+			E:         EncodeSegment(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		return &Key{
+			KeyType:   "EC",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "ES256",
+			Curve:     "P-256",
+			X:         EncodeSegment(k.X.Bytes()),
+			Y:         EncodeSegment(k.Y.Bytes()),
+		}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+// KeySet is a JSON Web Key Set.
+type KeySet struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Key returns the key with the key ID kid.
+func (ks *KeySet) Key(kid string) (*Key, error) {
+	for _, k := range ks.Keys {
+		if k.KeyID == kid {
+			return k, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Verify checks the signature of t with the key named in its header.
+func (ks *KeySet) Verify(t *Token) error {
+	k, err := ks.Key(t.Header.KeyID)
+	if err != nil {
+		return err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	return t.Verify(pub)
+}
+
+// RemoteKeySet fetches and caches a provider's published key set, e.g.
+// https://www.googleapis.com/oauth2/v3/certs.
+type RemoteKeySet struct {
+	URL string
+	// Expiration is how long a fetched key set is used before it is
+	// fetched again. Zero means one hour.
+	Expiration time.Duration
+
+	mu      sync.Mutex
+	keys    *KeySet
+	fetched time.Time
+}
+
+// NewRemoteKeySet creates a RemoteKeySet for url.
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{URL: url}
+}
+
+// Verify checks the signature of t. The key set is fetched with client
+// when it has expired or does not contain the token's key, which
+// happens when the provider rotates its keys.
+func (rk *RemoteKeySet) Verify(client *http.Client, t *Token) error {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	exp := rk.Expiration
+	if exp == 0 {
+		exp = time.Hour
+	}
+	if rk.keys != nil && time.Since(rk.fetched) < exp {
+		if _, err := rk.keys.Key(t.Header.KeyID); err == nil {
+			return rk.keys.Verify(t)
+		}
+	}
+	ks, err := FetchKeySet(client, rk.URL)
+	if err != nil {
+		return err
+	}
+	rk.keys = ks
+	rk.fetched = time.Now()
+	return ks.Verify(t)
+}
+
+// FetchKeySet retrieves the key set published at url.
+func FetchKeySet(client *http.Client, url string) (*KeySet, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth/jwt: %s returned %s", url, res.Status)
+	}
+	ks := new(KeySet)
+	if err = json.NewDecoder(res.Body).Decode(ks); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
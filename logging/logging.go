@@ -0,0 +1,52 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/logging writes the log messages of the auth packages. They
+go to the standard logger unless NewLogger is replaced, e.g. by
+auth/namespace, which writes them to the App Engine log of the request.
+*/
+package logging
+
+import (
+	"log"
+	"net/http"
+)
+
+// Logger is implemented by the App Engine context.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Criticalf(format string, args ...interface{})
+}
+
+// NewLogger returns the Logger of the request.
+var NewLogger = func(r *http.Request) Logger {
+	return stdLogger{}
+}
+
+// stdLogger writes to the standard logger, prefixed by the level.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) {
+	log.Printf("DEBUG: "+format, args...)
+}
+
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("INFO: "+format, args...)
+}
+
+func (stdLogger) Warningf(format string, args ...interface{}) {
+	log.Printf("WARNING: "+format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (stdLogger) Criticalf(format string, args ...interface{}) {
+	log.Printf("CRITICAL: "+format, args...)
+}
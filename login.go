@@ -0,0 +1,210 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/gaego/auth/logging"
+	"github.com/gaego/auth/tenant"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var (
+	// Icons are the URLs of the providers' icons by key, e.g.
+	// Icons["google"] = "/static/google.svg".
+	Icons = make(map[string]string)
+	// LoginErrors are the messages of the login page for the error
+	// codes of the failed logins, e.g. "login_failed". Codes without a
+	// message are not shown.
+	LoginErrors = map[string]string{
+		"login_failed": "The login failed. Please try again.",
+		"server_error": "The login failed because of an error on our side. Please try again later.",
+	}
+)
+
+// ProviderInfo describes a registered provider.
+type ProviderInfo struct {
+	// Key is the key of Register, e.g. "google".
+	Key string
+	// Name and URL are those of the provider, e.g. "Google" and
+	// "https://plus.google.com".
+	Name string
+	URL  string `json:",omitempty"`
+	// StartURL starts the login, e.g. /-/auth/google.
+	StartURL string
+	// Icon is the provider's URL in Icons.
+	Icon string `json:",omitempty"`
+	// Form is true for providers that take a POST of the login form to
+	// the StartURL instead of redirecting, e.g. auth/password.
+	Form bool `json:",omitempty"`
+}
+
+// describer is implemented by providers with a display name and a
+// home page, e.g. those of auth/oauth2.
+type describer interface {
+	Describe() (name, url string)
+}
+
+// loginFormer is implemented by providers that authenticate a POST of
+// the login form, e.g. auth/password.
+type loginFormer interface {
+	LoginForm() bool
+}
+
+// ListProviders returns the providers of the request's Tenant, sorted
+// by key.
+func ListProviders(r *http.Request) []*ProviderInfo {
+	providersMu.RLock()
+	ps := providers
+	if t := tenant.Current(r); t != nil {
+		ps = tenantProviders[t.Name]
+	}
+	var ks []string
+	for k := range ps {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	infos := make([]*ProviderInfo, len(ks))
+	for i, k := range ks {
+		infos[i] = newProviderInfo(k, ps[k])
+	}
+	providersMu.RUnlock()
+	return infos
+}
+
+func newProviderInfo(key string, a authenticater) *ProviderInfo {
+	info := &ProviderInfo{
+		Key:      key,
+		StartURL: BaseURL + key,
+		Icon:     Icons[key],
+	}
+	if d, ok := a.(describer); ok {
+		info.Name, info.URL = d.Describe()
+	}
+	if info.Name == "" {
+		info.Name = key
+	}
+	if f, ok := a.(loginFormer); ok {
+		info.Form = f.LoginForm()
+	}
+	return info
+}
+
+// loginRedirect returns the LoginURL of the request's Tenant with the
+// parameters.
+func loginRedirect(r *http.Request, params url.Values) string {
+	login := loginURL(r)
+	if len(params) == 0 {
+		return login
+	}
+	sep := "?"
+	if strings.Contains(login, "?") {
+		sep = "&"
+	}
+	return login + sep + params.Encode()
+}
+
+// LoginTemplate renders the page of LoginHandler. Its templates may be
+// redefined to theme the page, e.g.:
+//
+//   auth.LoginTemplate = template.Must(template.Must(auth.LoginTemplate.Clone()).Parse(
+//     `{{define "title"}}Log in to Example{{end}}` +
+//     `{{define "style"}}body { font-family: serif; }{{end}}`))
+//
+// The templates are "login", the page, "title", "style", "error", the
+// error message, "providers", the links that start the logins, and
+// "form", the login form of the providers such as auth/password. They
+// are executed with a *LoginPage.
+var LoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{template "title" .}}</title>
+<style>{{template "style" .}}</style>
+</head>
+<body>
+<main class="auth-login">
+<h1>{{template "title" .}}</h1>
+{{template "error" .}}
+{{template "providers" .}}
+{{template "form" .}}
+</main>
+</body>
+</html>
+{{- define "title"}}Log in{{end}}
+{{- define "style"}}
+body { font-family: sans-serif; margin: 0; background: #f5f5f5; color: #222; }
+.auth-login { max-width: 22em; margin: 4em auto; padding: 2em; background: #fff; border-radius: 4px; }
+.auth-login h1 { font-size: 1.5em; margin-top: 0; }
+.auth-error { padding: .5em; background: #fdecea; color: #a50e0e; }
+.auth-provider { display: block; margin: .5em 0; padding: .6em; border: 1px solid #ccc; border-radius: 4px; color: inherit; text-align: center; text-decoration: none; }
+.auth-provider img { height: 1.2em; margin-right: .5em; vertical-align: middle; }
+.auth-form label { display: block; margin: .5em 0; }
+.auth-form input[type=email], .auth-form input[type=password] { box-sizing: border-box; width: 100%; padding: .4em; }
+{{end}}
+{{- define "error"}}{{with .Error}}<p class="auth-error" role="alert">{{.}}</p>{{end}}{{end}}
+{{- define "providers"}}{{range .Providers}}
+<a class="auth-provider auth-{{.Key}}" href="{{.StartURL}}{{with $.Next}}?{{$.NextField}}={{.}}{{end}}">{{with .Icon}}<img src="{{.}}" alt="">{{end}}Log in with {{.Name}}</a>
+{{- end}}{{end}}
+{{- define "form"}}{{range .Forms}}
+<form class="auth-form auth-{{.Key}}" method="post" action="{{.StartURL}}">
+<label>Email <input type="email" name="Email" autocomplete="email" required></label>
+<label>Password <input type="password" name="Password.Current" autocomplete="current-password" required></label>
+<label><input type="checkbox" name="{{$.RememberField}}" value="1"> Remember me</label>
+{{with $.Next}}<input type="hidden" name="{{$.NextField}}" value="{{.}}">{{end}}
+<button type="submit">Log in</button>
+</form>
+{{- end}}{{end}}
+`))
+
+// LoginPage is the data of LoginTemplate.
+type LoginPage struct {
+	// Providers are the providers that redirect, and Forms those that
+	// take the login form.
+	Providers []*ProviderInfo
+	Forms     []*ProviderInfo
+	// Error is the message in LoginErrors of the failed login.
+	Error string
+	// Next is the local URL to return to after the login.
+	Next          string
+	NextField     string
+	RememberField string
+}
+
+// LoginHandler renders a login page for the providers of the request's
+// Tenant from LoginTemplate. It is optional; register it at LoginURL:
+//
+//   http.HandleFunc(auth.LoginURL, auth.LoginHandler)
+//
+// The NextField of the request is passed on to the providers. Failed
+// logins return to the page with the error code in the "error"
+// parameter.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	page := &LoginPage{
+		Error:         LoginErrors[r.FormValue("error")],
+		NextField:     NextField,
+		RememberField: RememberField,
+	}
+	if next := r.FormValue(NextField); localURL(next) {
+		page.Next = next
+	}
+	for _, p := range ListProviders(r) {
+		if p.Form {
+			page.Forms = append(page.Forms, p)
+		} else {
+			page.Providers = append(page.Providers, p)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The page may not be framed, e.g. to click its buttons for the User.
+	w.Header().Set("X-Frame-Options", "DENY")
+	if err := LoginTemplate.Execute(w, page); err != nil {
+		logging.NewLogger(r).Errorf("auth: login page: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/metrics provides the instrumentation of the login steps.
+
+Each step, e.g. the provider's token exchange, is reported to Default
+with the provider, its outcome and its duration. Default does nothing
+until it is replaced, e.g. by the Prometheus adapter:
+
+  import "github.com/gaego/auth/metrics/prometheus"
+
+  metrics.Default = prometheus.New(prometheus.DefaultRegisterer)
+*/
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The steps of a login.
+const (
+	// Start is the redirect to the provider.
+	Start = "start"
+	// Callback is the provider's redirect back, including the token
+	// exchange and profile fetch.
+	Callback = "callback"
+	// Token is a login through the token endpoint used by native apps.
+	Token = "token"
+	// TokenExchange is the request to the provider's token endpoint.
+	TokenExchange = "token_exchange"
+	// ProfileFetch is the request to the provider's user info endpoint.
+	ProfileFetch = "profile_fetch"
+	// CreateAndLogin is the creation or update of the User and session.
+	CreateAndLogin = "create_and_login"
+)
+
+// The outcomes of a step.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Recorder is implemented by metrics backends.
+type Recorder interface {
+	// Observe records that step ended with outcome after d. provider
+	// is the lower case key of the provider, e.g. "google", see Provider.
+	Observe(step, provider, outcome string, d time.Duration)
+}
+
+// Nop is the Recorder that discards the metrics.
+type Nop struct{}
+
+func (Nop) Observe(step, provider, outcome string, d time.Duration) {}
+
+// Default is the Recorder used by the auth packages.
+var Default Recorder = Nop{}
+
+// Timer measures the duration of a step. Step may be changed before
+// Done, e.g. once it is known whether a request was the start or the
+// callback of a login.
+type Timer struct {
+	Step     string
+	Provider string
+	Started  time.Time
+}
+
+type contextKey int
+
+const providerKey contextKey = 0
+
+// WithProvider returns a copy of r that carries the key of the provider
+// that serves it, e.g. "google", so that the steps of the provider
+// package and of auth have the same label.
+func WithProvider(r *http.Request, key string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), providerKey, key))
+}
+
+// Provider returns the provider key of WithProvider, or name if the
+// request has none, e.g. the Name of a provider used outside of the auth
+// handlers.
+func Provider(r *http.Request, name string) string {
+	if k, ok := r.Context().Value(providerKey).(string); ok {
+		return k
+	}
+	return name
+}
+
+// Time starts measuring step for the provider.
+func Time(step, provider string) *Timer {
+	return &Timer{Step: step, Provider: strings.ToLower(provider), Started: time.Now()}
+}
+
+// Done records the step to Default with the outcome of err.
+func (t *Timer) Done(err error) {
+	outcome := Success
+	if err != nil {
+		outcome = Failure
+	}
+	Default.Observe(t.Step, t.Provider, outcome, time.Since(t.Started))
+}
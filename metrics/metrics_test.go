@@ -0,0 +1,61 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	step, provider, outcome string
+	d                       time.Duration
+}
+
+type recorder []observation
+
+func (r *recorder) Observe(step, provider, outcome string, d time.Duration) {
+	*r = append(*r, observation{step, provider, outcome, d})
+}
+
+func TestTimer(t *testing.T) {
+	rec := &recorder{}
+	defer func(r Recorder) { Default = r }(Default)
+	Default = rec
+
+	Time(TokenExchange, "Google").Done(nil)
+	m := Time(Callback, "twitter")
+	m.Step = Start
+	m.Done(errors.New("denied"))
+
+	want := []observation{
+		{TokenExchange, "google", Success, 0},
+		{Start, "twitter", Failure, 0},
+	}
+	if len(*rec) != len(want) {
+		t.Fatalf(`len(rec): %v, want %v`, len(*rec), len(want))
+	}
+	for i, o := range *rec {
+		if o.step != want[i].step || o.provider != want[i].provider || o.outcome != want[i].outcome {
+			t.Errorf(`rec[%v]: %v, want %v`, i, o, want[i])
+		}
+		if o.d < 0 {
+			t.Errorf(`rec[%v].d: %v, want >= 0`, i, o.d)
+		}
+	}
+}
+
+func TestProvider(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://example.com/-/auth/gh/callback", nil)
+	if p := Provider(r, "GitHub"); p != "GitHub" {
+		t.Errorf(`Provider: %v, want GitHub`, p)
+	}
+	r = WithProvider(r, "gh")
+	if p := Provider(r, "GitHub"); p != "gh" {
+		t.Errorf(`Provider: %v, want gh`, p)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/metrics/prometheus publishes the auth metrics to
+Prometheus as
+
+  auth_login_steps_total{step, provider, outcome}
+  auth_login_step_duration_seconds{step, provider, outcome}
+*/
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// DefaultRegisterer is the Prometheus default registry.
+var DefaultRegisterer = prometheus.DefaultRegisterer
+
+// Recorder is the metrics.Recorder that updates Prometheus collectors.
+type Recorder struct {
+	Steps     *prometheus.CounterVec
+	Durations *prometheus.HistogramVec
+}
+
+// New creates a Recorder and registers its collectors with reg.
+func New(reg prometheus.Registerer) *Recorder {
+	labels := []string{"step", "provider", "outcome"}
+	r := &Recorder{
+		Steps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "auth",
+			Name:      "login_steps_total",
+			Help:      "Number of login steps by provider and outcome.",
+		}, labels),
+		Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "auth",
+			Name:      "login_step_duration_seconds",
+			Help:      "Duration of the login steps by provider and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+	}
+	reg.MustRegister(r.Steps, r.Durations)
+	return r
+}
+
+func (r *Recorder) Observe(step, provider, outcome string, d time.Duration) {
+	r.Steps.WithLabelValues(step, provider, outcome).Inc()
+	r.Durations.WithLabelValues(step, provider, outcome).Observe(d.Seconds())
+}
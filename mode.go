@@ -0,0 +1,153 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"github.com/gaego/auth/origin"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The modes of ModeField. Without a mode the login redirects to the
+// SuccessURL, or on errors to the LoginURL with the error code in the
+// "error" parameter.
+const (
+	// ModePopup is for single-page apps that open the login in a popup.
+	// The callback renders a page that posts the TokenReply, with the
+	// type "auth", to the window that opened the popup, and closes it:
+	//
+	//   window.open("/-/auth/google?mode=popup&origin=" +
+	//     encodeURIComponent(location.origin), "login", "width=500,height=600");
+	//   window.addEventListener("message", function(e) {
+	//     if (e.origin !== "https://example.com" || e.data.type !== "auth") return;
+	//     if (e.data.error) ... else ... e.data.userId
+	//   });
+	//
+	// The message is only posted to the origin parameter, which must be
+	// the app's own origin, see auth/origin, or one of PopupOrigins. It
+	// defaults to the app's origin.
+	ModePopup = "popup"
+	// ModeJSON is for logins by XHR, e.g. a POST of the password form.
+	// The response is the TokenReply as JSON, with the status 401
+	// Unauthorized if the login failed. Providers that redirect reply
+	// with their RedirectURL.
+	ModeJSON = "json"
+)
+
+var (
+	// ModeField is the name of the login parameter with the mode of the
+	// response, ModePopup or ModeJSON.
+	ModeField = "mode"
+	// PopupOrigins are the origins, other than the app's own, that may
+	// open the login in a popup, e.g. "https://app.example.com".
+	PopupOrigins []string
+)
+
+// popupCookie carries the target origin of ModePopup through the
+// provider's redirects.
+const popupCookie = "auth-popup"
+
+// loginMode returns the mode of the login and, for ModePopup, the
+// origin to post the result to. ok is false if the origin may not open
+// the popup.
+func loginMode(r *http.Request) (mode, target string, ok bool) {
+	switch r.FormValue(ModeField) {
+	case ModeJSON:
+		return ModeJSON, "", true
+	case ModePopup:
+		target = r.FormValue("origin")
+		if target == "" {
+			target = origin.Of(r)
+		}
+		return ModePopup, target, popupOrigin(r, target)
+	}
+	// The callback leg of a popup.
+	if c, err := r.Cookie(popupCookie); err == nil && strings.HasSuffix(r.URL.Path, "/callback") {
+		if target, err = url.QueryUnescape(c.Value); err == nil && popupOrigin(r, target) {
+			return ModePopup, target, true
+		}
+	}
+	return "", "", true
+}
+
+// popupOrigin reports whether the origin may open the login in a popup.
+func popupOrigin(r *http.Request, o string) bool {
+	if o == origin.Of(r) {
+		return true
+	}
+	for _, p := range PopupOrigins {
+		if o == p {
+			return true
+		}
+	}
+	return false
+}
+
+// setPopup sets, or with a negative maxAge removes, the cookie that
+// carries the target origin of the popup.
+func setPopup(w http.ResponseWriter, target string, maxAge int) {
+	setLoginCookie(w, popupCookie, url.QueryEscape(target), maxAge)
+}
+
+// popupTemplate posts the message to the opener of the popup. The
+// message and the origin are escaped as JavaScript values.
+var popupTemplate = template.Must(template.New("popup").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Login</title></head>
+<body>
+<script>
+if (window.opener) {
+  window.opener.postMessage({{.Message}}, {{.Origin}});
+}
+window.close();
+</script>
+</body>
+</html>
+`))
+
+// popupMessage is the message posted by the popup.
+type popupMessage struct {
+	Type string `json:"type"`
+	*TokenReply
+}
+
+// writePopup writes the page that posts the reply to the target origin.
+func writePopup(w http.ResponseWriter, target string, reply *TokenReply) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	popupTemplate.Execute(w, map[string]interface{}{
+		"Message": &popupMessage{"auth", reply},
+		"Origin":  target,
+	})
+}
+
+// loginError ends a failed login in the mode with the error code, e.g.
+// "login_failed", and for ModeJSON the status.
+func loginError(w http.ResponseWriter, r *http.Request, mode, target string,
+	status int, code string) {
+	// The next login must ask for remember me again.
+	if _, err := r.Cookie(rememberCookie); err == nil {
+		setRememberRequest(w, -1)
+	}
+	switch mode {
+	case ModePopup:
+		setPopup(w, "", -1)
+		writePopup(w, target, &TokenReply{Error: code})
+	case ModeJSON:
+		writeJSON(w, status, &TokenReply{Error: code})
+	default:
+		// Return to the login page with the error, and the URL the login
+		// was started from.
+		params := url.Values{"error": {code}}
+		if next := r.FormValue(NextField); localURL(next) {
+			params.Set(NextField, next)
+		} else if next = successURL(r); next != defaultSuccessURL(r) {
+			params.Set(NextField, next)
+		}
+		http.Redirect(w, r, loginRedirect(r, params), http.StatusFound)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/namespace returns the App Engine context of a request in the
+datastore namespace of its Tenant. It is used by the datastore
+implementations of the auth packages' stores, e.g.
+auth/profile/datastore, and makes auth/logging write to the App Engine
+log of the request.
+*/
+package namespace
+
+import (
+	"appengine"
+	"github.com/gaego/auth/logging"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/context"
+	"net/http"
+)
+
+func init() {
+	logging.NewLogger = func(r *http.Request) logging.Logger {
+		return context.NewContext(r)
+	}
+}
+
+// NewContext returns the App Engine context of the request in the
+// namespace of its Tenant.
+func NewContext(r *http.Request) appengine.Context {
+	c := context.NewContext(r)
+	ns := tenant.Namespace(r)
+	if ns == "" {
+		return c
+	}
+	nc, err := appengine.Namespace(c, ns)
+	if err != nil {
+		// Tenants are validated by auth.RegisterTenant.
+		panic("auth/namespace: " + err.Error())
+	}
+	return nc
+}
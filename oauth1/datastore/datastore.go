@@ -0,0 +1,53 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/oauth1/datastore saves the request tokens of auth/oauth1 to
+the App Engine datastore, as "AuthRequestToken" entities. It is imported
+for its side effect:
+
+  import _ "github.com/gaego/auth/oauth1/datastore"
+*/
+package datastore
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/gaego/auth/namespace"
+	"github.com/gaego/auth/oauth1"
+	"net/http"
+)
+
+func init() {
+	oauth1.DefaultRequestTokenStore = RequestTokens{}
+}
+
+// RequestTokens saves request tokens to the App Engine datastore as
+// "AuthRequestToken" entities.
+type RequestTokens struct{}
+
+func (RequestTokens) Put(r *http.Request, token string, rt *oauth1.RequestToken) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthRequestToken", token, 0, nil), rt)
+	return err
+}
+
+func (RequestTokens) Take(r *http.Request, token string) (*oauth1.RequestToken, error) {
+	c := namespace.NewContext(r)
+	key := datastore.NewKey(c, "AuthRequestToken", token, 0, nil)
+	rt := new(oauth1.RequestToken)
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		err := datastore.Get(tc, key, rt)
+		if err == datastore.ErrNoSuchEntity {
+			return oauth1.ErrTokenExpired
+		} else if err != nil {
+			return err
+		}
+		return datastore.Delete(tc, key)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
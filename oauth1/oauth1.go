@@ -0,0 +1,440 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/oauth1 provides OAuth 1.0a authentication
+
+The Provider performs the three legs of the flow, signing each request
+with HMAC-SHA1:
+
+ 1. Start obtains a request token and returns the authorize URL. The
+    request token secret is saved to DefaultRequestTokenStore, and the
+    request token is bound to the browser with a cookie.
+ 2. Callback exchanges the request token and verifier for an access
+    token, if the callback comes from the browser that started the
+    flow.
+ 3. Client returns an *http.Client that signs requests with the access
+    token.
+*/
+package oauth1
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gaego/auth/fetch"
+	"github.com/gaego/auth/metrics"
+	"github.com/gaego/auth/origin"
+	"github.com/gaego/auth/tenant"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissingToken = errors.New("auth/oauth1: the callback is missing the oauth_token or oauth_verifier")
+	ErrTokenExpired = errors.New("auth/oauth1: the request token was not found or has expired")
+	ErrWrongBrowser = errors.New("auth/oauth1: the request token was started by another browser")
+	ErrNoStore      = errors.New("auth/oauth1: no RequestTokenStore is set, import github.com/gaego/auth/appengine or set DefaultRequestTokenStore")
+)
+
+// browserCookie carries the nonce that binds the request token to the
+// browser that started the flow, so that the callback of another
+// browser's request token, e.g. one sent by an attacker, is rejected.
+const browserCookie = "auth-oauth1"
+
+// RequestTokenExpiration is the duration a request token secret is kept
+// between the start and callback legs.
+var RequestTokenExpiration = 30 * time.Minute
+
+type Provider struct {
+	Name            string
+	URL             string
+	ConsumerKey     string
+	ConsumerSecret  string
+	RequestTokenURL string
+	AuthorizeURL    string
+	AccessTokenURL  string
+	// Transport is used for the requests to the provider, e.g. to use a
+	// proxy or custom TLS configuration. If nil fetch.NewTransport is
+	// used.
+	Transport http.RoundTripper
+}
+
+func New(name, url, consumerKey, consumerSecret, requestTokenURL,
+	authorizeURL, accessTokenURL string) *Provider {
+	return &Provider{
+		Name:            name,
+		URL:             url,
+		ConsumerKey:     consumerKey,
+		ConsumerSecret:  consumerSecret,
+		RequestTokenURL: requestTokenURL,
+		AuthorizeURL:    authorizeURL,
+		AccessTokenURL:  accessTokenURL,
+	}
+}
+
+// Token is a token and secret pair issued by the provider.
+type Token struct {
+	Token  string
+	Secret string
+	// Extra holds any additional parameters returned with the token,
+	// e.g. Twitter's "user_id" and "screen_name".
+	Extra url.Values
+}
+
+// RequestToken is the temporary credential saved between the start and
+// callback legs.
+type RequestToken struct {
+	Secret string `datastore:",noindex"`
+	// Browser is the SHA-256 hash of the nonce of the browserCookie.
+	Browser []byte `datastore:",noindex"`
+	Created time.Time
+}
+
+// hashNonce returns the hash of a browserCookie nonce.
+func hashNonce(nonce string) []byte {
+	h := sha256.Sum256([]byte(nonce))
+	return h[:]
+}
+
+// startedBy reports whether the browser of r started the flow of rt.
+func (rt *RequestToken) startedBy(r *http.Request) bool {
+	c, err := r.Cookie(browserCookie)
+	return err == nil && subtle.ConstantTimeCompare(hashNonce(c.Value), rt.Browser) == 1
+}
+
+// setBrowser sets, or with a negative maxAge removes, the browserCookie
+// on the path of the callback.
+func setBrowser(w http.ResponseWriter, r *http.Request, nonce string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     browserCookie,
+		Value:    nonce,
+		Path:     strings.TrimSuffix(r.URL.Path, "/callback") + "/callback",
+		MaxAge:   maxAge,
+		Secure:   origin.Scheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// IsCallback reports whether the request is for the callback leg of the
+// flow, e.g. /-/auth/twitter/callback.
+func IsCallback(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/callback")
+}
+
+// Describe returns the Name and URL of the Provider, for the login page
+// of auth.
+func (p *Provider) Describe() (name, url string) {
+	return p.Name, p.URL
+}
+
+// CallbackURL returns the URL the provider should redirect to after the
+// User has authorized the request token.
+func (p *Provider) CallbackURL(r *http.Request) string {
+	return origin.Callback(r)
+}
+
+// Start obtains a request token, saves its secret, binds it to the
+// browser with a cookie, and returns the URL of the provider's
+// authorization page.
+func (p *Provider) Start(w http.ResponseWriter, r *http.Request) (string, error) {
+	params := url.Values{"oauth_callback": {p.CallbackURL(r)}}
+	tok, err := p.post(r, p.RequestTokenURL, params, nil)
+	if err != nil {
+		return "", err
+	}
+	if tok.Extra.Get("oauth_callback_confirmed") != "true" {
+		return "", errors.New("auth/oauth1: the callback was not confirmed")
+	}
+	browser := nonce()
+	rt := &RequestToken{Secret: tok.Secret, Browser: hashNonce(browser), Created: time.Now()}
+	if err = DefaultRequestTokenStore.Put(r, tok.Token, rt); err != nil {
+		return "", err
+	}
+	setBrowser(w, r, browser, int(RequestTokenExpiration/time.Second))
+	return p.AuthorizeURL + "?oauth_token=" + url.QueryEscape(tok.Token), nil
+}
+
+// Callback exchanges the request token and verifier in the request for
+// an access token. The saved request token secret is deleted. The
+// request token must have been started by the browser of the request.
+func (p *Provider) Callback(w http.ResponseWriter, r *http.Request) (*Token, error) {
+	if r.FormValue("denied") != "" {
+		return nil, errors.New("auth/oauth1: access denied")
+	}
+	token := r.FormValue("oauth_token")
+	verifier := r.FormValue("oauth_verifier")
+	if token == "" || verifier == "" {
+		return nil, ErrMissingToken
+	}
+	// The request token may only be used once.
+	rt, err := DefaultRequestTokenStore.Take(r, token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(rt.Created) > RequestTokenExpiration {
+		return nil, ErrTokenExpired
+	}
+	if !rt.startedBy(r) {
+		return nil, ErrWrongBrowser
+	}
+	setBrowser(w, r, "", -1)
+	params := url.Values{"oauth_verifier": {verifier}}
+	m := metrics.Time(metrics.TokenExchange, metrics.Provider(r, p.Name))
+	tok, err := p.post(r, p.AccessTokenURL, params,
+		&Token{Token: token, Secret: rt.Secret})
+	m.Done(err)
+	return tok, err
+}
+
+// RequestTokenStore is implemented by the types that save the request
+// tokens between the start and callback legs.
+type RequestTokenStore interface {
+	// Put saves the request token.
+	Put(r *http.Request, token string, rt *RequestToken) error
+	// Take returns and deletes the request token. ErrTokenExpired is
+	// returned when it does not exist.
+	Take(r *http.Request, token string) (*RequestToken, error)
+}
+
+// DefaultRequestTokenStore is the RequestTokenStore of the Providers.
+// Until it is set, e.g. by importing auth/oauth1/datastore, it returns
+// ErrNoStore.
+var DefaultRequestTokenStore RequestTokenStore = noStore{}
+
+// noStore is the DefaultRequestTokenStore until one is set.
+type noStore struct{}
+
+func (noStore) Put(r *http.Request, token string, rt *RequestToken) error {
+	return ErrNoStore
+}
+
+func (noStore) Take(r *http.Request, token string) (*RequestToken, error) {
+	return nil, ErrNoStore
+}
+
+// MemoryRequestTokens keeps request tokens in memory. It is intended for
+// tests and development servers.
+type MemoryRequestTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*RequestToken
+}
+
+// NewMemoryRequestTokens creates an empty MemoryRequestTokens.
+func NewMemoryRequestTokens() *MemoryRequestTokens {
+	return &MemoryRequestTokens{tokens: make(map[string]*RequestToken)}
+}
+
+func (s *MemoryRequestTokens) Put(r *http.Request, token string, rt *RequestToken) error {
+	c := *rt
+	s.mu.Lock()
+	s.tokens[tenant.Key(r, token)] = &c
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryRequestTokens) Take(r *http.Request, token string) (*RequestToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenant.Key(r, token)
+	rt, ok := s.tokens[key]
+	if !ok {
+		return nil, ErrTokenExpired
+	}
+	delete(s.tokens, key)
+	return rt, nil
+}
+
+// Client returns an *http.Client that signs its requests with tok. The
+// requests are made with r's context.
+func (p *Provider) Client(r *http.Request, tok *Token) *http.Client {
+	return &http.Client{
+		Transport: &Transport{
+			Provider: p,
+			Token:    tok,
+			Base:     fetch.Transport(r, p.Transport),
+		},
+	}
+}
+
+// post makes a signed POST to a token endpoint and parses the form
+// encoded token response.
+func (p *Provider) post(r *http.Request, endpoint string, oauthParams url.Values,
+	tok *Token) (*Token, error) {
+
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", p.authorization(req.Method, endpoint, nil, oauthParams, tok))
+	res, err := fetch.Client(r, p.Transport).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth/oauth1: %s returned %s: %s", endpoint, res.Status, body)
+	}
+	v, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	nt := &Token{
+		Token:  v.Get("oauth_token"),
+		Secret: v.Get("oauth_token_secret"),
+		Extra:  v,
+	}
+	if nt.Token == "" {
+		return nil, fmt.Errorf("auth/oauth1: %s did not return a token", endpoint)
+	}
+	return nt, nil
+}
+
+// authorization builds the OAuth Authorization header for a request.
+// params are the query and form parameters of the request; oauthParams
+// are any additional protocol parameters such as oauth_callback.
+func (p *Provider) authorization(method, rawurl string, params, oauthParams url.Values,
+	tok *Token) string {
+
+	op := url.Values{
+		"oauth_consumer_key":     {p.ConsumerKey},
+		"oauth_nonce":            {nonce()},
+		"oauth_signature_method": {"HMAC-SHA1"},
+		"oauth_timestamp":        {strconv.FormatInt(time.Now().Unix(), 10)},
+		"oauth_version":          {"1.0"},
+	}
+	for k, v := range oauthParams {
+		op[k] = v
+	}
+	var tokenSecret string
+	if tok != nil {
+		op.Set("oauth_token", tok.Token)
+		tokenSecret = tok.Secret
+	}
+	all := url.Values{}
+	for k, v := range params {
+		all[k] = append(all[k], v...)
+	}
+	for k, v := range op {
+		all[k] = append(all[k], v...)
+	}
+	op.Set("oauth_signature", signature(method, rawurl, all, p.ConsumerSecret, tokenSecret))
+
+	keys := make([]string, 0, len(op))
+	for k := range op {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := make([]string, len(keys))
+	for i, k := range keys {
+		h[i] = fmt.Sprintf(`%s="%s"`, escape(k), escape(op.Get(k)))
+	}
+	return "OAuth " + strings.Join(h, ", ")
+}
+
+// signature returns the HMAC-SHA1 signature of a request as described in
+// RFC 5849 section 3.4.
+func signature(method, rawurl string, params url.Values, consumerSecret,
+	tokenSecret string) string {
+
+	u, _ := url.Parse(rawurl)
+	baseURL := fmt.Sprintf("%s://%s%s", strings.ToLower(u.Scheme),
+		strings.ToLower(u.Host), u.EscapedPath())
+
+	pairs := make([]string, 0, len(params))
+	for k, vs := range params {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(pairs)
+
+	base := strings.ToUpper(method) + "&" + escape(baseURL) + "&" +
+		escape(strings.Join(pairs, "&"))
+	key := escape(consumerSecret) + "&" + escape(tokenSecret)
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// escape percent encodes s as described in RFC 5849 section 3.6. Only
+// the unreserved characters are left as is.
+func escape(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b = append(b, c)
+		default:
+			b = append(b, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+	return string(b)
+}
+
+func nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Transport is an http.RoundTripper that signs requests with a Token.
+type Transport struct {
+	Provider *Provider
+	Token    *Token
+	// Base is the RoundTripper used to make the signed requests.
+	Base http.RoundTripper
+}
+
+// RoundTrip signs a copy of the request and sends it with Base. Only
+// form encoded bodies are included in the signature.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	params := req.URL.Query()
+	if req.Body != nil && strings.HasPrefix(req.Header.Get("Content-Type"),
+		"application/x-www-form-urlencoded") {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range form {
+			params[k] = append(params[k], v...)
+		}
+		req.Body = ioutil.NopCloser(strings.NewReader(string(b)))
+	}
+	u := *req.URL
+	u.RawQuery = ""
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set("Authorization",
+		t.Provider.authorization(req.Method, u.String(), params, nil, t.Token))
+	return t.Base.RoundTrip(r2)
+}
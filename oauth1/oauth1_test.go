@@ -0,0 +1,118 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oauth1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	if x := escape("Ladies + Gentlemen"); x != "Ladies%20%2B%20Gentlemen" {
+		t.Errorf(`escape: %v, want "Ladies%%20%%2B%%20Gentlemen"`, x)
+	}
+	if x := escape("An encoded string!"); x != "An%20encoded%20string%21" {
+		t.Errorf(`escape: %v, want "An%%20encoded%%20string%%21"`, x)
+	}
+	if x := escape("Dogs, Cats & Mice"); x != "Dogs%2C%20Cats%20%26%20Mice" {
+		t.Errorf(`escape: %v, want "Dogs%%2C%%20Cats%%20%%26%%20Mice"`, x)
+	}
+	if x := escape("☃"); x != "%E2%98%83" {
+		t.Errorf(`escape: %v, want "%%E2%%98%%83"`, x)
+	}
+}
+
+// TestSignature uses the example from Twitter's "Creating a signature"
+// documentation.
+func TestSignature(t *testing.T) {
+	params := url.Values{
+		"status":                 {"Hello Ladies + Gentlemen, a signed OAuth request!"},
+		"include_entities":       {"true"},
+		"oauth_consumer_key":     {"xvz1evFS4wEEPTGEFPHBog"},
+		"oauth_nonce":            {"kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg"},
+		"oauth_signature_method": {"HMAC-SHA1"},
+		"oauth_timestamp":        {"1318622958"},
+		"oauth_token":            {"370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb"},
+		"oauth_version":          {"1.0"},
+	}
+	s := signature("POST", "https://api.twitter.com/1/statuses/update.json", params,
+		"kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		"LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE")
+	if s != "tnnArxj06cWHq44gCs1OSKk/jLY=" {
+		t.Errorf(`signature: %v, want "tnnArxj06cWHq44gCs1OSKk/jLY="`, s)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	p := New("Example", "http://example.com", "key", "secret",
+		"http://example.com/request_token", "http://example.com/authorize",
+		"http://example.com/access_token")
+	h := p.authorization("POST", p.RequestTokenURL, nil,
+		url.Values{"oauth_callback": {"http://localhost:8080/-/auth/example/callback"}}, nil)
+	if !strings.HasPrefix(h, "OAuth ") {
+		t.Errorf(`h: %v, want prefix "OAuth "`, h)
+	}
+	for _, k := range []string{"oauth_callback", "oauth_consumer_key", "oauth_nonce",
+		"oauth_signature", "oauth_signature_method", "oauth_timestamp", "oauth_version"} {
+		if !strings.Contains(h, k+`="`) {
+			t.Errorf(`h: %v, want %v`, h, k)
+		}
+	}
+	if strings.Contains(h, "oauth_token=") {
+		t.Errorf(`h: %v, should not contain oauth_token`, h)
+	}
+}
+
+func TestCallbackURL(t *testing.T) {
+	p := New("Twitter", "https://twitter.com", "key", "secret", "", "", "")
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/twitter", nil)
+	if x := p.CallbackURL(r); x != "http://localhost:8080/-/auth/twitter/callback" {
+		t.Errorf(`CallbackURL: %v, want "http://localhost:8080/-/auth/twitter/callback"`, x)
+	}
+}
+
+func TestStartedBy(t *testing.T) {
+	// The start leg sets the cookie on the path of the callback.
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "https://example.com/-/auth/twitter", nil)
+	setBrowser(w, r, "n", 600)
+	c := w.Result().Cookies()[0]
+	if c.Path != "/-/auth/twitter/callback" || !c.Secure || !c.HttpOnly {
+		t.Errorf(`cookie: %v, want a secure cookie of the callback`, c)
+	}
+
+	rt := &RequestToken{Browser: hashNonce("n")}
+	r, _ = http.NewRequest("GET", "https://example.com/-/auth/twitter/callback", nil)
+	if rt.startedBy(r) {
+		t.Errorf(`startedBy: true without the cookie, want false`)
+	}
+	r.AddCookie(&http.Cookie{Name: browserCookie, Value: "other"})
+	if rt.startedBy(r) {
+		t.Errorf(`startedBy: true with another nonce, want false`)
+	}
+	r.Header.Del("Cookie")
+	r.AddCookie(c)
+	if !rt.startedBy(r) {
+		t.Errorf(`startedBy: false, want true`)
+	}
+}
+
+func TestMemoryRequestTokens(t *testing.T) {
+	s := NewMemoryRequestTokens()
+	r, _ := http.NewRequest("GET", "https://example.com/-/auth/twitter/callback", nil)
+	if err := s.Put(r, "t1", &RequestToken{Secret: "s1"}); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if rt, err := s.Take(r, "t1"); err != nil || rt.Secret != "s1" {
+		t.Errorf(`rt: %v, err: %v, want the request token`, rt, err)
+	}
+	// The request token may only be used once.
+	if _, err := s.Take(r, "t1"); err != ErrTokenExpired {
+		t.Errorf(`err: %v, want %v`, err, ErrTokenExpired)
+	}
+}
@@ -8,16 +8,37 @@ Package auth/oauth2 provides OAuth2 authentication
 package oauth2
 
 import (
-	"appengine/urlfetch"
 	"code.google.com/p/goauth2/oauth"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/gaego/auth/fetch"
+	"github.com/gaego/auth/metrics"
+	"github.com/gaego/auth/origin"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
+var (
+	ErrMissingCode           = errors.New("auth/oauth2: the callback is missing the authorization code")
+	ErrEmailDomainNotAllowed = errors.New("auth/oauth2: the account has no verified email address in an allowed domain")
+	ErrInvalidState          = errors.New("auth/oauth2: the callback state does not match the browser that started the flow")
+)
+
+// stateCookie carries the state parameter of the flow, so that the
+// callback of another browser's authorization code, e.g. one sent by an
+// attacker to log the victim in to the attacker's account, is rejected.
+const stateCookie = "auth-oauth2-state"
+
+// StateExpiration is the duration the state is kept between the start
+// and callback legs.
+var StateExpiration = 10 * time.Minute
+
 type Provider struct {
 	Name         string
 	URL          string
@@ -26,7 +47,21 @@ type Provider struct {
 	Scope        string
 	AuthURL      string
 	TokenURL     string
-	RedirectURL  string
+	// RedirectURL, if set, replaces the callback URL of the request,
+	// e.g. "/auth/google/callback". Paths are resolved against the
+	// origin of auth/origin.
+	RedirectURL string
+	// Transport is used for the requests to the provider, e.g. to use a
+	// proxy or custom TLS configuration. If nil fetch.NewTransport is
+	// used.
+	Transport http.RoundTripper
+	// EmailDomains, if set, restrict the logins to accounts with a
+	// verified email address in one of the domains, e.g. "example.com".
+	EmailDomains []string
+	// FormPost requests the callback as a POST from the provider's site
+	// (response_mode=form_post), e.g. for Apple. The state cookie is
+	// then sent with SameSite=None.
+	FormPost bool
 }
 
 func New(name, url, clientID, clientSecret, scope, authURL, tokenURL string) *Provider {
@@ -42,33 +77,138 @@ func New(name, url, clientID, clientSecret, scope, authURL, tokenURL string) *Pr
 }
 
 // Config returns the configuration information for OAuth2.
-func (p *Provider) Config(url *url.URL) *oauth.Config {
+func (p *Provider) Config(r *http.Request) *oauth.Config {
 	return &oauth.Config{
 		ClientId:     p.ClientID,
 		ClientSecret: p.ClientSecret,
 		Scope:        p.Scope,
 		AuthURL:      p.AuthURL,
 		TokenURL:     p.TokenURL,
-		RedirectURL: fmt.Sprintf("%s://%s/-/auth/%s/callback", url.Scheme, url.Host,
-			strings.ToLower(p.Name)),
+		RedirectURL:  p.CallbackURL(r),
+	}
+}
+
+// Describe returns the Name and URL of the Provider, for the login page
+// of auth.
+func (p *Provider) Describe() (name, url string) {
+	return p.Name, p.URL
+}
+
+// CallbackURL returns the redirect URI of the request's flow. It is the
+// same for the start and callback legs.
+func (p *Provider) CallbackURL(r *http.Request) string {
+	if p.RedirectURL != "" {
+		return origin.Resolve(r, p.RedirectURL)
+	}
+	return origin.Callback(r)
+}
+
+// CheckEmail returns ErrEmailDomainNotAllowed unless there are no
+// EmailDomains, or the email address is verified and in one of them.
+func (p *Provider) CheckEmail(email string, verified bool) error {
+	if len(p.EmailDomains) == 0 {
+		return nil
+	}
+	i := strings.LastIndex(email, "@")
+	if !verified || i < 0 {
+		return ErrEmailDomainNotAllowed
+	}
+	domain := email[i+1:]
+	for _, d := range p.EmailDomains {
+		if strings.EqualFold(d, domain) {
+			return nil
+		}
+	}
+	return ErrEmailDomainNotAllowed
+}
+
+// IsCallback reports whether the request is for the callback leg of the
+// flow, e.g. /-/auth/google/callback.
+func IsCallback(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/callback")
+}
+
+// Start returns the URL of the provider's authorization page. A random
+// state is included in the URL and set in a cookie of w, to be checked
+// by Callback.
+func (p *Provider) Start(w http.ResponseWriter, r *http.Request) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := base64.RawURLEncoding.EncodeToString(b)
+	p.setState(w, r, state, int(StateExpiration/time.Second))
+	u := p.Config(r).AuthCodeURL(state)
+	if p.FormPost {
+		u += "&response_mode=form_post"
 	}
+	return u
 }
 
-func (p *Provider) start(r *http.Request) string {
-	return p.Config(r.URL).AuthCodeURL(r.URL.RawQuery)
+// setState sets, or with a negative maxAge removes, the stateCookie on
+// the path of the callback.
+func (p *Provider) setState(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+	c := &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   origin.Scheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if u, err := url.Parse(p.CallbackURL(r)); err == nil && u.Path != "" {
+		c.Path = u.Path
+	}
+	if p.FormPost {
+		// Browsers only accept SameSite=None when it is Secure.
+		c.SameSite = http.SameSiteNoneMode
+		c.Secure = true
+	}
+	http.SetCookie(w, c)
 }
 
-func (p *Provider) callback(r *http.Request) error {
+// checkState reports whether the state of the callback r matches the
+// stateCookie.
+func checkState(r *http.Request) bool {
+	c, err := r.Cookie(stateCookie)
+	state := r.FormValue("state")
+	return err == nil && state != "" &&
+		subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) == 1
+}
+
+// Client returns an *http.Client for making requests to the provider
+// during r. The requests are made with r's context.
+func (p *Provider) Client(r *http.Request) *http.Client {
+	return fetch.Client(r, p.Transport)
+}
+
+// Callback exchanges the authorization code in the request for an access
+// token. The returned Transport holds the token and may be used to make
+// authorized requests to the provider. ErrInvalidState is returned unless
+// the state matches the cookie set by Start, which is then removed.
+func (p *Provider) Callback(w http.ResponseWriter, r *http.Request) (*oauth.Transport, error) {
+	if !checkState(r) {
+		return nil, ErrInvalidState
+	}
+	p.setState(w, r, "", -1)
+	if e := r.FormValue("error"); e != "" {
+		return nil, fmt.Errorf("auth/oauth2: %s", e)
+	}
 	// Exchange code for an access token at OAuth provider.
 	code := r.FormValue("code")
+	if code == "" {
+		return nil, ErrMissingCode
+	}
 	t := &oauth.Transport{
-		Config: p.Config(r.URL),
-		Transport: &urlfetch.Transport{
-			Context: context.NewContext(r),
-		},
+		Config:    p.Config(r),
+		Transport: fetch.Transport(r, p.Transport),
 	}
+	m := metrics.Time(metrics.TokenExchange, metrics.Provider(r, p.Name))
 	_, err := t.Exchange(code)
-	return err
+	m.Done(err)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 func (p *Provider) Authenticate(r *http.Request) (
@@ -0,0 +1,508 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tenant"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csrfCookie holds the token that the consent form must post back.
+const csrfCookie = "oidc-csrf"
+
+// ConsentTemplate renders the consent page. It is executed with a
+// ConsentPage.
+var ConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.Client.Name}}</title></head>
+<body>
+<form method="POST" action="{{.Action}}">
+  <p>{{.Client.Name}} would like to:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+  {{end}}<input type="hidden" name="csrf" value="{{.CSRF}}">
+  <button type="submit" name="consent" value="allow">Allow</button>
+  <button type="submit" name="consent" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// ConsentPage is the data of ConsentTemplate.
+type ConsentPage struct {
+	Client *Client
+	// Scopes are the descriptions of the requested scopes.
+	Scopes []string
+	// Action is the URL the form posts to.
+	Action string
+	// Params are the parameters of the authorization request, to be
+	// posted back as hidden fields.
+	Params url.Values
+	CSRF   string
+}
+
+// authRequest is an authorization request of a Client.
+type authRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scopes              []string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+func parseAuthRequest(r *http.Request) *authRequest {
+	q := &authRequest{
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		ResponseType:        r.FormValue("response_type"),
+		State:               r.FormValue("state"),
+		Nonce:               r.FormValue("nonce"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Prompt:              r.FormValue("prompt"),
+	}
+	q.Scopes = parseScopes(r.FormValue("scope"))
+	return q
+}
+
+// parseScopes returns the known Scopes of a scope parameter. Unknown
+// scopes are ignored, as in RFC 6749 3.3.
+func parseScopes(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if _, ok := Scopes[s]; ok && !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// params returns the parameters of the request, e.g. to continue it
+// after the login or the consent.
+func (q *authRequest) params() url.Values {
+	v := url.Values{
+		"client_id":     {q.ClientID},
+		"redirect_uri":  {q.RedirectURI},
+		"response_type": {q.ResponseType},
+		"scope":         {strings.Join(q.Scopes, " ")},
+	}
+	for k, s := range map[string]string{
+		"state":                 q.State,
+		"nonce":                 q.Nonce,
+		"code_challenge":        q.CodeChallenge,
+		"code_challenge_method": q.CodeChallengeMethod,
+	} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	return v
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect returns to the Client with the parameters and the state of
+// the request.
+func (q *authRequest) redirect(w http.ResponseWriter, r *http.Request, v url.Values) {
+	if q.State != "" {
+		v.Set("state", q.State)
+	}
+	u, _ := url.Parse(q.RedirectURI)
+	p := u.Query()
+	for k := range v {
+		p.Set(k, v.Get(k))
+	}
+	u.RawQuery = p.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// fail returns an error to the Client, as in RFC 6749 4.1.2.1.
+func (q *authRequest) fail(w http.ResponseWriter, r *http.Request, code, desc string) {
+	q.redirect(w, r, url.Values{"error": {code}, "error_description": {desc}})
+}
+
+// AuthorizeHandler is the authorization endpoint. It logs in the User,
+// asks for their consent and returns an authorization code to the
+// Client's redirect URI.
+func AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := parseAuthRequest(r)
+	c, err := DefaultClients.Get(r, q.ClientID)
+	if err == ErrNoSuchClient {
+		http.Error(w, "auth/oidc: unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "auth/oidc: unable to load the client", http.StatusInternalServerError)
+		return
+	}
+	// The errors are only returned to a registered redirect URI, so that
+	// the endpoint can't be used to redirect to another site.
+	if !c.AllowsRedirect(q.RedirectURI) {
+		http.Error(w, "auth/oidc: invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.ResponseType != "code" {
+		q.fail(w, r, "unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	if !hasScope(q.Scopes, "openid") {
+		q.fail(w, r, "invalid_scope", "the openid scope is required")
+		return
+	}
+	if q.CodeChallenge != "" && q.CodeChallengeMethod != "S256" {
+		q.fail(w, r, "invalid_request", "code_challenge_method must be S256")
+		return
+	}
+	if q.CodeChallenge == "" && c.Public() {
+		q.fail(w, r, "invalid_request", "public clients must use PKCE")
+		return
+	}
+	l, err := session.CurrentLogin(r)
+	if err != nil || l.UserID == "" {
+		if q.Prompt == "none" {
+			q.fail(w, r, "login_required", "the user is not logged in")
+			return
+		}
+		next := BaseURL + "authorize?" + q.params().Encode()
+		http.Redirect(w, r, auth.LoginRedirectURL(r, next), http.StatusFound)
+		return
+	}
+	if !c.Trusted {
+		ok, err := consented(w, r, q, c, l.UserID)
+		if err != nil {
+			q.fail(w, r, "server_error", "unable to save the consent")
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	code, err := newGrant(r, q, l)
+	if err != nil {
+		q.fail(w, r, "server_error", "unable to create the authorization code")
+		return
+	}
+	q.redirect(w, r, url.Values{"code": {code}})
+}
+
+// consented reports whether the User has consented to the request. If
+// not, the consent page, or an error, has been written to w.
+func consented(w http.ResponseWriter, r *http.Request, q *authRequest,
+	c *Client, userID string) (bool, error) {
+
+	if r.Method == "POST" && r.FormValue("consent") != "" {
+		ck, err := r.Cookie(csrfCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(ck.Value),
+			[]byte(r.FormValue("csrf"))) != 1 {
+			http.Error(w, "auth/oidc: invalid consent form", http.StatusForbidden)
+			return false, nil
+		}
+		if r.FormValue("consent") != "allow" {
+			q.fail(w, r, "access_denied", "the user denied the request")
+			return false, nil
+		}
+		err = DefaultConsents.Put(r, &Consent{
+			UserID:   userID,
+			ClientID: c.ID,
+			Scopes:   q.Scopes,
+			Created:  time.Now(),
+		})
+		return err == nil, err
+	}
+	con, err := DefaultConsents.Get(r, userID, c.ID)
+	if err != nil && err != ErrNoSuchConsent {
+		return false, err
+	}
+	if err == nil && con.Covers(q.Scopes) && q.Prompt != "consent" {
+		return true, nil
+	}
+	if q.Prompt == "none" {
+		q.fail(w, r, "consent_required", "the user has not consented")
+		return false, nil
+	}
+	csrf := random(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrf,
+		Path:     BaseURL,
+		Secure:   session.DefaultOptions.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	p := &ConsentPage{
+		Client: c,
+		Action: BaseURL + "authorize",
+		Params: q.params(),
+		CSRF:   csrf,
+	}
+	for _, s := range q.Scopes {
+		p.Scopes = append(p.Scopes, Scopes[s])
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	return false, ConsentTemplate.Execute(w, p)
+}
+
+// Grant is the authorization of a Client that an authorization code is
+// exchanged for.
+type Grant struct {
+	// ID is the hash of the authorization code.
+	ID       string `datastore:"-"`
+	ClientID string
+	UserID   string
+	// Provider and AuthTime are those of the User's login.
+	Provider    string    `datastore:",noindex"`
+	AuthTime    time.Time `datastore:",noindex"`
+	RedirectURI string    `datastore:",noindex"`
+	Scopes      []string  `datastore:",noindex"`
+	Nonce       string    `datastore:",noindex"`
+	// CodeChallenge is the S256 PKCE challenge, if any.
+	CodeChallenge string `datastore:",noindex"`
+	Expires       time.Time
+}
+
+// codeID returns the ID of the Grant of an authorization code.
+func codeID(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// newGrant saves the Grant of the request and returns its authorization
+// code.
+func newGrant(r *http.Request, q *authRequest, l *session.LoginInfo) (string, error) {
+	code := random(32)
+	err := DefaultGrants.Put(r, &Grant{
+		ID:            codeID(code),
+		ClientID:      q.ClientID,
+		UserID:        l.UserID,
+		Provider:      l.Provider,
+		AuthTime:      l.AuthTime,
+		RedirectURI:   q.RedirectURI,
+		Scopes:        q.Scopes,
+		Nonce:         q.Nonce,
+		CodeChallenge: q.CodeChallenge,
+		Expires:       time.Now().Add(CodeExpiration),
+	})
+	return code, err
+}
+
+// verifyChallenge reports whether the PKCE verifier matches the S256
+// challenge, as in RFC 7636 4.6.
+func verifyChallenge(challenge, verifier string) bool {
+	h := sha256.Sum256([]byte(verifier))
+	s := base64.RawURLEncoding.EncodeToString(h[:])
+	return subtle.ConstantTimeCompare([]byte(s), []byte(challenge)) == 1
+}
+
+// GrantStore is implemented by the types that persist Grants.
+type GrantStore interface {
+	// Get returns the Grant with the ID, or ErrNoSuchGrant.
+	Get(r *http.Request, id string) (*Grant, error)
+	Put(r *http.Request, g *Grant) error
+	Delete(r *http.Request, id string) error
+	// Take returns the Grant with the ID and deletes it in one
+	// transaction, so that its authorization code is only redeemed
+	// once, or returns ErrNoSuchGrant.
+	Take(r *http.Request, id string) (*Grant, error)
+}
+
+// DefaultGrants is the GrantStore of the authorization codes. Until it
+// is set, e.g. by importing auth/oidc/datastore, it returns ErrNoStore.
+var DefaultGrants GrantStore = noGrants{}
+
+// noGrants is the DefaultGrants until one is set.
+type noGrants struct{}
+
+func (noGrants) Get(r *http.Request, id string) (*Grant, error) {
+	return nil, ErrNoStore
+}
+
+func (noGrants) Put(r *http.Request, g *Grant) error {
+	return ErrNoStore
+}
+
+func (noGrants) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+func (noGrants) Take(r *http.Request, id string) (*Grant, error) {
+	return nil, ErrNoStore
+}
+
+// Consent is a User's consent to the scopes requested by a Client.
+type Consent struct {
+	UserID   string    `json:"-"`
+	ClientID string    `json:"clientId"`
+	Scopes   []string  `datastore:",noindex" json:"scopes"`
+	Created  time.Time `json:"created"`
+}
+
+// Covers reports whether the Consent includes all of the scopes.
+func (con *Consent) Covers(scopes []string) bool {
+	for _, s := range scopes {
+		if !hasScope(con.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsentStore is implemented by the types that persist Consents.
+type ConsentStore interface {
+	// Get returns the User's Consent to the Client, or
+	// ErrNoSuchConsent.
+	Get(r *http.Request, userID, clientID string) (*Consent, error)
+	Put(r *http.Request, con *Consent) error
+	Delete(r *http.Request, userID, clientID string) error
+	// List returns the Consents of the User.
+	List(r *http.Request, userID string) ([]*Consent, error)
+}
+
+// DefaultConsents is the ConsentStore of the Users' consents. Until it
+// is set, e.g. by importing auth/oidc/datastore, it returns ErrNoStore.
+var DefaultConsents ConsentStore = noConsents{}
+
+// noConsents is the DefaultConsents until one is set.
+type noConsents struct{}
+
+func (noConsents) Get(r *http.Request, userID, clientID string) (*Consent, error) {
+	return nil, ErrNoStore
+}
+
+func (noConsents) Put(r *http.Request, con *Consent) error {
+	return ErrNoStore
+}
+
+func (noConsents) Delete(r *http.Request, userID, clientID string) error {
+	return ErrNoStore
+}
+
+func (noConsents) List(r *http.Request, userID string) ([]*Consent, error) {
+	return nil, ErrNoStore
+}
+
+// MemoryGrants keeps Grants in memory. It is intended for tests and
+// single instance development servers.
+type MemoryGrants struct {
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// NewMemoryGrants creates an empty MemoryGrants.
+func NewMemoryGrants() *MemoryGrants {
+	return &MemoryGrants{grants: make(map[string]Grant)}
+}
+
+func (s *MemoryGrants) Get(r *http.Request, id string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSuchGrant
+	}
+	return &g, nil
+}
+
+func (s *MemoryGrants) Put(r *http.Request, g *Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grants[tenant.Key(r, g.ID)] = *g
+	return nil
+}
+
+func (s *MemoryGrants) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.grants, tenant.Key(r, id))
+	return nil
+}
+
+func (s *MemoryGrants) Take(r *http.Request, id string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenant.Key(r, id)
+	g, ok := s.grants[key]
+	if !ok {
+		return nil, ErrNoSuchGrant
+	}
+	delete(s.grants, key)
+	return &g, nil
+}
+
+// MemoryConsents keeps Consents in memory. It is intended for tests and
+// development servers.
+type MemoryConsents struct {
+	mu       sync.RWMutex
+	consents map[string]Consent
+}
+
+// NewMemoryConsents creates an empty MemoryConsents.
+func NewMemoryConsents() *MemoryConsents {
+	return &MemoryConsents{consents: make(map[string]Consent)}
+}
+
+// ConsentID returns the ID of the User's Consent to the Client.
+func ConsentID(userID, clientID string) string {
+	return userID + "|" + clientID
+}
+
+func (s *MemoryConsents) Get(r *http.Request, userID, clientID string) (*Consent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	con, ok := s.consents[tenant.Key(r, ConsentID(userID, clientID))]
+	if !ok {
+		return nil, ErrNoSuchConsent
+	}
+	return &con, nil
+}
+
+func (s *MemoryConsents) Put(r *http.Request, con *Consent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consents[tenant.Key(r, ConsentID(con.UserID, con.ClientID))] = *con
+	return nil
+}
+
+func (s *MemoryConsents) Delete(r *http.Request, userID, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.consents, tenant.Key(r, ConsentID(userID, clientID)))
+	return nil
+}
+
+func (s *MemoryConsents) List(r *http.Request, userID string) ([]*Consent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var cs []*Consent
+	for k, con := range s.consents {
+		if con.UserID == userID && tenant.InNamespace(r, k) {
+			con := con
+			cs = append(cs, &con)
+		}
+	}
+	return cs, nil
+}
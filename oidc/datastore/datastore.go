@@ -0,0 +1,205 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/oidc/datastore saves the Clients, Grants, Consents and
+Devices of auth/oidc to the App Engine datastore. It is imported for its
+side effect:
+
+  import _ "github.com/gaego/auth/oidc/datastore"
+*/
+package datastore
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/gaego/auth/namespace"
+	"github.com/gaego/auth/oidc"
+	"net/http"
+)
+
+func init() {
+	oidc.DefaultClients = Clients{}
+	oidc.DefaultGrants = Grants{}
+	oidc.DefaultConsents = Consents{}
+	oidc.DefaultDevices = Devices{}
+}
+
+// Clients saves Clients to the App Engine datastore as
+// "AuthOIDCClient" entities.
+type Clients struct{}
+
+func (Clients) Get(r *http.Request, id string) (*oidc.Client, error) {
+	c := namespace.NewContext(r)
+	cl := new(oidc.Client)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthOIDCClient", id, 0, nil), cl)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, oidc.ErrNoSuchClient
+	}
+	cl.ID = id
+	return cl, err
+}
+
+func (Clients) Put(r *http.Request, cl *oidc.Client) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthOIDCClient", cl.ID, 0, nil), cl)
+	return err
+}
+
+func (Clients) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthOIDCClient", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Clients) List(r *http.Request) ([]*oidc.Client, error) {
+	c := namespace.NewContext(r)
+	var cs []*oidc.Client
+	keys, err := datastore.NewQuery("AuthOIDCClient").GetAll(c, &cs)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		cs[i].ID = k.StringID()
+	}
+	return cs, nil
+}
+
+// Grants saves Grants to the App Engine datastore as
+// "AuthOIDCGrant" entities.
+type Grants struct{}
+
+func (Grants) Get(r *http.Request, id string) (*oidc.Grant, error) {
+	c := namespace.NewContext(r)
+	g := new(oidc.Grant)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthOIDCGrant", id, 0, nil), g)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, oidc.ErrNoSuchGrant
+	}
+	g.ID = id
+	return g, err
+}
+
+func (Grants) Put(r *http.Request, g *oidc.Grant) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthOIDCGrant", g.ID, 0, nil), g)
+	return err
+}
+
+func (Grants) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthOIDCGrant", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Grants) Take(r *http.Request, id string) (*oidc.Grant, error) {
+	c := namespace.NewContext(r)
+	key := datastore.NewKey(c, "AuthOIDCGrant", id, 0, nil)
+	g := new(oidc.Grant)
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		err := datastore.Get(tc, key, g)
+		if err == datastore.ErrNoSuchEntity {
+			return oidc.ErrNoSuchGrant
+		} else if err != nil {
+			return err
+		}
+		return datastore.Delete(tc, key)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.ID = id
+	return g, nil
+}
+
+// Consents saves Consents to the App Engine datastore as
+// "AuthOIDCConsent" entities.
+type Consents struct{}
+
+func (Consents) Get(r *http.Request, userID, clientID string) (*oidc.Consent, error) {
+	c := namespace.NewContext(r)
+	con := new(oidc.Consent)
+	key := datastore.NewKey(c, "AuthOIDCConsent", oidc.ConsentID(userID, clientID), 0, nil)
+	err := datastore.Get(c, key, con)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, oidc.ErrNoSuchConsent
+	}
+	return con, err
+}
+
+func (Consents) Put(r *http.Request, con *oidc.Consent) error {
+	c := namespace.NewContext(r)
+	key := datastore.NewKey(c, "AuthOIDCConsent", oidc.ConsentID(con.UserID, con.ClientID), 0, nil)
+	_, err := datastore.Put(c, key, con)
+	return err
+}
+
+func (Consents) Delete(r *http.Request, userID, clientID string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthOIDCConsent",
+		oidc.ConsentID(userID, clientID), 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Consents) List(r *http.Request, userID string) ([]*oidc.Consent, error) {
+	c := namespace.NewContext(r)
+	var cs []*oidc.Consent
+	_, err := datastore.NewQuery("AuthOIDCConsent").
+		Filter("UserID =", userID).GetAll(c, &cs)
+	return cs, err
+}
+
+// Devices saves Devices to the App Engine datastore as
+// "AuthOIDCDevice" entities.
+type Devices struct{}
+
+func (Devices) Get(r *http.Request, id string) (*oidc.Device, error) {
+	c := namespace.NewContext(r)
+	d := new(oidc.Device)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthOIDCDevice", id, 0, nil), d)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, oidc.ErrNoSuchDevice
+	}
+	d.ID = id
+	return d, err
+}
+
+func (Devices) GetByUserCode(r *http.Request, code string) (*oidc.Device, error) {
+	c := namespace.NewContext(r)
+	var ds []*oidc.Device
+	keys, err := datastore.NewQuery("AuthOIDCDevice").
+		Filter("UserCode =", code).Limit(1).GetAll(c, &ds)
+	if err != nil {
+		return nil, err
+	}
+	if len(ds) == 0 {
+		return nil, oidc.ErrNoSuchDevice
+	}
+	ds[0].ID = keys[0].StringID()
+	return ds[0], nil
+}
+
+func (Devices) Put(r *http.Request, d *oidc.Device) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthOIDCDevice", d.ID, 0, nil), d)
+	return err
+}
+
+func (Devices) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthOIDCDevice", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
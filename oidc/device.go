@@ -0,0 +1,418 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/token"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchDevice = errors.New("auth/oidc: no such device code")
+)
+
+// DeviceCodeGrant is the grant_type of the device flow's token requests.
+const DeviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+var (
+	// DeviceCodeExpiration is how long the User has to approve a device.
+	DeviceCodeExpiration = 10 * time.Minute
+	// DeviceInterval is the minimum time between two token requests of
+	// a device, in seconds. It is increased by 5 seconds each time a
+	// device polls too often.
+	DeviceInterval = 5
+	// DeviceKeyExpiration is the lifetime of the API keys issued to
+	// devices. Zero issues keys that don't expire.
+	DeviceKeyExpiration = 90 * 24 * time.Hour
+)
+
+// The Status of a Device.
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+)
+
+// Device is the authorization request of a device.
+type Device struct {
+	// ID is the hash of the device code.
+	ID       string `datastore:"-"`
+	UserCode string
+	ClientID string
+	Scopes   []string `datastore:",noindex"`
+	Status   string
+	// UserID is the User who approved the Device.
+	UserID     string
+	Interval   int
+	LastPolled time.Time
+	Expires    time.Time
+}
+
+// userCodeChars are the characters of the user codes. There are no
+// vowels, so the codes don't spell words, and none that are easily
+// confused with one another.
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+// newUserCode returns a random user code, e.g. "WDJB-MJHT".
+func newUserCode() string {
+	b := make([]byte, 0, 8)
+	rb := make([]byte, 16)
+	for len(b) < 8 {
+		if _, err := rand.Read(rb); err != nil {
+			panic("auth/oidc: unable to generate a user code: " + err.Error())
+		}
+		for _, c := range rb {
+			// Larger bytes are skipped so that each character is as
+			// likely.
+			if int(c) < 256-256%len(userCodeChars) && len(b) < 8 {
+				b = append(b, userCodeChars[int(c)%len(userCodeChars)])
+			}
+		}
+	}
+	return string(b[:4]) + "-" + string(b[4:])
+}
+
+// normalizeUserCode returns the user code as entered by a User in the
+// form of newUserCode.
+func normalizeUserCode(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer("-", "", " ", "").Replace(s)
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:]
+}
+
+// DeviceReply is the response of the device authorization endpoint.
+type DeviceReply struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceCodeHandler is the device authorization endpoint. It returns
+// the codes of a new Device.
+func DeviceCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, &errorReply{Error: "invalid_request"})
+		return
+	}
+	c, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if !c.Trusted {
+		writeJSON(w, http.StatusBadRequest, &errorReply{
+			Error:       "unauthorized_client",
+			Description: "only trusted clients may use the device flow",
+		})
+		return
+	}
+	code := random(32)
+	d := &Device{
+		ID:       codeID(code),
+		UserCode: newUserCode(),
+		ClientID: c.ID,
+		Scopes:   parseScopes(r.FormValue("scope")),
+		Status:   DevicePending,
+		Interval: DeviceInterval,
+		Expires:  time.Now().Add(DeviceCodeExpiration),
+	}
+	if err := DefaultDevices.Put(r, d); err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	uri := token.IssuerURL(r) + BaseURL + "device"
+	writeJSON(w, http.StatusOK, &DeviceReply{
+		DeviceCode:              code,
+		UserCode:                d.UserCode,
+		VerificationURI:         uri,
+		VerificationURIComplete: uri + "?user_code=" + url.QueryEscape(d.UserCode),
+		ExpiresIn:               int64(DeviceCodeExpiration / time.Second),
+		Interval:                d.Interval,
+	})
+}
+
+// DeviceTokenReply is the response of the token endpoint to an approved
+// device.
+type DeviceTokenReply struct {
+	// AccessToken is an API key of auth/apikey.
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// deviceToken answers the token requests of the device flow, as in RFC
+// 8628 3.5.
+func deviceToken(w http.ResponseWriter, r *http.Request, c *Client) {
+	if !c.Trusted {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "unauthorized_client"})
+		return
+	}
+	d, err := DefaultDevices.Get(r, codeID(r.FormValue("device_code")))
+	if err == ErrNoSuchDevice || (err == nil && d.ClientID != c.ID) {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "invalid_grant"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	now := time.Now()
+	if now.After(d.Expires) {
+		DefaultDevices.Delete(r, d.ID)
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "expired_token"})
+		return
+	}
+	switch d.Status {
+	case DeviceDenied:
+		DefaultDevices.Delete(r, d.ID)
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "access_denied"})
+		return
+	case DevicePending:
+		e := "authorization_pending"
+		if now.Sub(d.LastPolled) < time.Duration(d.Interval)*time.Second {
+			d.Interval += 5
+			e = "slow_down"
+		}
+		d.LastPolled = now
+		if err = DefaultDevices.Put(r, d); err != nil {
+			writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: e})
+		return
+	}
+	// A device code may only be exchanged once.
+	if err = DefaultDevices.Delete(r, d.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	_, key, err := apikey.Create(r, d.UserID, c.Name, d.Scopes, DeviceKeyExpiration)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &DeviceTokenReply{
+		AccessToken: key,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(DeviceKeyExpiration / time.Second),
+		Scope:       strings.Join(d.Scopes, " "),
+	})
+}
+
+// DeviceTemplate renders the verification page. It is executed with a
+// DevicePage.
+var DeviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>
+{{else if .Client}}<form method="POST" action="{{.Action}}">
+  <p>{{.Client.Name}} on the device showing {{.UserCode}} would like to:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  <input type="hidden" name="user_code" value="{{.UserCode}}">
+  <input type="hidden" name="csrf" value="{{.CSRF}}">
+  <button type="submit" name="consent" value="allow">Allow</button>
+  <button type="submit" name="consent" value="deny">Deny</button>
+</form>
+{{else}}<form method="GET" action="{{.Action}}">
+  {{if .Error}}<p>{{.Error}}</p>{{end}}
+  <label>Enter the code shown on your device
+    <input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus>
+  </label>
+  <button type="submit">Continue</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// DevicePage is the data of DeviceTemplate. The page asks for the user
+// code when Client is nil, asks to approve the Device otherwise, and
+// shows Message once it has been approved or denied.
+type DevicePage struct {
+	UserCode string
+	Client   *Client
+	// Scopes are the descriptions of the requested scopes.
+	Scopes  []string
+	Action  string
+	CSRF    string
+	Error   string
+	Message string
+}
+
+func renderDevice(w http.ResponseWriter, p *DevicePage) {
+	p.Action = BaseURL + "device"
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	DeviceTemplate.Execute(w, p)
+}
+
+// DeviceHandler is the verification page of the device flow. The User
+// logs in, enters the user code shown by the device and approves it.
+func DeviceHandler(w http.ResponseWriter, r *http.Request) {
+	code := normalizeUserCode(r.FormValue("user_code"))
+	userID, err := session.Default.UserID(r)
+	if err != nil || userID == "" {
+		next := BaseURL + "device"
+		if code != "" {
+			next += "?user_code=" + url.QueryEscape(code)
+		}
+		http.Redirect(w, r, auth.LoginRedirectURL(r, next), http.StatusFound)
+		return
+	}
+	if code == "" {
+		renderDevice(w, &DevicePage{})
+		return
+	}
+	d, err := DefaultDevices.GetByUserCode(r, code)
+	var c *Client
+	if err == nil {
+		c, err = DefaultClients.Get(r, d.ClientID)
+	}
+	if err == nil && (d.Status != DevicePending || time.Now().After(d.Expires)) {
+		err = ErrNoSuchDevice
+	}
+	if err != nil {
+		renderDevice(w, &DevicePage{UserCode: code, Error: "The code is invalid or has expired."})
+		return
+	}
+	if r.Method == "POST" && r.FormValue("consent") != "" {
+		ck, err := r.Cookie(csrfCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(ck.Value),
+			[]byte(r.FormValue("csrf"))) != 1 {
+			http.Error(w, "auth/oidc: invalid device form", http.StatusForbidden)
+			return
+		}
+		msg := "The device has been connected. You may return to it."
+		d.Status, d.UserID = DeviceApproved, userID
+		if r.FormValue("consent") != "allow" {
+			msg = "The device has not been connected."
+			d.Status, d.UserID = DeviceDenied, ""
+		}
+		if err = DefaultDevices.Put(r, d); err != nil {
+			http.Error(w, "auth/oidc: unable to save the device", http.StatusInternalServerError)
+			return
+		}
+		renderDevice(w, &DevicePage{Message: msg})
+		return
+	}
+	csrf := random(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrf,
+		Path:     BaseURL,
+		Secure:   session.DefaultOptions.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	p := &DevicePage{UserCode: code, Client: c, CSRF: csrf}
+	for _, s := range d.Scopes {
+		if desc, ok := Scopes[s]; ok {
+			s = desc
+		}
+		p.Scopes = append(p.Scopes, s)
+	}
+	renderDevice(w, p)
+}
+
+// DeviceStore is implemented by the types that persist Devices.
+type DeviceStore interface {
+	// Get returns the Device with the ID, or ErrNoSuchDevice.
+	Get(r *http.Request, id string) (*Device, error)
+	// GetByUserCode returns the Device with the user code, or
+	// ErrNoSuchDevice.
+	GetByUserCode(r *http.Request, code string) (*Device, error)
+	Put(r *http.Request, d *Device) error
+	Delete(r *http.Request, id string) error
+}
+
+// DefaultDevices is the DeviceStore of the device flow. Until it is
+// set, e.g. by importing auth/oidc/datastore, it returns ErrNoStore.
+var DefaultDevices DeviceStore = noDevices{}
+
+// noDevices is the DefaultDevices until one is set.
+type noDevices struct{}
+
+func (noDevices) Get(r *http.Request, id string) (*Device, error) {
+	return nil, ErrNoStore
+}
+
+func (noDevices) GetByUserCode(r *http.Request, code string) (*Device, error) {
+	return nil, ErrNoStore
+}
+
+func (noDevices) Put(r *http.Request, d *Device) error {
+	return ErrNoStore
+}
+
+func (noDevices) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+// MemoryDevices keeps Devices in memory. It is intended for tests and
+// single instance development servers.
+type MemoryDevices struct {
+	mu      sync.Mutex
+	devices map[string]Device
+}
+
+// NewMemoryDevices creates an empty MemoryDevices.
+func NewMemoryDevices() *MemoryDevices {
+	return &MemoryDevices{devices: make(map[string]Device)}
+}
+
+func (s *MemoryDevices) Get(r *http.Request, id string) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSuchDevice
+	}
+	return &d, nil
+}
+
+func (s *MemoryDevices) GetByUserCode(r *http.Request, code string) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, d := range s.devices {
+		if d.UserCode == code && tenant.InNamespace(r, k) {
+			return &d, nil
+		}
+	}
+	return nil, ErrNoSuchDevice
+}
+
+func (s *MemoryDevices) Put(r *http.Request, d *Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[tenant.Key(r, d.ID)] = *d
+	return nil
+}
+
+func (s *MemoryDevices) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, tenant.Key(r, id))
+	return nil
+}
@@ -0,0 +1,328 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/oidc makes the app an OpenID Connect provider, so that other
+apps can log in their users with the accounts, and through the login
+providers, of this one.
+
+The endpoints are registered at BaseURL, and the discovery document at
+/.well-known/openid-configuration, by Handle:
+
+  oidc.Handle()
+
+  // Register an app.
+  c, secret, err := oidc.RegisterClient(r, "Wiki",
+    []string{"https://wiki.example.com/oidc/callback"}, false, true)
+
+The apps use the authorization code flow. A User who is not logged in is
+sent to auth.LoginURL, or that of the request's Tenant, with a "next"
+parameter, which the login page passes on to the provider's start URL,
+e.g. /-/auth/google?next=..., to return to the authorization afterwards.
+Apps that are not Trusted are then shown ConsentTemplate once for each
+new scope.
+
+Devices that can't open a browser, e.g. a command line tool or a kiosk,
+use the device flow of RFC 8628 instead. The device shows a code that
+the User enters at BaseURL+"device" on another device, and receives an
+API key of auth/apikey once the User has approved it. The API key is a
+full login of the User, so only Trusted clients, e.g. the
+organization's own tools, may use the device flow.
+
+The ID tokens are signed with the keys of auth/token, and their claims
+are taken from the User and the Person of each of its Profiles.
+*/
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/token"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchClient       = errors.New("auth/oidc: no such client")
+	ErrNoSuchGrant        = errors.New("auth/oidc: no such authorization code")
+	ErrNoSuchConsent      = errors.New("auth/oidc: no such consent")
+	ErrMissingName        = errors.New("auth/oidc: a name is required")
+	ErrInvalidRedirectURI = errors.New("auth/oidc: invalid redirect URI")
+	ErrNoStore            = errors.New("auth/oidc: no store is set, import github.com/gaego/auth/appengine or set the Default stores")
+)
+
+var (
+	// BaseURL is the path of the endpoints, e.g. /-/oidc/authorize.
+	BaseURL = "/-/oidc/"
+	// CodeExpiration is the lifetime of an authorization code.
+	CodeExpiration = time.Minute
+)
+
+// Scopes are the supported scopes and their descriptions on the consent
+// page. The claims of the "profile" and "email" scopes are those of
+// OpenID Connect Core 5.4.
+var Scopes = map[string]string{
+	"openid":  "Sign you in",
+	"profile": "See your name and picture",
+	"email":   "See your email address",
+}
+
+// Handle registers the endpoints on http.DefaultServeMux.
+func Handle() {
+	http.HandleFunc(BaseURL+"authorize", AuthorizeHandler)
+	http.HandleFunc(BaseURL+"token", TokenHandler)
+	http.HandleFunc(BaseURL+"userinfo", UserInfoHandler)
+	http.HandleFunc(BaseURL+"jwks", token.JWKSHandler)
+	http.HandleFunc(BaseURL+"device/code", DeviceCodeHandler)
+	http.HandleFunc(BaseURL+"device", DeviceHandler)
+	http.HandleFunc("/.well-known/openid-configuration", DiscoveryHandler)
+}
+
+// Client is an app that logs in its users through this one.
+type Client struct {
+	ID   string `datastore:"-" json:"id"`
+	Name string `json:"name"`
+	// SecretHash is the SHA-256 hash of the secret of a confidential
+	// client, e.g. a server side app. It is empty for public clients,
+	// e.g. single page and mobile apps, which must use PKCE instead.
+	SecretHash []byte `datastore:",noindex" json:"-"`
+	// RedirectURIs are the only URIs the authorization may return to.
+	// Without any the Client may only use the device flow.
+	RedirectURIs []string `datastore:",noindex" json:"redirectUris"`
+	// Trusted clients, e.g. the organization's own apps, are not shown
+	// the consent page.
+	Trusted bool      `json:"trusted"`
+	Created time.Time `json:"created"`
+}
+
+// Public reports whether the Client has no secret.
+func (c *Client) Public() bool {
+	return len(c.SecretHash) == 0
+}
+
+// AllowsRedirect reports whether uri is one of the RedirectURIs. The
+// URIs must match exactly.
+func (c *Client) AllowsRedirect(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) checkSecret(secret string) bool {
+	return !c.Public() && subtle.ConstantTimeCompare(hash(secret), c.SecretHash) == 1
+}
+
+// validRedirectURI reports whether u may be registered. It must be
+// absolute, without a fragment and, unless it is a loopback URI of a
+// native app or a private-use scheme, e.g. com.example.app:/callback,
+// https.
+func validRedirectURI(u string) bool {
+	p, err := url.Parse(u)
+	if err != nil || p.Scheme == "" || p.Fragment != "" {
+		return false
+	}
+	switch p.Scheme {
+	case "https":
+		return p.Host != ""
+	case "http":
+		h := p.Hostname()
+		return h == "localhost" || h == "127.0.0.1" || h == "::1"
+	}
+	return true
+}
+
+// random returns n random bytes encoded as base64.
+func random(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth/oidc: unable to generate a secret: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hash(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// RegisterClient registers an app and returns its Client and, unless
+// public is true, its secret. The secret is only returned here; only
+// its hash is saved. Apps that only use the device flow, e.g. a command
+// line tool, need no redirectURIs.
+func RegisterClient(r *http.Request, name string, redirectURIs []string,
+	public, trusted bool) (*Client, string, error) {
+
+	if name == "" {
+		return nil, "", ErrMissingName
+	}
+	for _, u := range redirectURIs {
+		if !validRedirectURI(u) {
+			return nil, "", ErrInvalidRedirectURI
+		}
+	}
+	c := &Client{
+		ID:           random(12),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Trusted:      trusted,
+		Created:      time.Now(),
+	}
+	var secret string
+	if !public {
+		secret = random(32)
+		c.SecretHash = hash(secret)
+	}
+	if err := DefaultClients.Put(r, c); err != nil {
+		return nil, "", err
+	}
+	return c, secret, nil
+}
+
+// Discovery is the OpenID Provider Metadata of DiscoveryHandler.
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	DeviceEndpoint        string   `json:"device_authorization_endpoint"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuth     []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported       []string `json:"claims_supported"`
+}
+
+// DiscoveryHandler serves the OpenID Provider Metadata.
+func DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	iss := token.IssuerURL(r)
+	var scopes []string
+	for s := range Scopes {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, &Discovery{
+		Issuer:                iss,
+		AuthorizationEndpoint: iss + BaseURL + "authorize",
+		TokenEndpoint:         iss + BaseURL + "token",
+		UserInfoEndpoint:      iss + BaseURL + "userinfo",
+		JWKSURI:               iss + BaseURL + "jwks",
+		DeviceEndpoint:        iss + BaseURL + "device/code",
+		ScopesSupported:       scopes,
+		ResponseTypes:         []string{"code"},
+		GrantTypes:            []string{"authorization_code", DeviceCodeGrant},
+		SubjectTypes:          []string{"public"},
+		SigningAlgs:           []string{"ES256"},
+		TokenEndpointAuth:     []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethods:  []string{"S256"},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time",
+			"nonce", "name", "given_name", "family_name", "middle_name",
+			"nickname", "picture", "website", "gender", "updated_at", "email"},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ClientStore is implemented by the types that persist Clients.
+type ClientStore interface {
+	// Get returns the Client with the ID, or ErrNoSuchClient.
+	Get(r *http.Request, id string) (*Client, error)
+	Put(r *http.Request, c *Client) error
+	Delete(r *http.Request, id string) error
+	List(r *http.Request) ([]*Client, error)
+}
+
+// DefaultClients is the ClientStore of the registered apps. Until it
+// is set, e.g. by importing auth/oidc/datastore, it returns ErrNoStore.
+var DefaultClients ClientStore = noClients{}
+
+// noClients is the DefaultClients until one is set.
+type noClients struct{}
+
+func (noClients) Get(r *http.Request, id string) (*Client, error) {
+	return nil, ErrNoStore
+}
+
+func (noClients) Put(r *http.Request, c *Client) error {
+	return ErrNoStore
+}
+
+func (noClients) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+func (noClients) List(r *http.Request) ([]*Client, error) {
+	return nil, ErrNoStore
+}
+
+// MemoryClients keeps Clients in memory. It is intended for tests and
+// development servers.
+type MemoryClients struct {
+	mu      sync.RWMutex
+	clients map[string]Client
+}
+
+// NewMemoryClients creates an empty MemoryClients.
+func NewMemoryClients() *MemoryClients {
+	return &MemoryClients{clients: make(map[string]Client)}
+}
+
+func (s *MemoryClients) Get(r *http.Request, id string) (*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clients[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSuchClient
+	}
+	return &c, nil
+}
+
+func (s *MemoryClients) Put(r *http.Request, c *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[tenant.Key(r, c.ID)] = *c
+	return nil
+}
+
+func (s *MemoryClients) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, tenant.Key(r, id))
+	return nil
+}
+
+func (s *MemoryClients) List(r *http.Request) ([]*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var cs []*Client
+	for k, c := range s.clients {
+		if tenant.InNamespace(r, k) {
+			c := c
+			cs = append(cs, &c)
+		}
+	}
+	return cs, nil
+}
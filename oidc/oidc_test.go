@@ -0,0 +1,392 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gaego/auth"
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"github.com/gaego/person"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func setup() {
+	DefaultClients = NewMemoryClients()
+	DefaultGrants = NewMemoryGrants()
+	DefaultConsents = NewMemoryConsents()
+	DefaultDevices = NewMemoryDevices()
+	apikey.DefaultStore = apikey.NewMemoryStore()
+	session.Default = session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+}
+
+// login returns a request to rawurl with the session of the User.
+func login(method, rawurl string, body url.Values, userID string) *http.Request {
+	var r *http.Request
+	if body != nil {
+		r, _ = http.NewRequest(method, rawurl, strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r, _ = http.NewRequest(method, rawurl, nil)
+	}
+	if userID != "" {
+		w := httptest.NewRecorder()
+		session.Default.SetUserID(w, r, userID)
+		for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestRegisterClient(t *testing.T) {
+	setup()
+	for _, u := range []string{"", "/callback", "http://example.com/cb",
+		"https://example.com/cb#x", "https:///cb"} {
+		if _, _, err := RegisterClient(nil, "App", []string{u}, false, false); err != ErrInvalidRedirectURI {
+			t.Errorf(`%q err: %v, want %v`, u, err, ErrInvalidRedirectURI)
+		}
+	}
+	if _, _, err := RegisterClient(nil, "", []string{"https://example.com/cb"}, false, false); err != ErrMissingName {
+		t.Errorf(`err: %v, want %v`, err, ErrMissingName)
+	}
+	c, secret, err := RegisterClient(nil, "App", []string{"https://example.com/cb",
+		"http://127.0.0.1:8080/cb", "com.example.app:/cb"}, false, false)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	c, _ = DefaultClients.Get(nil, c.ID)
+	if c.Public() || !c.checkSecret(secret) || c.checkSecret("x") {
+		t.Errorf(`checkSecret: the secret is not checked`)
+	}
+	if !c.AllowsRedirect("https://example.com/cb") || c.AllowsRedirect("https://example.com/cb2") {
+		t.Errorf(`AllowsRedirect: the redirect URIs must match exactly`)
+	}
+	c, secret, _ = RegisterClient(nil, "SPA", []string{"https://example.com/cb"}, true, false)
+	if !c.Public() || secret != "" {
+		t.Errorf(`Public: %v, secret: %q, want true, ""`, c.Public(), secret)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	setup()
+	c, secret, _ := RegisterClient(nil, "Wiki", []string{"https://wiki.example.com/cb"}, false, false)
+	verifier := "0123456789abcdef0123456789abcdef0123456789a"
+	h := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"client_id":             {c.ID},
+		"redirect_uri":          {"https://wiki.example.com/cb"},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(h[:])},
+		"code_challenge_method": {"S256"},
+	}
+	authorize := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		AuthorizeHandler(w, r)
+		return w
+	}
+	with := func(k, v string) url.Values {
+		p := url.Values{}
+		for k, v := range q {
+			p[k] = v
+		}
+		p.Set(k, v)
+		return p
+	}
+
+	// Unknown client or redirect URI.
+
+	w := authorize(login("GET", "/-/oidc/authorize?"+with("client_id", "x").Encode(), nil, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusBadRequest)
+	}
+	w = authorize(login("GET", "/-/oidc/authorize?"+with("redirect_uri", "https://evil.example.com/").Encode(), nil, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusBadRequest)
+	}
+
+	// Errors are returned to the Client.
+
+	w = authorize(login("GET", "/-/oidc/authorize?"+with("scope", "email").Encode(), nil, ""))
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if loc.Host != "wiki.example.com" || loc.Query().Get("error") != "invalid_scope" ||
+		loc.Query().Get("state") != "xyz" {
+		t.Errorf(`Location: %v, want an invalid_scope error with the state`, loc)
+	}
+
+	// Not logged in.
+
+	w = authorize(login("GET", "/-/oidc/authorize?"+q.Encode(), nil, ""))
+	loc, _ = url.Parse(w.Header().Get("Location"))
+	if loc.Path != auth.LoginURL || !strings.HasPrefix(loc.Query().Get("next"), BaseURL+"authorize?") {
+		t.Errorf(`Location: %v, want the login page with next`, loc)
+	}
+	acme := &tenant.Tenant{Name: "acme", LoginURL: "/acme/login"}
+	ra := tenant.WithTenant(login("GET", "/-/oidc/authorize?"+q.Encode(), nil, ""), acme)
+	if w = authorize(ra); w.Code != http.StatusBadRequest {
+		t.Errorf(`code: %v, want %v as the Client is not the Tenant's`, w.Code, http.StatusBadRequest)
+	}
+	DefaultClients.Put(ra, c)
+	w = authorize(ra)
+	if loc, _ = url.Parse(w.Header().Get("Location")); loc.Path != "/acme/login" {
+		t.Errorf(`Location: %v, want the Tenant's login page`, loc)
+	}
+	w = authorize(login("GET", "/-/oidc/authorize?"+with("prompt", "none").Encode(), nil, ""))
+	if loc, _ = url.Parse(w.Header().Get("Location")); loc.Query().Get("error") != "login_required" {
+		t.Errorf(`Location: %v, want a login_required error`, loc)
+	}
+
+	// Consent.
+
+	w = authorize(login("GET", "/-/oidc/authorize?"+with("prompt", "none").Encode(), nil, "1"))
+	if loc, _ = url.Parse(w.Header().Get("Location")); loc.Query().Get("error") != "consent_required" {
+		t.Errorf(`Location: %v, want a consent_required error`, loc)
+	}
+	w = authorize(login("GET", "/-/oidc/authorize?"+q.Encode(), nil, "1"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Wiki") {
+		t.Fatalf(`code: %v, want the consent page`, w.Code)
+	}
+	var csrf *http.Cookie
+	for _, ck := range (&http.Response{Header: w.Header()}).Cookies() {
+		if ck.Name == csrfCookie {
+			csrf = ck
+		}
+	}
+	post := with("consent", "allow")
+	post.Set("csrf", "forged")
+	r := login("POST", "/-/oidc/authorize", post, "1")
+	r.AddCookie(csrf)
+	if w = authorize(r); w.Code != http.StatusForbidden {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusForbidden)
+	}
+	post.Set("csrf", csrf.Value)
+	r = login("POST", "/-/oidc/authorize", post, "1")
+	r.AddCookie(csrf)
+	w = authorize(r)
+	loc, _ = url.Parse(w.Header().Get("Location"))
+	code := loc.Query().Get("code")
+	if code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf(`Location: %v, want a code with the state`, loc)
+	}
+
+	// The consent is remembered.
+
+	w = authorize(login("GET", "/-/oidc/authorize?"+q.Encode(), nil, "1"))
+	if loc, _ = url.Parse(w.Header().Get("Location")); loc.Query().Get("code") == "" {
+		t.Errorf(`Location: %v, want a code`, loc)
+	}
+
+	// Token exchange.
+
+	exchange := func(code, secret, verifier string) *httptest.ResponseRecorder {
+		r := login("POST", "/-/oidc/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://wiki.example.com/cb"},
+			"code_verifier": {verifier},
+		}, "")
+		r.SetBasicAuth(c.ID, secret)
+		w := httptest.NewRecorder()
+		TokenHandler(w, r)
+		return w
+	}
+	if w = exchange(code, "wrong", verifier); w.Code != http.StatusUnauthorized {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusUnauthorized)
+	}
+	if w = exchange(code, secret, "wrong"); w.Code != http.StatusBadRequest {
+		t.Errorf(`code: %v, want %v`, w.Code, http.StatusBadRequest)
+	}
+	// The code was used by the previous request.
+	if w = exchange(code, secret, verifier); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf(`code: %v, body: %s, want 400 invalid_grant`, w.Code, w.Body)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	now := time.Now()
+	u := &user.User{Email: "ada@example.com"}
+	pfs := []*profile.Profile{
+		{Updated: now.Add(-time.Hour), Person: &person.Person{
+			DisplayName: "Old Name",
+			Image:       &person.PersonImage{URL: "https://example.com/ada.png"},
+		}},
+		{},
+		{Updated: now, Person: &person.Person{
+			Name: &person.PersonName{GivenName: "Ada", FamilyName: "Lovelace", Formatted: "Ada Lovelace"},
+		}},
+	}
+	ui := userInfo(u, pfs, []string{"openid", "profile"})
+	if ui.Name != "Ada Lovelace" || ui.GivenName != "Ada" || ui.Picture != "https://example.com/ada.png" {
+		t.Errorf(`ui: %+v, want the newest name and the old picture`, ui)
+	}
+	if ui.UpdatedAt != now.Unix() {
+		t.Errorf(`UpdatedAt: %v, want %v`, ui.UpdatedAt, now.Unix())
+	}
+	if ui.Email != "" {
+		t.Errorf(`Email: %v, want "" without the email scope`, ui.Email)
+	}
+	if ui = userInfo(u, pfs, []string{"openid", "email"}); ui.Email != "ada@example.com" || ui.Name != "" {
+		t.Errorf(`ui: %+v, want the email only`, ui)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://example.com/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	DiscoveryHandler(w, r)
+	d := new(Discovery)
+	if err := json.Unmarshal(w.Body.Bytes(), d); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if d.Issuer != "https://example.com" || d.TokenEndpoint != "https://example.com/-/oidc/token" {
+		t.Errorf(`d: %+v, want the endpoints of https://example.com`, d)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	setup()
+	c, _, _ := RegisterClient(nil, "CLI", nil, true, true)
+	post := func(h http.HandlerFunc, v url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, login("POST", "https://example.com/-/oidc/", v, ""))
+		return w
+	}
+
+	// Only trusted clients.
+
+	untrusted, _, _ := RegisterClient(nil, "Game", nil, true, false)
+	w := post(DeviceCodeHandler, url.Values{"client_id": {untrusted.ID}, "scope": {"openid"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unauthorized_client") {
+		t.Errorf(`code: %v, body: %s, want 400 unauthorized_client`, w.Code, w.Body)
+	}
+
+	w = post(DeviceCodeHandler, url.Values{"client_id": {c.ID}, "scope": {"openid repo"}})
+	d := new(DeviceReply)
+	json.Unmarshal(w.Body.Bytes(), d)
+	if d.DeviceCode == "" || len(d.UserCode) != 9 ||
+		d.VerificationURI != "https://example.com/-/oidc/device" {
+		t.Fatalf(`reply: %+v, want the codes`, d)
+	}
+	poll := func() string {
+		w := post(TokenHandler, url.Values{
+			"grant_type":  {DeviceCodeGrant},
+			"client_id":   {c.ID},
+			"device_code": {d.DeviceCode},
+		})
+		var e struct {
+			Error       string `json:"error"`
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &e)
+		return e.Error + e.AccessToken
+	}
+	if e := poll(); e != "authorization_pending" {
+		t.Errorf(`error: %v, want authorization_pending`, e)
+	}
+	if e := poll(); e != "slow_down" {
+		t.Errorf(`error: %v, want slow_down`, e)
+	}
+
+	// The User is sent to the login page first.
+
+	code := strings.ToLower(strings.Replace(d.UserCode, "-", "", 1))
+	w = httptest.NewRecorder()
+	DeviceHandler(w, login("GET", "/-/oidc/device?user_code="+code, nil, ""))
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if loc.Path != auth.LoginURL || loc.Query().Get("next") != BaseURL+"device?user_code="+d.UserCode {
+		t.Errorf(`Location: %v, want the login page with next`, loc)
+	}
+
+	// Approve.
+
+	w = httptest.NewRecorder()
+	DeviceHandler(w, login("GET", "/-/oidc/device?user_code="+code, nil, "1"))
+	if !strings.Contains(w.Body.String(), "CLI") {
+		t.Fatalf(`body: %s, want the approval form`, w.Body)
+	}
+	var csrf *http.Cookie
+	for _, ck := range (&http.Response{Header: w.Header()}).Cookies() {
+		if ck.Name == csrfCookie {
+			csrf = ck
+		}
+	}
+	r := login("POST", "/-/oidc/device", url.Values{
+		"user_code": {d.UserCode},
+		"csrf":      {csrf.Value},
+		"consent":   {"allow"},
+	}, "1")
+	r.AddCookie(csrf)
+	DeviceHandler(httptest.NewRecorder(), r)
+
+	// Poll after the interval.
+
+	dev, _ := DefaultDevices.Get(nil, codeID(d.DeviceCode))
+	dev.LastPolled = time.Time{}
+	DefaultDevices.Put(nil, dev)
+	key := poll()
+	if !strings.HasPrefix(key, apikey.Prefix) {
+		t.Fatalf(`access_token: %v, want an API key`, key)
+	}
+	k, err := apikey.Authenticate(nil, key)
+	if err != nil || k.UserID != "1" || !k.HasScope("openid") || k.HasScope("repo") {
+		t.Errorf(`key: %+v, err: %v, want a key of User 1 with the openid scope only`, k, err)
+	}
+	if e := poll(); e != "invalid_grant" {
+		t.Errorf(`error: %v, want invalid_grant`, e)
+	}
+
+	// Expiry.
+
+	w = post(DeviceCodeHandler, url.Values{"client_id": {c.ID}})
+	json.Unmarshal(w.Body.Bytes(), d)
+	dev, _ = DefaultDevices.Get(nil, codeID(d.DeviceCode))
+	dev.Expires = time.Now().Add(-time.Second)
+	DefaultDevices.Put(nil, dev)
+	if e := poll(); e != "expired_token" {
+		t.Errorf(`error: %v, want expired_token`, e)
+	}
+}
+
+func TestMemoryGrants_Take(t *testing.T) {
+	s := NewMemoryGrants()
+	s.Put(nil, &Grant{ID: "1", ClientID: "app"})
+
+	// Of the concurrent exchanges of a code only one gets its Grant.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var taken int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Take(nil, "1"); err == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 1 {
+		t.Errorf(`taken: %v, want 1`, taken)
+	}
+	if _, err := s.Get(nil, "1"); err != ErrNoSuchGrant {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchGrant)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"errors"
+	"github.com/gaego/auth/session"
+	"net/http"
+)
+
+var (
+	ErrForbidden = errors.New("auth/oidc: the admin role is required")
+)
+
+type Service struct{}
+
+type Args struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	// Public registers a Client without a secret, e.g. a single page or
+	// mobile app.
+	Public  bool
+	Trusted bool
+}
+
+type Reply struct {
+	Client *Client
+	// Secret is only returned when the Client is registered.
+	Secret   string `json:",omitempty"`
+	Clients  []*Client
+	Consents []*Consent
+}
+
+// admin returns ErrForbidden unless the current User has the admin
+// role.
+func admin(r *http.Request) error {
+	u, err := session.Current(r)
+	if err != nil {
+		return err
+	}
+	if !u.HasRole("admin") {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// RegisterClient registers an app. The secret in the reply can not be
+// retrieved later. The current User must have the admin role.
+func (s *Service) RegisterClient(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	if err = admin(r); err != nil {
+		return err
+	}
+	reply.Client, reply.Secret, err = RegisterClient(r, args.Name,
+		args.RedirectURIs, args.Public, args.Trusted)
+	return err
+}
+
+// ListClients returns the registered apps. The current User must have
+// the admin role.
+func (s *Service) ListClients(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	if err = admin(r); err != nil {
+		return err
+	}
+	reply.Clients, err = DefaultClients.List(r)
+	return err
+}
+
+// DeleteClient removes the app with args.ID. The current User must have
+// the admin role.
+func (s *Service) DeleteClient(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	if err = admin(r); err != nil {
+		return err
+	}
+	return DefaultClients.Delete(r, args.ID)
+}
+
+// ListConsents returns the apps the current User has consented to.
+func (s *Service) ListConsents(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	reply.Consents, err = DefaultConsents.List(r, id)
+	return err
+}
+
+// RevokeConsent removes the current User's consent to the app with
+// args.ID; the app must ask for it again at the next login.
+func (s *Service) RevokeConsent(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	id, err := session.Default.UserID(r)
+	if err != nil {
+		return err
+	}
+	if err = DefaultConsents.Delete(r, id, args.ID); err != nil {
+		return err
+	}
+	reply.Consents, err = DefaultConsents.List(r, id)
+	return err
+}
@@ -0,0 +1,282 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/token"
+	"github.com/gaego/auth/user"
+	"github.com/gaego/person"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IDTokenExpiration is the lifetime of an ID token.
+var IDTokenExpiration = time.Hour
+
+// UserInfo are the claims about the User, as in OpenID Connect Core
+// 5.1. Only the claims of the granted scopes are set.
+type UserInfo struct {
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	MiddleName string `json:"middle_name,omitempty"`
+	Nickname   string `json:"nickname,omitempty"`
+	Picture    string `json:"picture,omitempty"`
+	Website    string `json:"website,omitempty"`
+	Gender     string `json:"gender,omitempty"`
+	UpdatedAt  int64  `json:"updated_at,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+// IDClaims are the claims of an ID token.
+type IDClaims struct {
+	jwt.StandardClaims
+	AuthTime int64  `json:"auth_time,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	UserInfo
+}
+
+// TokenReply is the response of the token endpoint.
+type TokenReply struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// errorReply is the error response of the token and userinfo endpoints,
+// as in RFC 6749 5.2.
+type errorReply struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// userInfo returns the claims of the scopes about the User. Each claim
+// is taken from the most recently updated Profile that has it.
+func userInfo(u *user.User, pfs []*profile.Profile, scopes []string) *UserInfo {
+	ui := new(UserInfo)
+	sort.Stable(byUpdated(pfs))
+	set := func(v *string, s string) {
+		if *v == "" {
+			*v = s
+		}
+	}
+	if hasScope(scopes, "profile") {
+		for _, pf := range pfs {
+			p := pf.Person
+			if p == nil {
+				continue
+			}
+			if ui.UpdatedAt == 0 {
+				ui.UpdatedAt = pf.Updated.Unix()
+			}
+			set(&ui.Name, p.DisplayName)
+			set(&ui.Nickname, p.Nickname)
+			set(&ui.Gender, p.Gender)
+			set(&ui.Website, p.URL)
+			if p.Name != nil {
+				set(&ui.Name, p.Name.Formatted)
+				set(&ui.GivenName, p.Name.GivenName)
+				set(&ui.FamilyName, p.Name.FamilyName)
+				set(&ui.MiddleName, p.Name.MiddleName)
+			}
+			if p.Image != nil {
+				set(&ui.Picture, p.Image.URL)
+			}
+		}
+	}
+	if hasScope(scopes, "email") {
+		set(&ui.Email, u.Email)
+		for _, pf := range pfs {
+			if pf.Person != nil {
+				set(&ui.Email, primaryEmail(pf.Person))
+			}
+		}
+	}
+	return ui
+}
+
+type byUpdated []*profile.Profile
+
+func (s byUpdated) Len() int           { return len(s) }
+func (s byUpdated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byUpdated) Less(i, j int) bool { return s[i].Updated.After(s[j].Updated) }
+
+// primaryEmail returns the primary email address of the Person.
+func primaryEmail(p *person.Person) string {
+	for _, e := range p.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	return p.Email
+}
+
+// loadUserInfo returns the claims of the scopes about the User with the
+// ID.
+func loadUserInfo(r *http.Request, userID string, scopes []string) (*UserInfo, error) {
+	u, err := profile.DefaultUserStore.Get(r, userID)
+	if err != nil {
+		return nil, err
+	}
+	// A missing Profile leaves an empty Profile in its place.
+	pfs, err := profile.DefaultStore.GetMulti(r, u.AuthIDs)
+	if pfs == nil && err != nil {
+		return nil, err
+	}
+	return userInfo(u, pfs, scopes), nil
+}
+
+// clientCredentials returns the client_id and client_secret of the
+// request's Authorization header or, failing that, its form.
+func clientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret")
+}
+
+// authenticateClient returns the Client of a token request. If the
+// client can't be authenticated, the error has been written to w.
+func authenticateClient(w http.ResponseWriter, r *http.Request) (*Client, bool) {
+	id, secret := clientCredentials(r)
+	c, err := DefaultClients.Get(r, id)
+	if err == nil && !c.Public() && !c.checkSecret(secret) {
+		err = ErrNoSuchClient
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		writeJSON(w, http.StatusUnauthorized, &errorReply{Error: "invalid_client"})
+		return nil, false
+	}
+	return c, true
+}
+
+// TokenHandler is the token endpoint. It exchanges an authorization
+// code for an ID token and an access token for UserInfoHandler, or an
+// approved device code for an API key.
+func TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, &errorReply{Error: "invalid_request"})
+		return
+	}
+	grant := r.FormValue("grant_type")
+	if grant != "authorization_code" && grant != DeviceCodeGrant {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "unsupported_grant_type"})
+		return
+	}
+	c, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if grant == DeviceCodeGrant {
+		deviceToken(w, r, c)
+		return
+	}
+	// An authorization code may only be used once.
+	g, err := DefaultGrants.Take(r, codeID(r.FormValue("code")))
+	if err == ErrNoSuchGrant {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "invalid_grant"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	if g.ClientID != c.ID || g.RedirectURI != r.FormValue("redirect_uri") ||
+		time.Now().After(g.Expires) {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: "invalid_grant"})
+		return
+	}
+	if g.CodeChallenge != "" && !verifyChallenge(g.CodeChallenge, r.FormValue("code_verifier")) {
+		writeJSON(w, http.StatusBadRequest, &errorReply{
+			Error:       "invalid_grant",
+			Description: "invalid code_verifier",
+		})
+		return
+	}
+	reply, err := issue(r, g)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// issue returns the tokens of the Grant.
+func issue(r *http.Request, g *Grant) (*TokenReply, error) {
+	ui, err := loadUserInfo(r, g.UserID, g.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var authTime int64
+	if !g.AuthTime.IsZero() {
+		authTime = g.AuthTime.Unix()
+	}
+	idt, err := token.Sign(r, &IDClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    token.IssuerURL(r),
+			Subject:   g.UserID,
+			Audience:  jwt.Audience{g.ClientID},
+			ExpiresAt: now.Add(IDTokenExpiration).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		AuthTime: authTime,
+		Nonce:    g.Nonce,
+		UserInfo: *ui,
+	})
+	if err != nil {
+		return nil, err
+	}
+	scope := strings.Join(g.Scopes, " ")
+	cl := token.NewClaims(r, g.UserID, g.Provider)
+	cl.ClientID, cl.Scope = g.ClientID, scope
+	at, err := token.Sign(r, cl)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenReply{
+		AccessToken: at,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(token.AccessTokenExpiration / time.Second),
+		IDToken:     idt,
+		Scope:       scope,
+	}, nil
+}
+
+// userInfoReply is the response of the userinfo endpoint.
+type userInfoReply struct {
+	Subject string `json:"sub"`
+	*UserInfo
+}
+
+// UserInfoHandler is the userinfo endpoint. It returns the claims about
+// the User of an access token from TokenHandler.
+func UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var raw string
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		raw = strings.TrimSpace(h[7:])
+	}
+	cl, err := token.Verify(r, raw)
+	if err != nil || cl.ClientID == "" ||
+		!hasScope(strings.Fields(cl.Scope), "openid") {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, &errorReply{Error: "invalid_token"})
+		return
+	}
+	ui, err := loadUserInfo(r, cl.Subject, strings.Fields(cl.Scope))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorReply{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &userInfoReply{Subject: cl.Subject, UserInfo: ui})
+}
@@ -0,0 +1,171 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/origin returns the public origin of the app, e.g.
+"https://example.com", for the redirect URIs sent to the providers and
+the issuer of the tokens.
+
+On App Engine and behind load balancers the scheme of the request is
+usually missing and its host may be internal. Either set the origin:
+
+  origin.URL = "https://example.com"
+
+or, if the app is only reachable through a proxy that sets them, trust
+the Forwarded, or X-Forwarded-Proto and X-Forwarded-Host, headers:
+
+  origin.TrustProxy = true
+*/
+package origin
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// URL is the public origin, e.g. "https://example.com", including
+	// the path prefix of a proxy, if any, e.g. "https://example.com/app".
+	// If empty the origin of each request is used, e.g. for the Tenants
+	// of auth/tenant.
+	URL string
+	// TrustProxy makes the origin of a request that of its Forwarded
+	// header or, failing that, its X-Forwarded-Proto and
+	// X-Forwarded-Host headers. The headers can be set by any client, so
+	// they may only be trusted when all requests pass through a proxy
+	// that replaces them.
+	//
+	// URL and TrustProxy may only be set before the requests are served;
+	// use Set to change them afterwards.
+	TrustProxy bool
+)
+
+// settings are the URL and TrustProxy of Set.
+type settings struct {
+	url        string
+	trustProxy bool
+}
+
+// set holds the *settings of the last Set.
+var set atomic.Value
+
+// Set replaces URL and TrustProxy at once, e.g. when auth/config reloads
+// its configuration while requests are served. Once it is called the
+// variables are no longer read.
+func Set(url string, trustProxy bool) {
+	set.Store(&settings{url, trustProxy})
+}
+
+// current returns the settings of the last Set, or else URL and
+// TrustProxy.
+func current() (url string, trustProxy bool) {
+	if s, ok := set.Load().(*settings); ok {
+		return s.url, s.trustProxy
+	}
+	return URL, TrustProxy
+}
+
+// Of returns the public origin of the request, e.g.
+// "https://example.com".
+func Of(r *http.Request) string {
+	if url, _ := current(); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return Scheme(r) + "://" + Host(r)
+}
+
+// Resolve returns the public URL of the path, e.g.
+// "https://example.com/-/auth/google/callback" for
+// "/-/auth/google/callback". Absolute URLs are returned as they are.
+func Resolve(r *http.Request, path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	return Of(r) + path
+}
+
+// Scheme returns the public scheme of the request, "http" or "https".
+func Scheme(r *http.Request) string {
+	if _, trustProxy := current(); trustProxy {
+		if s := forwarded(r, "proto"); s != "" {
+			return strings.ToLower(s)
+		}
+		if s := first(r.Header.Get("X-Forwarded-Proto")); s != "" {
+			return strings.ToLower(s)
+		}
+	}
+	if r.TLS != nil || r.URL.Scheme == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the public host of the request, e.g. "example.com" or
+// "localhost:8080".
+func Host(r *http.Request) string {
+	if _, trustProxy := current(); trustProxy {
+		if h := forwarded(r, "host"); h != "" {
+			return h
+		}
+		if h := first(r.Header.Get("X-Forwarded-Host")); h != "" {
+			return h
+		}
+	}
+	return r.Host
+}
+
+// IP returns the IP address of the client, that of the proxy's headers
+// if TrustProxy is set.
+func IP(r *http.Request) string {
+	if _, trustProxy := current(); trustProxy {
+		if ip := forwarded(r, "for"); ip != "" {
+			return stripPort(ip)
+		}
+		if ip := first(r.Header.Get("X-Forwarded-For")); ip != "" {
+			return stripPort(ip)
+		}
+	}
+	return stripPort(r.RemoteAddr)
+}
+
+// stripPort returns the address without its port and the brackets of
+// an IPv6 address, e.g. "2001:db8::1" for "[2001:db8::1]:4711".
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// first returns the first value of a comma separated header, i.e. the
+// one set by the proxy closest to the client.
+func first(h string) string {
+	if i := strings.Index(h, ","); i >= 0 {
+		h = h[:i]
+	}
+	return strings.TrimSpace(h)
+}
+
+// forwarded returns the parameter of the first element of the Forwarded
+// header of RFC 7239, e.g. "https" for "proto" of
+// `for=192.0.2.60;proto=https;host=example.com`.
+func forwarded(r *http.Request, param string) string {
+	for _, p := range strings.Split(first(r.Header.Get("Forwarded")), ";") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], param) {
+			return strings.Trim(kv[1], `"`)
+		}
+	}
+	return ""
+}
+
+// Callback returns the public URL of the callback leg of the provider
+// that serves the request, e.g. "https://example.com/-/auth/google/callback"
+// for both /-/auth/google and /-/auth/google/callback, so that the start
+// and callback legs send the same redirect URI.
+func Callback(r *http.Request) string {
+	return Of(r) + strings.TrimSuffix(r.URL.Path, "/callback") + "/callback"
+}
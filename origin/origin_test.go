@@ -0,0 +1,121 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package origin
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	defer func() { URL, TrustProxy = "", false }()
+	tests := []struct {
+		url     string
+		trust   bool
+		tls     bool
+		headers map[string]string
+		want    string
+	}{
+		{"", false, false, nil, "http://app.internal:8080"},
+		{"", false, true, nil, "https://app.internal:8080"},
+		// The headers are ignored unless the proxy is trusted.
+		{"", false, false, map[string]string{"X-Forwarded-Proto": "https"}, "http://app.internal:8080"},
+		{"", true, false, map[string]string{
+			"X-Forwarded-Proto": "https, http",
+			"X-Forwarded-Host":  "example.com",
+		}, "https://example.com"},
+		{"", true, false, map[string]string{
+			"Forwarded":         `for=192.0.2.60;Proto=HTTPS;host="example.com", for=10.0.0.1`,
+			"X-Forwarded-Proto": "http",
+		}, "https://example.com"},
+		{"https://example.com/app/", true, false, map[string]string{
+			"X-Forwarded-Host": "other.example.com",
+		}, "https://example.com/app"},
+	}
+	for i, tt := range tests {
+		URL, TrustProxy = tt.url, tt.trust
+		r, _ := http.NewRequest("GET", "/-/auth/google", nil)
+		r.Host = "app.internal:8080"
+		if tt.tls {
+			r.TLS = new(tls.ConnectionState)
+		}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if x := Of(r); x != tt.want {
+			t.Errorf(`%d: Of: %v, want %v`, i, x, tt.want)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	defer func() { TrustProxy = false }()
+	r, _ := http.NewRequest("GET", "http://app.internal/", nil)
+	r.RemoteAddr = "10.0.0.1:4711"
+	r.Header.Set("X-Forwarded-For", "192.0.2.60, 10.0.0.2")
+	if x := IP(r); x != "10.0.0.1" {
+		t.Errorf(`IP: %v, want "10.0.0.1"`, x)
+	}
+	TrustProxy = true
+	if x := IP(r); x != "192.0.2.60" {
+		t.Errorf(`IP: %v, want "192.0.2.60"`, x)
+	}
+	r.Header.Set("Forwarded", `for="[2001:db8::1]:4711";proto=https`)
+	if x := IP(r); x != "2001:db8::1" {
+		t.Errorf(`IP: %v, want "2001:db8::1"`, x)
+	}
+}
+
+func TestCallback(t *testing.T) {
+	defer func() { URL = "" }()
+	URL = "https://example.com/app"
+	for _, p := range []string{"/auth/google", "/auth/google/callback"} {
+		r, _ := http.NewRequest("GET", "http://app.internal"+p+"?code=1234", nil)
+		if x := Callback(r); x != "https://example.com/app/auth/google/callback" {
+			t.Errorf(`Callback: %v, want "https://example.com/app/auth/google/callback"`, x)
+		}
+	}
+	r, _ := http.NewRequest("GET", "http://app.internal/auth/google", nil)
+	if x := Resolve(r, "/callback"); x != "https://example.com/app/callback" {
+		t.Errorf(`Resolve: %v, want "https://example.com/app/callback"`, x)
+	}
+	if x := Resolve(r, "https://login.example.com/callback"); x != "https://login.example.com/callback" {
+		t.Errorf(`Resolve: %v, want "https://login.example.com/callback"`, x)
+	}
+}
+
+func TestSet(t *testing.T) {
+	defer func() { set = atomic.Value{} }()
+	URL = "https://ignored.example.com"
+	defer func() { URL = "" }()
+
+	Set("https://example.com", false)
+	if x := Of(nil); x != "https://example.com" {
+		t.Errorf(`Of: %v, want "https://example.com"`, x)
+	}
+
+	// Requests may be served while the settings are replaced.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			Set("https://example.com", false)
+			Set("", true)
+		}
+		close(done)
+	}()
+	r, _ := http.NewRequest("GET", "http://app.internal/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	for i := 0; i < 100; i++ {
+		if x := Of(r); x != "https://example.com" && x != "https://app.internal" {
+			t.Fatalf(`Of: %v, want "https://example.com" or "https://app.internal"`, x)
+		}
+	}
+	<-done
+	if x := Of(r); x != "https://app.internal" {
+		t.Errorf(`Of: %v, want "https://app.internal"`, x)
+	}
+}
@@ -5,13 +5,14 @@
 package password
 
 import (
-	"appengine"
 	"errors"
+	"github.com/gaego/auth/audit"
 	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/tracing"
+	"github.com/gaego/auth/user"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
-	"github.com/gaego/user/email"
 	"golang.org/x/crypto/bcrypt"
+	"net/http"
 )
 
 var (
@@ -57,7 +58,7 @@ func (p *Password) Validate() (err error) {
 		}
 	}
 	// Validate email
-	if err = email.Validate(p.Email); err != nil {
+	if err = user.ValidateEmail(p.Email); err != nil {
 		return
 	}
 	return
@@ -74,82 +75,112 @@ func CompareHashAndPassword(hash, password []byte) error {
 	return nil
 }
 
-func authenticate(c appengine.Context, pass *Password, pers *person.Person, userID string) (
-	pf *profile.Profile, err error) {
+// hash is GenerateFromPassword traced as a child of the request's span.
+func hash(r *http.Request, pass string) []byte {
+	span := tracing.StartSpan(r, "bcrypt.GenerateFromPassword")
+	h, err := GenerateFromPassword([]byte(pass))
+	tracing.End(span, err)
+	return h
+}
+
+// compare is CompareHashAndPassword traced as a child of the request's
+// span. A mismatch is not recorded as an error of the span.
+func compare(r *http.Request, hash []byte, pass string) error {
+	span := tracing.StartSpan(r, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	return CompareHashAndPassword(hash, []byte(pass))
+}
+
+// authenticate creates, logs in or updates the password Profile of the
+// User. Profiles are read from s.
+func authenticate(r *http.Request, s profile.Store, pass *Password, pers *person.Person,
+	userID string) (pf *profile.Profile, err error) {
 
 	if err = pass.Validate(); err != nil {
 		return nil, err
 	}
 	if pass.New != "" && pass.Current != "" {
-		pf, err = update(c, pass.Current, pass.New, userID, pers)
+		pf, err = update(r, s, pass.Current, pass.New, userID, pers)
 		return
 	}
 	if pass.New != "" {
 		// if we have a user ID check for a profile
 		if userID != "" {
-			if pf, err = login(c, pass.New, userID); err == ErrProfileNotFound {
-				pf, err = create(c, pass.New, pers, userID)
+			if pf, err = login(r, s, pass.New, userID); err == ErrProfileNotFound {
+				pf, err = create(r, pass.New, pers, userID)
 				return
 			}
 			if err != nil {
 				return
 			}
 		}
-		pf, err = create(c, pass.New, pers, "")
+		pf, err = create(r, pass.New, pers, "")
 		return
 	}
 	if pass.Current != "" {
-		pf, err = login(c, pass.Current, userID)
+		pf, err = login(r, s, pass.Current, userID)
 		return
 	}
 	return pf, nil
 }
 
-func create(c appengine.Context, pass string, pers *person.Person, userID string) (
+func create(r *http.Request, pass string, pers *person.Person, userID string) (
 	pf *profile.Profile, err error) {
 
 	var id string
 	if userID == "" {
-		u := user.New()
-		u.SetKey(c)
-		if err = u.Put(c); err != nil {
+		var u *user.User
+		if u, id, err = profile.DefaultUserStore.New(r); err != nil {
 			return
 		}
-		id = u.Key.StringID()
+		if err = profile.DefaultUserStore.Put(r, id, u); err != nil {
+			return
+		}
+		audit.Record(r, &audit.AuthEvent{Type: audit.UserCreated, UserID: id, Provider: "Password"})
 	} else {
 		id = userID
 	}
 	pf = profile.New("Password", "")
 	pf.ID = id
 	pf.UserID = id
-	pf.Auth, _ = GenerateFromPassword([]byte(pass))
+	pf.Auth = hash(r, pass)
 	pf.Person = pers
 	return
 }
 
-func login(c appengine.Context, pass string, userID string) (
+func login(r *http.Request, s profile.Store, pass string, userID string) (
 	pf *profile.Profile, err error) {
 
 	if userID == "" {
 		return nil, ErrProfileNotFound
 	}
 	pid := profile.GenAuthID("Password", userID)
-	if pf, err = profile.Get(c, pid); err != nil {
+	if pf, err = s.Get(r, pid); err != nil {
 		return nil, ErrProfileNotFound
 	}
-	if err := CompareHashAndPassword(pf.Auth, []byte(pass)); err != nil {
+	if err := compare(r, pf.Auth, pass); err != nil {
 		return nil, err
 	}
 	return pf, nil
 }
 
-func update(c appengine.Context, passCurrent, passNew string, userID string, pers *person.Person) (
-	pf *profile.Profile, err error) {
+func update(r *http.Request, s profile.Store, passCurrent, passNew string, userID string,
+	pers *person.Person) (pf *profile.Profile, err error) {
 
-	if pf, err = login(c, passCurrent, userID); err != nil {
+	if pf, err = login(r, s, passCurrent, userID); err != nil {
 		return
 	}
-	pf.Auth, _ = GenerateFromPassword([]byte(passNew))
+	pf.Auth = hash(r, passNew)
 	pf.Person = pers
+	// Save the new password before it is recorded as changed.
+	if err = s.Put(r, pf); err != nil {
+		return nil, err
+	}
+	audit.Record(r, &audit.AuthEvent{
+		Type:     audit.PasswordChanged,
+		UserID:   userID,
+		Provider: "Password",
+		AuthID:   pf.AuthID(),
+	})
 	return pf, nil
 }
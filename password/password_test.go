@@ -5,6 +5,11 @@
 package password
 
 import (
+	"errors"
+	"github.com/gaego/auth/audit"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"net/http"
 	"testing"
 )
 
@@ -16,3 +21,42 @@ func TestValidate(t *testing.T) {
 		t.Errorf(`validatePass("passw") = %v, want true`, x)
 	}
 }
+
+// failingStore is a MemoryStore whose Put fails.
+type failingStore struct{ *profile.MemoryStore }
+
+func (failingStore) Put(r *http.Request, p *profile.Profile) error {
+	return errors.New("unavailable")
+}
+
+func TestUpdate(t *testing.T) {
+	defer func(s audit.Sink) { audit.DefaultSink = s }(audit.DefaultSink)
+	sink := audit.NewMemorySink()
+	audit.DefaultSink = sink
+	s := profile.NewMemoryStore()
+	pf := profile.New("Password", "")
+	pf.ID, pf.UserID = "1", "1"
+	pf.Auth, _ = GenerateFromPassword([]byte("secret1"))
+	s.Put(nil, pf)
+
+	r, _ := http.NewRequest("POST", "http://localhost:8080/-/auth/password", nil)
+	q := &audit.Query{Type: audit.PasswordChanged}
+
+	// A change that isn't saved isn't recorded.
+
+	if _, err := update(r, failingStore{s}, "secret1", "secret2", "1", new(person.Person)); err == nil {
+		t.Errorf(`err: nil, want an error`)
+	}
+	if es, _ := sink.Query(r, q); len(es) != 0 {
+		t.Errorf(`len(es): %v, want 0`, len(es))
+	}
+	if _, err := update(r, s, "secret1", "secret2", "1", new(person.Person)); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if es, _ := sink.Query(r, q); len(es) != 1 {
+		t.Errorf(`len(es): %v, want 1`, len(es))
+	}
+	if _, err := login(r, s, "secret2", "1"); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+}
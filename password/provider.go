@@ -40,9 +40,8 @@ import (
 	"github.com/gorilla/schema"
 	"errors"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
+	"github.com/gaego/auth/session"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
 	"net/http"
 )
 
@@ -60,6 +59,28 @@ func New() *Provider {
 	return &Provider{"Password", ""}
 }
 
+// LoginForm reports that the Provider takes a POST of the login form,
+// for the login page of auth.
+func (p *Provider) LoginForm() bool {
+	return true
+}
+
+// Describe returns the Name and URL of the Provider, for the login page
+// of auth.
+func (p *Provider) Describe() (name, url string) {
+	return p.Name, p.URL
+}
+
+// currentUserID returns the ID of the logged in User or, if there is
+// none, of the User with the email address.
+func currentUserID(r *http.Request, email string) string {
+	if id, _ := session.Default.UserID(r); id != "" {
+		return id
+	}
+	id, _ := profile.DefaultUserStore.UserID(r, email)
+	return id
+}
+
 func decodePerson(r *http.Request) *person.Person {
 	// Decode the form data and add the resulting Person type to the Profile.
 	p := &person.Person{}
@@ -82,9 +103,8 @@ func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
 		Current: r.FormValue("Password.Current"),
 		Email:   r.FormValue("Email"),
 	}
-	c := context.NewContext(r)
-	userID, _ := user.CurrentUserIDByEmail(r, pass.Email)
+	userID := currentUserID(r, pass.Email)
 	pers := decodePerson(r)
-	pf, err = authenticate(c, pass, pers, userID)
+	pf, err = authenticate(r, profile.DefaultStore, pass, pers, userID)
 	return pf, "", err
 }
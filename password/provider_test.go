@@ -6,11 +6,11 @@ package password
 
 import (
 	"github.com/gaego/auth"
+	"github.com/gaego/auth/audit"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
-	"github.com/gaego/user/email"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/user"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -27,11 +27,14 @@ func setup() *Provider {
 	startOnce.Do(func() {
 		auth.Register("password", pro)
 	})
+	profile.DefaultStore = profile.NewMemoryStore()
+	profile.DefaultUserStore = profile.NewMemoryUserStore()
+	session.Default = session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+	audit.DefaultSink = audit.NewMemorySink()
 	return pro
 }
 
 func tearDown() {
-	context.Close()
 }
 
 func createRequest(v url.Values) *http.Request {
@@ -115,7 +118,6 @@ func TestAuthenticate_Scenario2(t *testing.T) {
 	var v url.Values
 	var r *http.Request
 
-	c := context.NewContext(nil)
 	w := httptest.NewRecorder()
 
 	// Profile Not found
@@ -135,18 +137,16 @@ func TestAuthenticate_Scenario2(t *testing.T) {
 	pf.ID = "1"
 	passHash, _ := GenerateFromPassword([]byte("secret1"))
 	pf.Auth = passHash
-	pf.SetKey(c)
 	pf.Person = &person.Person{
 		Name: &person.PersonName{
 			GivenName:  "Barack",
 			FamilyName: "Obama",
 		},
 	}
-	_ = pf.Put(c)
-	e := email.New()
-	e.UserID = "1"
-	e.SetKey(c, "test@example.org")
-	_ = e.Put(c)
+	_ = profile.DefaultStore.Put(r, pf)
+	u := user.New()
+	_ = profile.DefaultUserStore.AddEmail(r, "1", u, "test@example.org")
+	_ = profile.DefaultUserStore.Put(r, "1", u)
 
 	// 1. Login
 	// a. Correct password.
@@ -252,7 +252,6 @@ func TestAuthenticate_Scenario3(t *testing.T) {
 	var v url.Values
 	var r *http.Request
 
-	//c := context.NewContext(nil)
 	w := httptest.NewRecorder()
 
 	// Setup.
@@ -261,7 +260,7 @@ func TestAuthenticate_Scenario3(t *testing.T) {
 	v.Set("Password.New", "secret1")
 	v.Set("Name.GivenName", "Bob")
 	r = createRequest(v)
-	_ = user.CurrentUserSetID(w, r, "1001")
+	_ = session.Login(w, r, "1001", "Password")
 
 	// Check.
 	if pf, uRL, err = pro.Authenticate(w, r); uRL != "" || err != nil {
@@ -6,10 +6,10 @@ package password
 
 import (
 	"github.com/gaego/auth"
+	"github.com/gaego/auth/audit"
 	"github.com/gaego/auth/profile"
-	"github.com/gaego/context"
+	"github.com/gaego/auth/session"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
 	"net/http"
 )
 
@@ -23,11 +23,16 @@ type Args struct {
 func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request,
 	args *Args, reply *Args) (err error) {
 
-	c := context.NewContext(r)
 	args.Person.Email = args.Password.Email
-	userID, _ := user.CurrentUserIDByEmail(r, args.Password.Email)
-	pf, err := authenticate(c, args.Password, args.Person, userID)
+	userID := currentUserID(r, args.Password.Email)
+	pf, err := authenticate(r, profile.DefaultStore, args.Password, args.Person, userID)
 	if err != nil {
+		audit.Record(r, &audit.AuthEvent{
+			Type:     audit.LoginFailure,
+			UserID:   userID,
+			Provider: "Password",
+			Detail:   err.Error(),
+		})
 		return err
 	}
 	if _, err = auth.CreateAndLogin(w, r, pf); err != nil {
@@ -41,10 +46,9 @@ func (s *Service) Authenticate(w http.ResponseWriter, r *http.Request,
 func (s *Service) Current(w http.ResponseWriter, r *http.Request,
 	args *Args, reply *Args) (err error) {
 
-	c := context.NewContext(r)
 	var isSet bool
-	userID, _ := user.CurrentUserID(r)
-	_, err = profile.Get(c, profile.GenAuthID("Password", userID))
+	userID, _ := session.Default.UserID(r)
+	_, err = profile.DefaultStore.Get(r, profile.GenAuthID("Password", userID))
 	if err == nil {
 		isSet = true
 	}
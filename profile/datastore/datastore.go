@@ -0,0 +1,219 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/profile/datastore saves the Profiles and Users of
+auth/profile to the App Engine datastore, as "AuthProfile" and User
+entities, and grants the App Engine administrators the "admin" role. It
+is imported for its side effect:
+
+  import _ "github.com/gaego/auth/profile/datastore"
+*/
+package datastore
+
+import (
+	"appengine"
+	"appengine/datastore"
+	aeuser "appengine/user"
+	"github.com/gaego/auth/namespace"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/tracing"
+	authuser "github.com/gaego/auth/user"
+	"github.com/gaego/context"
+	"github.com/gaego/ds"
+	"github.com/gaego/person"
+	"github.com/gaego/user"
+	"net/http"
+	"time"
+)
+
+func init() {
+	profile.DefaultStore = Store{}
+	profile.DefaultUserStore = UserStore{}
+	profile.Rules = append(profile.Rules, AppEngineAdmin)
+}
+
+// AppEngineAdmin grants the "admin" role to the administrators of the
+// App Engine app.
+var AppEngineAdmin = &profile.Rule{
+	Role: "admin",
+	Match: func(r *http.Request, p *profile.Profile) bool {
+		return aeuser.IsAdmin(context.NewContext(r))
+	},
+	GrantOnly: true,
+}
+
+// NewKey returns the key of the Profile with the auth id, e.g.
+// "google|12345".
+func NewKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "AuthProfile", id, 0, nil)
+}
+
+// Get is a convience method for retrieveing an entity from the ds.
+func Get(c appengine.Context, id string) (up *profile.Profile, err error) {
+	key := NewKey(c, id)
+	up = &profile.Profile{Key: key}
+	err = ds.Get(c, key, up)
+	up.Decode()
+	return
+}
+
+func GetMulti(c appengine.Context, ids []string) (pfs []*profile.Profile, err error) {
+	key := make([]*datastore.Key, len(ids))
+	for k, id := range ids {
+		key[k] = NewKey(c, id)
+	}
+	pfs = make([]*profile.Profile, len(ids))
+	for i := range pfs {
+		pfs[i] = new(profile.Profile)
+	}
+	err = ds.GetMulti(c, key, pfs)
+	for i := range pfs {
+		pfs[i].Key = key[i]
+		pfs[i].Decode()
+	}
+	return
+}
+
+func GetPersonMulti(c appengine.Context, ids []string) (pers []*person.Person, err error) {
+	pfs, err := GetMulti(c, ids)
+	pers = make([]*person.Person, len(pfs))
+	for i, pf := range pfs {
+		pers[i] = pf.Person
+	}
+	return
+}
+
+// Put is a convience method to save the Profile to the datastore and
+// updated the Updated property to time.Now().
+func Put(c appengine.Context, p *profile.Profile) error {
+	// TODO add error handeling for empty Provider and ID
+	p.Updated = time.Now()
+	p.Encode()
+	key, err := ds.Put(c, NewKey(c, p.AuthID()), p)
+	p.Key = key
+	return err
+}
+
+// Store saves Profiles to the App Engine datastore as "AuthProfile"
+// entities.
+type Store struct{}
+
+func (Store) Get(r *http.Request, id string) (*profile.Profile, error) {
+	span := tracing.StartSpan(r, "datastore.Get", tracing.Kind("AuthProfile"))
+	p, err := Get(namespace.NewContext(r), id)
+	if err == datastore.ErrNoSuchEntity {
+		err = profile.ErrNoSuchProfile
+	}
+	// A missing Profile is not a failure of the read.
+	if err == profile.ErrNoSuchProfile {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return p, err
+}
+
+func (Store) GetMulti(r *http.Request, ids []string) ([]*profile.Profile, error) {
+	span := tracing.StartSpan(r, "datastore.GetMulti", tracing.Kind("AuthProfile"))
+	ps, err := GetMulti(namespace.NewContext(r), ids)
+	tracing.End(span, err)
+	return ps, err
+}
+
+func (Store) Put(r *http.Request, p *profile.Profile) error {
+	span := tracing.StartSpan(r, "datastore.Put", tracing.Kind("AuthProfile"))
+	err := Put(namespace.NewContext(r), p)
+	tracing.End(span, err)
+	return err
+}
+
+// UserStore saves the Users of auth/user to the App Engine datastore as
+// gaego/user entities, so that gaego/user finds them too.
+type UserStore struct{}
+
+// fromUser returns the User of auth/user for that of gaego/user.
+func fromUser(id string, gu *user.User) *authuser.User {
+	u := &authuser.User{
+		ID:      id,
+		AuthIDs: append([]string(nil), gu.AuthIDs...),
+		Email:   gu.Email,
+		Roles:   append([]string(nil), gu.Roles...),
+	}
+	if gu.Email != "" {
+		u.Emails = []string{gu.Email}
+	}
+	return u
+}
+
+// getUser returns the gaego/user User with the ID, or a new one with
+// that ID if it hasn't been saved yet.
+func getUser(c appengine.Context, id string) (*user.User, error) {
+	gu, err := user.Get(c, id)
+	if err == datastore.ErrNoSuchEntity {
+		gu = user.New()
+		gu.Key = datastore.NewKey(c, "User", id, 0, nil)
+		err = nil
+	}
+	return gu, err
+}
+
+func (UserStore) New(r *http.Request) (*authuser.User, string, error) {
+	gu := user.New()
+	// Allocation an new ID
+	if err := gu.SetKey(namespace.NewContext(r)); err != nil {
+		return nil, "", err
+	}
+	id := gu.Key.StringID()
+	return fromUser(id, gu), id, nil
+}
+
+func (UserStore) Get(r *http.Request, id string) (*authuser.User, error) {
+	span := tracing.StartSpan(r, "datastore.Get", tracing.Kind("User"))
+	gu, err := user.Get(namespace.NewContext(r), id)
+	if err == datastore.ErrNoSuchEntity {
+		err = profile.ErrNoSuchUser
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return fromUser(id, gu), nil
+}
+
+// Put saves the AuthIDs, Email and Roles of the User, keeping the other
+// properties of the gaego/user entity.
+func (UserStore) Put(r *http.Request, id string, u *authuser.User) (err error) {
+	span := tracing.StartSpan(r, "datastore.Put", tracing.Kind("User"))
+	defer func() { tracing.End(span, err) }()
+	c := namespace.NewContext(r)
+	gu, err := getUser(c, id)
+	if err != nil {
+		return err
+	}
+	gu.AuthIDs = u.AuthIDs
+	gu.Email = u.Email
+	gu.Roles = u.Roles
+	return gu.Put(c)
+}
+
+func (UserStore) AddEmail(r *http.Request, id string, u *authuser.User, email string) error {
+	if u.HasEmail(email) {
+		return profile.ErrEmailExists
+	}
+	c := namespace.NewContext(r)
+	gu, err := getUser(c, id)
+	if err != nil {
+		return err
+	}
+	if _, err = gu.AddEmail(c, email, 0); err != nil {
+		return err
+	}
+	u.AddEmail(email)
+	return nil
+}
+
+func (UserStore) UserID(r *http.Request, email string) (string, error) {
+	return user.CurrentUserIDByEmail(r, email)
+}
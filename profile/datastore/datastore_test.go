@@ -2,10 +2,11 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package profile
+package datastore
 
 import (
 	"appengine/datastore"
+	"github.com/gaego/auth/profile"
 	"github.com/gaego/context"
 	"github.com/gaego/ds"
 	"github.com/gaego/person"
@@ -21,7 +22,7 @@ func TestNewKey(t *testing.T) {
 	defer tearDown()
 
 	k1 := datastore.NewKey(c, "AuthProfile", "google|12345", 0, nil)
-	k2 := newKey(c, "Google", "12345")
+	k2 := NewKey(c, profile.GenAuthID("Google", "12345"))
 	if k1.String() != k2.String() {
 		t.Errorf("k2: %q, want %q.", k2, k1)
 		t.Errorf("k1:", k1)
@@ -35,7 +36,7 @@ func TestGet(t *testing.T) {
 
 	// Save it.
 
-	u := New("Google", "http://plus.google.com")
+	u := profile.New("Google", "http://plus.google.com")
 	u.ID = "12345"
 	u.Person = &person.Person{
 		Name: &person.PersonName{
@@ -43,18 +44,16 @@ func TestGet(t *testing.T) {
 			FamilyName: "Obama",
 		},
 	}
-	key := newKey(c, "google", "12345")
-	u.Key = key
-	err := u.Put(c)
+	err := Put(c, u)
 	if err != nil {
 		t.Errorf(`err: %q, want nil`, err)
 	}
 
 	// Get it.
 
-	u2 := &Profile{}
+	u2 := &profile.Profile{}
 	id := "google|12345"
-	key = datastore.NewKey(c, "AuthProfile", id, 0, nil)
+	key := datastore.NewKey(c, "AuthProfile", id, 0, nil)
 	err = ds.Get(c, key, u2)
 	if err != nil {
 		t.Errorf(`err: %q, want nil`, err)
@@ -66,8 +65,8 @@ func TestGet(t *testing.T) {
 	if u2.ID != "12345" {
 		t.Errorf(`u2.ID: %v, want "1"`, u2.ID)
 	}
-	if u2.Key.StringID() != "google|12345" {
-		t.Errorf(`uKey.StringID(): %v, want "google|12345"`, u2.Key.StringID())
+	if u2.AuthID() != "google|12345" {
+		t.Errorf(`u2.AuthID(): %v, want "google|12345"`, u2.AuthID())
 	}
 	if x := u2.ProviderURL; x != "http://plus.google.com" {
 		t.Errorf(`u2.ProviderURL: %v, want %s`, x, "http://plus.google.com")
@@ -0,0 +1,89 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"github.com/gaego/person"
+	"net/http"
+)
+
+// The functions of this file keep the callers of the datastore API of
+// the Profiles, from before DefaultStore, compiling. They use the
+// DefaultStore with the request of the App Engine context.
+
+// Context is implemented by appengine.Context.
+//
+// Deprecated: The Stores take the request instead.
+type Context interface {
+	Request() interface{}
+}
+
+// request returns the request of the context.
+func request(c Context) *http.Request {
+	r, _ := c.Request().(*http.Request)
+	return r
+}
+
+// Key is the datastore key of a Profile, a *datastore.Key.
+//
+// Deprecated: Use Profile.AuthID.
+type Key interface {
+	StringID() string
+}
+
+// authIDKey is the Key set by SetKey.
+type authIDKey string
+
+func (k authIDKey) StringID() string {
+	return string(k)
+}
+
+// SetKey sets the Key of the Profile to its AuthID.
+//
+// Deprecated: Use AuthID.
+func (u *Profile) SetKey(c Context) error {
+	u.Key = authIDKey(u.AuthID())
+	return nil
+}
+
+// Get returns the Profile with the auth id from the DefaultStore.
+//
+// Deprecated: Use DefaultStore.Get.
+func Get(c Context, id string) (*Profile, error) {
+	return DefaultStore.Get(request(c), id)
+}
+
+// GetMulti returns the Profiles with the auth ids from the DefaultStore.
+//
+// Deprecated: Use DefaultStore.GetMulti.
+func GetMulti(c Context, ids []string) ([]*Profile, error) {
+	return DefaultStore.GetMulti(request(c), ids)
+}
+
+// GetPersonMulti returns the Person of each of the Profiles with the
+// auth ids.
+//
+// Deprecated: Use DefaultStore.GetMulti.
+func GetPersonMulti(c Context, ids []string) ([]*person.Person, error) {
+	pfs, err := GetMulti(c, ids)
+	pers := make([]*person.Person, len(pfs))
+	for i, pf := range pfs {
+		pers[i] = pf.Person
+	}
+	return pers, err
+}
+
+// Put saves the Profile to the DefaultStore.
+//
+// Deprecated: Use DefaultStore.Put.
+func (u *Profile) Put(c Context) error {
+	if err := DefaultStore.Put(request(c), u); err != nil {
+		return err
+	}
+	if u.Key == nil {
+		u.SetKey(c)
+	}
+	return nil
+}
@@ -8,29 +8,43 @@
 /*
 Package auth/profile provides the auth.Profile for starage of
 authentication strategies.
+
+The Profiles and their Users are saved by DefaultStore and
+DefaultUserStore, which fail with ErrNoStore and ErrNoUserStore until
+they are set. On App Engine they are saved to the datastore by
+importing auth/appengine, or only auth/profile/datastore, for its side
+effect:
+
+  import _ "github.com/gaego/auth/appengine"
+
+Elsewhere they may be saved to a database/sql database:
+
+  profile.DefaultStore = profile.NewSQLStore(db)
+  profile.DefaultUserStore = profile.NewSQLUserStore(db)
 */
 package profile
 
 import (
-	"appengine"
-	"appengine/datastore"
-	aeuser "appengine/user"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/gaego/context"
-	"github.com/gaego/ds"
+	"github.com/gaego/auth/audit"
+	"github.com/gaego/auth/logging"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tracing"
+	"github.com/gaego/auth/user"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
 	"net/http"
 	"strings"
 	"time"
 )
 
 type Profile struct {
-	// Key is the datastore key. It is not saved back to the datastore
-	// it is just embeded here for convience.
-	Key *datastore.Key `datastore:"-"`
+	// Key is the datastore key of a Profile read by
+	// auth/profile/datastore, or the Key set by SetKey.
+	//
+	// Deprecated: Use AuthID.
+	Key Key `datastore:"-" json:"-"`
 	// ID represents a unique ID from the Provider.
 	// This ID does not have to be unique to this application just to the
 	// provider.
@@ -56,6 +70,13 @@ type Profile struct {
 	// PersonRawJSON is the JSON encoded representation of the raw
 	// response returned from a provider representing the User's Profile.
 	PersonRawJSON []byte
+	// Claims are facts about the User asserted by the provider, e.g. the
+	// Google Workspace domain "hd", the GitHub "orgs" and "teams", or the
+	// "groups" of an OpenID Connect or SAML provider. They are matched by
+	// Rules to grant roles.
+	Claims map[string][]string `datastore:"-"`
+	// ClaimsJSON is Claims converted to JSON, for storage purposes.
+	ClaimsJSON []byte `datastore:"Claims,noindex"`
 	// Created is a time.Time representing with the Profile was created.
 	Created time.Time
 	// Created is a time.Time representing with the Profile was updated.
@@ -81,17 +102,22 @@ func GenAuthID(provider, id string) string {
 	return fmt.Sprintf("%s|%s", strings.ToLower(provider), id)
 }
 
-// newKey generates a *datastore.Key based on a string representing
-// the provider and a unique id provided by the provider.
-func newKey(c appengine.Context, provider, id string) *datastore.Key {
-	authID := GenAuthID(provider, id)
-	return datastore.NewKey(c, "AuthProfile", authID, 0, nil)
+// Claim returns the values of the claim, or nil.
+func (u *Profile) Claim(name string) []string {
+	return u.Claims[name]
 }
 
-// SetKey creates and embeds a ds.Key to the entity.
-func (u *Profile) SetKey(c appengine.Context) (err error) {
-	u.Key = newKey(c, u.ProviderName, u.ID)
-	return
+// SetClaim sets the values of the claim, replacing any previous ones.
+func (u *Profile) SetClaim(name string, values ...string) {
+	if u.Claims == nil {
+		u.Claims = make(map[string][]string)
+	}
+	u.Claims[name] = values
+}
+
+// AuthID returns the unique id of the Profile, e.g. "google|12345".
+func (u *Profile) AuthID() string {
+	return GenAuthID(u.ProviderName, u.ID)
 }
 
 // Encode is called prior to save. Any fields that need to be updated
@@ -115,12 +141,26 @@ func (u *Profile) Encode() error {
 	u.Person.Updated = u.Updated.UnixNano() / 1000000
 	// Convert to JSON
 	j, err := json.Marshal(u.Person)
+	if err != nil {
+		return err
+	}
 	u.PersonJSON = j
+	u.ClaimsJSON = nil
+	if len(u.Claims) > 0 {
+		u.ClaimsJSON, err = json.Marshal(u.Claims)
+	}
 	return err
 }
 
 // Decode is called after the entity has been retrieved from the the ds.
 func (u *Profile) Decode() error {
+	if u.ClaimsJSON != nil {
+		var cl map[string][]string
+		if err := json.Unmarshal(u.ClaimsJSON, &cl); err != nil {
+			return err
+		}
+		u.Claims = cl
+	}
 	if u.PersonJSON != nil {
 		var p *person.Person
 		err := json.Unmarshal(u.PersonJSON, &p)
@@ -130,79 +170,30 @@ func (u *Profile) Decode() error {
 	return nil
 }
 
-// Get is a convience method for retrieveing an entity from the ds.
-func Get(c appengine.Context, id string) (up *Profile, err error) {
-	up = &Profile{}
-	key := datastore.NewKey(c, "AuthProfile", id, 0, nil)
-	err = ds.Get(c, key, up)
-	up.Key = key
-	up.Decode()
-	return
-}
-
-func GetMulti(c appengine.Context, ids []string) (pfs []*Profile, err error) {
-	key := make([]*datastore.Key, len(ids))
-	for k, id := range ids {
-		key[k] = datastore.NewKey(c, "AuthProfile", id, 0, nil)
-	}
-	pfs = make([]*Profile, len(ids))
-	for i := range pfs {
-		pfs[i] = new(Profile)
-	}
-	err = ds.GetMulti(c, key, pfs)
-	for i := range pfs {
-		pfs[i].Key = key[i]
-		pfs[i].Decode()
-	}
-	return
-}
-
-func GetPersonMulti(c appengine.Context, ids []string) (pers []*person.Person, err error) {
-	pfs, err := GetMulti(c, ids)
-	pers = make([]*person.Person, len(pfs))
-	for i, pf := range pfs {
-		pers[i] = pf.Person
-	}
-	return
-}
-
-// Put is a convience method to save the Profile to the datastore and
-// updated the Updated property to time.Now().
-func (u *Profile) Put(c appengine.Context) error {
-	// TODO add error handeling for empty Provider and ID
-	u.SetKey(c)
-	u.Updated = time.Now()
-	u.Encode()
-	key, err := ds.Put(c, u.Key, u)
-	u.Key = key
-	return err
-}
-
 // UpdateUser does the following:
 //  - Search for an existing user - session -> Profile -> email address
 //  - Creates a User or appends the AuthID to the Requesting user's account
-//  - Adds the admin role to the User if they are a GAE Admin.
+//  - Grants and revokes the roles of the Rules of the login's provider
 func (p *Profile) UpdateUser(w http.ResponseWriter, r *http.Request) (u *user.User, err error) {
 
-	c := context.NewContext(r)
-	if p.Key == nil {
-		if p.ProviderName == "" && p.ID == "" {
-			return nil, errors.New("auth: key not set")
-		}
-		p.SetKey(c)
+	if p.ProviderName == "" && p.ID == "" {
+		return nil, errors.New("auth: key not set")
 	}
+	r, span := tracing.Start(r, "profile.UpdateUser", tracing.Provider(p.ProviderName))
+	defer func() { tracing.End(span, err) }()
 	var saveUser bool // flag indicating that the user needs to be saved.
+	var events []*audit.AuthEvent // recorded once the user is saved.
 
 	// Find the UserID
 	// if the AuthProfile doesn't have a UserID look it up. And populate the
 	// UserID from the saved profile.
 	if p.UserID == "" {
-		if p2, err := Get(c, p.Key.StringID()); err == nil {
+		if p2, err := DefaultStore.Get(r, p.AuthID()); err == nil {
 			p.UserID = p2.UserID
 		}
 	}
 	// look up the UserID in the session
-	currentUserID, _ := user.CurrentUserID(r)
+	currentUserID, _ := session.Default.UserID(r)
 	if currentUserID != "" {
 		if p.UserID == "" {
 			p.UserID = currentUserID
@@ -212,44 +203,47 @@ func (p *Profile) UpdateUser(w http.ResponseWriter, r *http.Request) (u *user.Us
 	}
 
 	// If we still don't have a UserID create a new user
-	if p.UserID == "" {
-		// Create User
-		u = user.New()
-		// Allocation an new ID
-		if err = u.SetKey(c); err != nil {
+	id := p.UserID
+	if id == "" {
+		// Create User with a new ID
+		if u, id, err = DefaultUserStore.New(r); err != nil {
 			return nil, err
 		}
 		saveUser = true
+		events = append(events, &audit.AuthEvent{Type: audit.UserCreated})
 	} else {
-		if u, err = user.Get(c, p.UserID); err != nil {
+		if u, err = DefaultUserStore.Get(r, id); err != nil {
 			// if user is not found we have some type of syncing problem.
-			c.Criticalf(`auth: userID: %v was saved to Profile / Session, but was not found in the datastore`, p.UserID)
+			logging.NewLogger(r).Criticalf(`auth: userID: %v was saved to Profile / Session, but was not found by DefaultUserStore`, id)
 			return nil, err
 		}
 	}
 	// Add AuthID
-	if err = u.AddAuthID(p.Key.StringID()); err == nil {
+	if err = u.AddAuthID(p.AuthID()); err == nil {
 		saveUser = true
+		events = append(events, &audit.AuthEvent{Type: audit.ProfileLinked})
 	}
 	if p.Person.Email != "" {
-		if _, err := u.AddEmail(c, p.Person.Email, 0); err == nil {
+		if err := DefaultUserStore.AddEmail(r, id, u, p.Person.Email); err == nil {
 			saveUser = true
 		}
 	}
-	// If current user is an admin in GAE add role to User
-	if aeuser.IsAdmin(c) {
-		// Save the roll to the session
-		_ = user.CurrentUserSetRole(w, r, "admin", true)
-		// Add the role to the user's roles.
-		if err = u.AddRole("admin"); err == nil {
-			saveUser = true
-		}
+	// Match the Rules against the fresh claims of this login.
+	if changed, es := applyRules(r, u, p); changed {
+		saveUser = true
+		events = append(events, es...)
 	}
 	if saveUser {
-		if err = u.Put(c); err != nil {
+		if err = DefaultUserStore.Put(r, id, u); err != nil {
 			return nil, err
 		}
 	}
-	p.UserID = u.Key.StringID()
+	p.UserID = id
+	for _, e := range events {
+		e.UserID = p.UserID
+		e.Provider = p.ProviderName
+		e.AuthID = p.AuthID()
+		audit.Record(r, e)
+	}
 	return u, nil
 }
@@ -0,0 +1,131 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"github.com/gaego/auth/audit"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"strings"
+)
+
+// Rule grants Role to the Users with a Profile that matches it. The
+// Rules are applied by UpdateUser on every login, against the Profile
+// of the login only: the Rules of other providers are left to the
+// logins with those providers.
+type Rule struct {
+	// Role is the role granted, e.g. "admin".
+	Role string
+	// Provider restricts the Rule to the Profiles of the provider, e.g.
+	// "Google". The Profiles of any provider match when it is empty.
+	Provider string
+	// Claim is the name of the claim of the Profile, e.g. "hd".
+	Claim string
+	// Values are the values of the claim that match. Any value matches
+	// when there are none.
+	Values []string
+	// Match, if set, is used instead of Claim and Values.
+	Match func(r *http.Request, p *Profile) bool
+	// GrantOnly Rules don't revoke the role when they no longer match,
+	// e.g. for roles that are also granted by other means. A role is
+	// revoked only if none of its Rules are GrantOnly.
+	GrantOnly bool
+}
+
+// Rules are the Rules applied on login. E.g. to make the members of a
+// Google Workspace domain staff, and a GitHub team developers:
+//
+//   profile.Rules = append(profile.Rules,
+//     &profile.Rule{Role: "staff", Provider: "Google", Claim: "hd",
+//       Values: []string{"example.com"}},
+//     &profile.Rule{Role: "developer", Provider: "Github", Claim: "teams",
+//       Values: []string{"example/engineering"}},
+//   )
+//
+// Importing auth/profile/datastore adds its AppEngineAdmin Rule; remove
+// it to not grant the App Engine administrators the "admin" role.
+var Rules []*Rule
+
+// Matches reports whether the Profile matches the Rule.
+func (rl *Rule) Matches(r *http.Request, p *Profile) bool {
+	if rl.Provider != "" && !strings.EqualFold(rl.Provider, p.ProviderName) {
+		return false
+	}
+	if rl.Match != nil {
+		return rl.Match(r, p)
+	}
+	vs := p.Claim(rl.Claim)
+	if len(rl.Values) == 0 {
+		return len(vs) > 0
+	}
+	for _, v := range vs {
+		for _, want := range rl.Values {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decides reports whether the Rule may decide from a login of the
+// Profile: Rules of another provider don't, as the claims of its stored
+// Profiles may be stale.
+func (rl *Rule) decides(p *Profile) bool {
+	return rl.Provider == "" || strings.EqualFold(rl.Provider, p.ProviderName)
+}
+
+// ruleRoles returns the roles to grant and to revoke for a login with
+// the Profile. A role is granted if one of its Rules that decide matches
+// the Profile. It is revoked if none match, all of its Rules decide and
+// none of them are GrantOnly.
+func ruleRoles(r *http.Request, p *Profile) (grant, revoke []string) {
+	var roles []string
+	matched := make(map[string]bool)
+	undecided := make(map[string]bool)
+	for _, rl := range Rules {
+		if _, ok := matched[rl.Role]; !ok {
+			roles = append(roles, rl.Role)
+			matched[rl.Role] = false
+		}
+		if !rl.decides(p) || rl.GrantOnly {
+			undecided[rl.Role] = true
+		}
+		if !matched[rl.Role] && rl.Matches(r, p) {
+			matched[rl.Role] = true
+		}
+	}
+	for _, role := range roles {
+		if matched[role] {
+			grant = append(grant, role)
+		} else if !undecided[role] {
+			revoke = append(revoke, role)
+		}
+	}
+	return grant, revoke
+}
+
+// applyRules grants and revokes the roles of the Rules on the User of
+// the login with the Profile. The roles are only kept on the User, not
+// in the session. It reports whether the User was changed, and returns
+// the events to record.
+func applyRules(r *http.Request, u *user.User,
+	p *Profile) (changed bool, events []*audit.AuthEvent) {
+
+	grant, revoke := ruleRoles(r, p)
+	for _, role := range grant {
+		if err := u.AddRole(role); err == nil {
+			changed = true
+			events = append(events, &audit.AuthEvent{Type: audit.RoleGranted, Detail: role})
+		}
+	}
+	for _, role := range revoke {
+		if u.HasRole(role) && u.RemoveRole(role) == nil {
+			changed = true
+			events = append(events, &audit.AuthEvent{Type: audit.RoleRevoked, Detail: role})
+		}
+	}
+	return changed, events
+}
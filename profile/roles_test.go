@@ -0,0 +1,72 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRuleMatches(t *testing.T) {
+	google := New("Google", "http://plus.google.com")
+	google.SetClaim("hd", "example.com")
+	github := New("Github", "http://github.com")
+	github.SetClaim("teams", "example/ops", "example/engineering")
+
+	tests := []struct {
+		rule *Rule
+		p    *Profile
+		want bool
+	}{
+		{&Rule{Claim: "hd", Values: []string{"example.com"}}, google, true},
+		{&Rule{Claim: "hd", Values: []string{"example.org"}}, google, false},
+		{&Rule{Provider: "google", Claim: "hd"}, google, true},
+		{&Rule{Provider: "Github", Claim: "hd"}, google, false},
+		{&Rule{Claim: "teams", Values: []string{"example/engineering"}}, github, true},
+		{&Rule{Claim: "groups"}, github, false},
+		{&Rule{Match: func(r *http.Request, p *Profile) bool { return true }}, github, true},
+		{&Rule{Provider: "Google", Match: func(r *http.Request, p *Profile) bool { return true }}, github, false},
+	}
+	for i, tt := range tests {
+		if x := tt.rule.Matches(nil, tt.p); x != tt.want {
+			t.Errorf(`%d: Matches: %v, want %v`, i, x, tt.want)
+		}
+	}
+}
+
+func TestRuleRoles(t *testing.T) {
+	defer func(rs []*Rule) { Rules = rs }(Rules)
+	Rules = []*Rule{
+		{Role: "staff", Provider: "Google", Claim: "hd", Values: []string{"example.com"}},
+		{Role: "developer", Provider: "Github", Claim: "teams", Values: []string{"example/engineering"}},
+		{Role: "member", Claim: "email"},
+		{Role: "member", Provider: "Github", Claim: "orgs"},
+		{Role: "admin", Claim: "admin", GrantOnly: true},
+	}
+	google := New("Google", "http://plus.google.com")
+	google.SetClaim("hd", "example.org")
+	github := New("Github", "http://github.com")
+	github.SetClaim("teams", "example/engineering")
+
+	tests := []struct {
+		p             *Profile
+		grant, revoke string
+	}{
+		// The developer Rule belongs to Github and doesn't decide on a
+		// Google login, nor do the member Rules as one of them is Github's.
+		{google, "", "staff"},
+		{github, "developer", "member"},
+	}
+	for i, tt := range tests {
+		grant, revoke := ruleRoles(nil, tt.p)
+		if x := strings.Join(grant, ","); x != tt.grant {
+			t.Errorf(`%d: grant: %v, want %v`, i, x, tt.grant)
+		}
+		if x := strings.Join(revoke, ","); x != tt.revoke {
+			t.Errorf(`%d: revoke: %v, want %v`, i, x, tt.revoke)
+		}
+	}
+}
@@ -5,9 +5,8 @@
 package profile
 
 import (
-	"github.com/gaego/context"
+	"github.com/gaego/auth/session"
 	"github.com/gaego/person"
-	"github.com/gaego/user"
 	"net/http"
 )
 
@@ -22,13 +21,17 @@ type Reply struct {
 func (s *Service) GetAll(w http.ResponseWriter, r *http.Request,
 	args *Args, reply *Reply) (err error) {
 
-	c := context.NewContext(r)
-	u, err := user.Current(r)
+	u, err := session.Current(r)
 	if err != nil {
 		return err
 	}
-	if reply.Profiles, err = GetPersonMulti(c, u.AuthIDs); err != nil {
+	pfs, err := DefaultStore.GetMulti(r, u.AuthIDs)
+	if err != nil {
 		return err
 	}
+	reply.Profiles = make([]*person.Person, len(pfs))
+	for i, pf := range pfs {
+		reply.Profiles[i] = pf.Person
+	}
 	return nil
 }
@@ -0,0 +1,271 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"time"
+)
+
+// SQLStore saves Profiles to a database/sql database. Queries use "?"
+// placeholders, as supported by the SQLite and MySQL drivers, and run
+// with the request's context. The Profiles of each Tenant are kept apart
+// by the namespace column.
+type SQLStore struct {
+	DB *sql.DB
+	// Table is the name of the table, by default "auth_profile".
+	Table string
+}
+
+// NewSQLStore creates a SQLStore that uses the "auth_profile" table of
+// db. The table may be created with CreateTable.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{DB: db, Table: "auth_profile"}
+}
+
+// CreateTable creates the Profile table if it does not exist. Tables
+// created before Profiles had Claims need the column added:
+//
+//   ALTER TABLE auth_profile ADD claims BLOB
+//
+// and tables created before the Tenants had namespaces need the column
+// added to the primary key, e.g. in MySQL:
+//
+//   ALTER TABLE auth_profile ADD namespace VARCHAR(100) NOT NULL DEFAULT '',
+//     DROP PRIMARY KEY, ADD PRIMARY KEY (namespace, id)
+//
+func (s *SQLStore) CreateTable() error {
+	_, err := s.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		namespace VARCHAR(100) NOT NULL DEFAULT '',
+		id VARCHAR(255) NOT NULL,
+		provider_id VARCHAR(255) NOT NULL,
+		provider_name VARCHAR(255) NOT NULL,
+		provider_url TEXT,
+		user_id VARCHAR(255),
+		auth BLOB,
+		person BLOB,
+		person_raw BLOB,
+		claims BLOB,
+		created TIMESTAMP,
+		updated TIMESTAMP,
+		PRIMARY KEY (namespace, id)
+	)`, s.Table))
+	return err
+}
+
+// requestContext returns the context of r, or a background context
+// when there is no request.
+func requestContext(r *http.Request) context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.Context()
+}
+
+func (s *SQLStore) Get(r *http.Request, id string) (*Profile, error) {
+	p := &Profile{}
+	err := s.DB.QueryRowContext(requestContext(r), fmt.Sprintf(`SELECT
+		provider_id, provider_name, provider_url, user_id, auth, person,
+		person_raw, claims, created, updated FROM %s WHERE namespace = ? AND
+		id = ?`, s.Table), tenant.Namespace(r), id).Scan(
+		&p.ID, &p.ProviderName, &p.ProviderURL, &p.UserID, &p.Auth,
+		&p.PersonJSON, &p.PersonRawJSON, &p.ClaimsJSON, &p.Created, &p.Updated)
+	if err == sql.ErrNoRows {
+		return p, ErrNoSuchProfile
+	}
+	if err != nil {
+		return p, err
+	}
+	return p, p.Decode()
+}
+
+func (s *SQLStore) GetMulti(r *http.Request, ids []string) (pfs []*Profile, err error) {
+	pfs = make([]*Profile, len(ids))
+	for i, id := range ids {
+		var e error
+		if pfs[i], e = s.Get(r, id); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (s *SQLStore) Put(r *http.Request, p *Profile) error {
+	p.Updated = time.Now()
+	if err := p.Encode(); err != nil {
+		return err
+	}
+	ctx := requestContext(r)
+	ns := tenant.Namespace(r)
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET
+		provider_id = ?, provider_name = ?, provider_url = ?, user_id = ?,
+		auth = ?, person = ?, person_raw = ?, claims = ?, created = ?,
+		updated = ? WHERE namespace = ? AND id = ?`, s.Table),
+		p.ID, p.ProviderName, p.ProviderURL, p.UserID, p.Auth,
+		p.PersonJSON, p.PersonRawJSON, p.ClaimsJSON, p.Created, p.Updated,
+		ns, p.AuthID())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (namespace, id,
+		provider_id, provider_name, provider_url, user_id, auth, person,
+		person_raw, claims, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?,
+		?, ?, ?, ?)`, s.Table),
+		ns, p.AuthID(), p.ID, p.ProviderName, p.ProviderURL, p.UserID, p.Auth,
+		p.PersonJSON, p.PersonRawJSON, p.ClaimsJSON, p.Created, p.Updated)
+	return err
+}
+
+// SQLUserStore saves Users to a database/sql database, like SQLStore.
+// The auth ids, email addresses and roles of a User are saved as JSON,
+// and each email address is a row of EmailTable so that UserID can find
+// it.
+type SQLUserStore struct {
+	DB *sql.DB
+	// Table is the name of the User table, by default "auth_user".
+	Table string
+	// EmailTable is the name of the email address table, by default
+	// "auth_user_email".
+	EmailTable string
+}
+
+// NewSQLUserStore creates a SQLUserStore that uses the "auth_user" and
+// "auth_user_email" tables of db. The tables may be created with
+// CreateTable.
+func NewSQLUserStore(db *sql.DB) *SQLUserStore {
+	return &SQLUserStore{DB: db, Table: "auth_user", EmailTable: "auth_user_email"}
+}
+
+// CreateTable creates the User and email address tables if they do not
+// exist.
+func (s *SQLUserStore) CreateTable() error {
+	_, err := s.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		namespace VARCHAR(100) NOT NULL DEFAULT '',
+		id VARCHAR(255) NOT NULL,
+		auth_ids BLOB,
+		email VARCHAR(255),
+		emails BLOB,
+		roles BLOB,
+		PRIMARY KEY (namespace, id)
+	)`, s.Table))
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		namespace VARCHAR(100) NOT NULL DEFAULT '',
+		email VARCHAR(255) NOT NULL,
+		user_id VARCHAR(255) NOT NULL,
+		PRIMARY KEY (namespace, email)
+	)`, s.EmailTable))
+	return err
+}
+
+// New returns a User with a random ID.
+func (s *SQLUserStore) New(r *http.Request) (*user.User, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	u := user.New()
+	u.ID = hex.EncodeToString(b)
+	return u, u.ID, nil
+}
+
+func (s *SQLUserStore) Get(r *http.Request, id string) (*user.User, error) {
+	var authIDs, emails, roles []byte
+	u := user.New()
+	err := s.DB.QueryRowContext(requestContext(r), fmt.Sprintf(`SELECT
+		auth_ids, email, emails, roles FROM %s WHERE namespace = ? AND
+		id = ?`, s.Table), tenant.Namespace(r), id).Scan(
+		&authIDs, &u.Email, &emails, &roles)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSuchUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.ID = id
+	for _, v := range []struct {
+		b  []byte
+		ss *[]string
+	}{{authIDs, &u.AuthIDs}, {emails, &u.Emails}, {roles, &u.Roles}} {
+		if len(v.b) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(v.b, v.ss); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func (s *SQLUserStore) Put(r *http.Request, id string, u *user.User) error {
+	authIDs, err := json.Marshal(u.AuthIDs)
+	if err != nil {
+		return err
+	}
+	emails, err := json.Marshal(u.Emails)
+	if err != nil {
+		return err
+	}
+	roles, err := json.Marshal(u.Roles)
+	if err != nil {
+		return err
+	}
+	ctx := requestContext(r)
+	ns := tenant.Namespace(r)
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET
+		auth_ids = ?, email = ?, emails = ?, roles = ? WHERE namespace = ?
+		AND id = ?`, s.Table), authIDs, u.Email, emails, roles, ns, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (namespace,
+		id, auth_ids, email, emails, roles) VALUES (?, ?, ?, ?, ?, ?)`,
+		s.Table), ns, id, authIDs, u.Email, emails, roles)
+	return err
+}
+
+func (s *SQLUserStore) AddEmail(r *http.Request, id string, u *user.User, email string) error {
+	if other, err := s.UserID(r, email); err != nil {
+		return err
+	} else if other != "" || u.HasEmail(email) {
+		return ErrEmailExists
+	}
+	// The primary key rejects an address added by a concurrent request.
+	_, err := s.DB.ExecContext(requestContext(r), fmt.Sprintf(`INSERT INTO
+		%s (namespace, email, user_id) VALUES (?, ?, ?)`, s.EmailTable),
+		tenant.Namespace(r), email, id)
+	if err != nil {
+		return err
+	}
+	u.AddEmail(email)
+	return nil
+}
+
+func (s *SQLUserStore) UserID(r *http.Request, email string) (string, error) {
+	var id string
+	err := s.DB.QueryRowContext(requestContext(r), fmt.Sprintf(`SELECT
+		user_id FROM %s WHERE namespace = ? AND email = ?`, s.EmailTable),
+		tenant.Namespace(r), email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
@@ -0,0 +1,230 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"errors"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchProfile = errors.New("auth/profile: no such profile")
+	ErrNoSuchUser    = errors.New("auth/profile: no such user")
+	ErrEmailExists   = errors.New("auth/profile: the email address has already been added")
+	ErrNoStore       = errors.New("auth/profile: no Store is set, import github.com/gaego/auth/appengine or set DefaultStore")
+	ErrNoUserStore   = errors.New("auth/profile: no UserStore is set, import github.com/gaego/auth/appengine or set DefaultUserStore")
+)
+
+func init() {
+	session.GetUser = func(r *http.Request, id string) (*user.User, error) {
+		return DefaultUserStore.Get(r, id)
+	}
+}
+
+// Store is implemented by the types that persist Profiles. The request
+// is passed so that implementations can derive a context from it; it
+// may be nil outside of a request, e.g. in tests.
+type Store interface {
+	// Get returns the Profile with the auth id, e.g. "google|12345".
+	// ErrNoSuchProfile is returned when it does not exist.
+	Get(r *http.Request, id string) (*Profile, error)
+	// GetMulti returns a Profile for each id. If any of the Profiles do
+	// not exist an empty Profile is returned in its place along with
+	// an error.
+	GetMulti(r *http.Request, ids []string) ([]*Profile, error)
+	// Put saves the Profile and sets its Updated time.
+	Put(r *http.Request, p *Profile) error
+}
+
+// DefaultStore is the Store used by the auth packages. Until it is set,
+// e.g. by importing auth/profile/datastore, it returns ErrNoStore.
+//
+// E.g. to run outside of App Engine:
+//
+//   profile.DefaultStore = profile.NewSQLStore(db)
+//
+var DefaultStore Store = noStore{}
+
+// noStore is the DefaultStore until one is set.
+type noStore struct{}
+
+func (noStore) Get(r *http.Request, id string) (*Profile, error) {
+	return &Profile{}, ErrNoStore
+}
+
+func (noStore) GetMulti(r *http.Request, ids []string) ([]*Profile, error) {
+	return nil, ErrNoStore
+}
+
+func (noStore) Put(r *http.Request, p *Profile) error {
+	return ErrNoStore
+}
+
+// UserStore is implemented by the types that persist the Users of the
+// Profiles.
+type UserStore interface {
+	// New returns a new User and its ID. It is not saved until Put.
+	New(r *http.Request) (*user.User, string, error)
+	// Get returns the User with the ID. ErrNoSuchUser is returned when
+	// it does not exist.
+	Get(r *http.Request, id string) (*user.User, error)
+	// Put saves the User with the ID.
+	Put(r *http.Request, id string, u *user.User) error
+	// AddEmail adds the email address to u, the User with the ID, and
+	// makes UserID return the ID for it. u must still be saved with
+	// Put. ErrEmailExists is returned if the address was already added,
+	// to u or to another User.
+	AddEmail(r *http.Request, id string, u *user.User, email string) error
+	// UserID returns the ID of the User with the email address, or "".
+	UserID(r *http.Request, email string) (string, error)
+}
+
+// DefaultUserStore is the UserStore used by the auth packages. Until it
+// is set, e.g. by importing auth/profile/datastore, it returns
+// ErrNoUserStore.
+var DefaultUserStore UserStore = noUserStore{}
+
+// noUserStore is the DefaultUserStore until one is set.
+type noUserStore struct{}
+
+func (noUserStore) New(r *http.Request) (*user.User, string, error) {
+	return nil, "", ErrNoUserStore
+}
+
+func (noUserStore) Get(r *http.Request, id string) (*user.User, error) {
+	return nil, ErrNoUserStore
+}
+
+func (noUserStore) Put(r *http.Request, id string, u *user.User) error {
+	return ErrNoUserStore
+}
+
+func (noUserStore) AddEmail(r *http.Request, id string, u *user.User, email string) error {
+	return ErrNoUserStore
+}
+
+func (noUserStore) UserID(r *http.Request, email string) (string, error) {
+	return "", ErrNoUserStore
+}
+
+// MemoryStore keeps Profiles in memory. It is intended for tests and
+// development servers.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	profiles map[string]*Profile
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{profiles: make(map[string]*Profile)}
+}
+
+func (s *MemoryStore) Get(r *http.Request, id string) (*Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sp, ok := s.profiles[tenant.Key(r, id)]
+	if !ok {
+		return &Profile{}, ErrNoSuchProfile
+	}
+	// Return a copy so the caller can't modify the saved Profile.
+	p := *sp
+	p.Decode()
+	return &p, nil
+}
+
+func (s *MemoryStore) GetMulti(r *http.Request, ids []string) (pfs []*Profile, err error) {
+	pfs = make([]*Profile, len(ids))
+	for i, id := range ids {
+		var e error
+		if pfs[i], e = s.Get(r, id); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (s *MemoryStore) Put(r *http.Request, p *Profile) error {
+	p.Updated = time.Now()
+	if err := p.Encode(); err != nil {
+		return err
+	}
+	sp := *p
+	sp.Person = nil
+	sp.Claims = nil
+	s.mu.Lock()
+	s.profiles[tenant.Key(r, p.AuthID())] = &sp
+	s.mu.Unlock()
+	return nil
+}
+
+// MemoryUserStore keeps Users in memory. It is intended for tests and
+// development servers.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	lastID int64
+	users  map[string]*user.User
+	emails map[string]string
+}
+
+// NewMemoryUserStore creates an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:  make(map[string]*user.User),
+		emails: make(map[string]string),
+	}
+}
+
+func (s *MemoryUserStore) New(r *http.Request) (*user.User, string, error) {
+	s.mu.Lock()
+	s.lastID++
+	id := strconv.FormatInt(s.lastID, 10)
+	s.mu.Unlock()
+	u := user.New()
+	u.ID = id
+	return u, id, nil
+}
+
+func (s *MemoryUserStore) Get(r *http.Request, id string) (*user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	su, ok := s.users[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSuchUser
+	}
+	// Return a copy so the caller can't modify the saved User.
+	return su.Copy(), nil
+}
+
+func (s *MemoryUserStore) Put(r *http.Request, id string, u *user.User) error {
+	su := u.Copy()
+	su.ID = id
+	s.mu.Lock()
+	s.users[tenant.Key(r, id)] = su
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryUserStore) AddEmail(r *http.Request, id string, u *user.User, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenant.Key(r, email)
+	if _, ok := s.emails[key]; ok || !u.AddEmail(email) {
+		return ErrEmailExists
+	}
+	s.emails[key] = id
+	return nil
+}
+
+func (s *MemoryUserStore) UserID(r *http.Request, email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.emails[tenant.Key(r, email)], nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"database/sql"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"github.com/gaego/person"
+	_ "github.com/mattn/go-sqlite3"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testStore runs the same checks against each Store implementation.
+func testStore(t *testing.T, s Store) {
+	// Not found.
+
+	if _, err := s.Get(nil, "google|12345"); err != ErrNoSuchProfile {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchProfile)
+	}
+
+	// Save it.
+
+	u := New("Google", "http://plus.google.com")
+	u.ID = "12345"
+	u.UserID = "1"
+	u.Auth = []byte("secret")
+	u.Person = &person.Person{
+		Name: &person.PersonName{
+			GivenName:  "Barack",
+			FamilyName: "Obama",
+		},
+	}
+	u.SetClaim("hd", "example.com")
+	if err := s.Put(nil, u); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	// Get it.
+
+	u2, err := s.Get(nil, "google|12345")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if u2.ID != "12345" {
+		t.Errorf(`u2.ID: %v, want "12345"`, u2.ID)
+	}
+	if u2.UserID != "1" {
+		t.Errorf(`u2.UserID: %v, want "1"`, u2.UserID)
+	}
+	if x := string(u2.Auth); x != "secret" {
+		t.Errorf(`u2.Auth: %v, want "secret"`, x)
+	}
+	if x := u2.Person.Name.GivenName; x != "Barack" {
+		t.Errorf(`u2.Person.Name.GivenName: %v, want "Barack"`, x)
+	}
+	if x := u2.Person.Kind; x != "google#person" {
+		t.Errorf(`u2.Person.Kind: %v, want "google#person"`, x)
+	}
+	if x := u2.Claim("hd"); len(x) != 1 || x[0] != "example.com" {
+		t.Errorf(`u2.Claim("hd"): %v, want [example.com]`, x)
+	}
+
+	// Update it.
+
+	u2.UserID = "2"
+	if err = s.Put(nil, u2); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	u3, _ := s.Get(nil, "google|12345")
+	if u3.UserID != "2" {
+		t.Errorf(`u3.UserID: %v, want "2"`, u3.UserID)
+	}
+
+	// GetMulti.
+
+	pfs, err := s.GetMulti(nil, []string{"google|12345", "google|missing"})
+	if err == nil {
+		t.Errorf(`err: nil, want an error`)
+	}
+	if len(pfs) != 2 {
+		t.Fatalf(`len(pfs): %v, want 2`, len(pfs))
+	}
+	if pfs[0].ID != "12345" {
+		t.Errorf(`pfs[0].ID: %v, want "12345"`, pfs[0].ID)
+	}
+
+	// Tenants don't share Profiles.
+
+	r, _ := http.NewRequest("GET", "http://example.org/", nil)
+	r = tenant.WithTenant(r, &tenant.Tenant{Name: "acme"})
+	if _, err = s.Get(r, "google|12345"); err != ErrNoSuchProfile {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchProfile)
+	}
+	u2.UserID = "3"
+	if err = s.Put(r, u2); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if u4, _ := s.Get(r, "google|12345"); u4.UserID != "3" {
+		t.Errorf(`u4.UserID: %v, want "3"`, u4.UserID)
+	}
+	if u5, _ := s.Get(nil, "google|12345"); u5.UserID != "2" {
+		t.Errorf(`u5.UserID: %v, want "2"`, u5.UserID)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	defer db.Close()
+	// Each connection to ":memory:" is a new database.
+	db.SetMaxOpenConns(1)
+	s := NewSQLStore(db)
+	if err = s.CreateTable(); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	testStore(t, s)
+}
+
+// testUserStore runs the same checks against each UserStore
+// implementation.
+func testUserStore(t *testing.T, s UserStore) {
+	if _, err := s.Get(nil, "1"); err != ErrNoSuchUser {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchUser)
+	}
+	u, id, err := s.New(nil)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if u.ID != id {
+		t.Errorf(`u.ID: %v, want %v`, u.ID, id)
+	}
+	if _, id2, _ := s.New(nil); id2 == id {
+		t.Errorf(`id: %v, want a new ID`, id2)
+	}
+	u.AddAuthID("google|12345")
+	u.AddRole("admin")
+	if err = s.Put(nil, id, u); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	u2, err := s.Get(nil, id)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if u2.ID != id || len(u2.AuthIDs) != 1 || u2.AuthIDs[0] != "google|12345" || !u2.HasRole("admin") {
+		t.Errorf(`u2: %+v, want the saved User`, u2)
+	}
+
+	// The returned User shares nothing with the saved one.
+
+	u2.AuthIDs[0] = "google|changed"
+	if u3, _ := s.Get(nil, id); u3.AuthIDs[0] != "google|12345" {
+		t.Errorf(`u3.AuthIDs[0]: %v, want "google|12345"`, u3.AuthIDs[0])
+	}
+
+	// Email addresses.
+
+	if err = s.AddEmail(nil, id, u, "test@example.org"); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if u.Email != "test@example.org" || !u.HasEmail("test@example.org") {
+		t.Errorf(`u: %+v, want the email address added`, u)
+	}
+	if err = s.AddEmail(nil, id, u, "test@example.org"); err != ErrEmailExists {
+		t.Errorf(`err: %v, want %v`, err, ErrEmailExists)
+	}
+	if err = s.AddEmail(nil, "2", user.New(), "test@example.org"); err != ErrEmailExists {
+		t.Errorf(`err: %v, want %v`, err, ErrEmailExists)
+	}
+	if x, _ := s.UserID(nil, "test@example.org"); x != id {
+		t.Errorf(`UserID: %v, want %v`, x, id)
+	}
+	if x, _ := s.UserID(nil, "other@example.org"); x != "" {
+		t.Errorf(`UserID: %v, want ""`, x)
+	}
+	if err = s.Put(nil, id, u); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if u4, _ := s.Get(nil, id); u4.Email != "test@example.org" || !u4.HasEmail("test@example.org") {
+		t.Errorf(`u4: %+v, want the email address saved`, u4)
+	}
+
+	// Tenants don't share Users.
+
+	r, _ := http.NewRequest("GET", "http://example.org/", nil)
+	r = tenant.WithTenant(r, &tenant.Tenant{Name: "acme"})
+	if _, err = s.Get(r, id); err != ErrNoSuchUser {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchUser)
+	}
+	if x, _ := s.UserID(r, "test@example.org"); x != "" {
+		t.Errorf(`UserID: %v, want ""`, x)
+	}
+}
+
+func TestMemoryUserStore(t *testing.T) {
+	testUserStore(t, NewMemoryUserStore())
+}
+
+func TestSQLUserStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	s := NewSQLUserStore(db)
+	if err = s.CreateTable(); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	testUserStore(t, s)
+}
+
+func TestUpdateUser(t *testing.T) {
+	defer func(s Store, us UserStore) {
+		DefaultStore, DefaultUserStore = s, us
+	}(DefaultStore, DefaultUserStore)
+	DefaultStore, DefaultUserStore = NewMemoryStore(), NewMemoryUserStore()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+	p := New("Google", "http://plus.google.com")
+	p.ID = "12345"
+	p.Person.Email = "test@example.org"
+	if _, err := p.UpdateUser(w, r); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if p.UserID == "" {
+		t.Fatalf(`p.UserID: "", want the ID of the new User`)
+	}
+	if _, err := DefaultUserStore.Get(r, p.UserID); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if x, _ := DefaultUserStore.UserID(r, "test@example.org"); x != p.UserID {
+		t.Errorf(`UserID: %v, want %v`, x, p.UserID)
+	}
+	if err := DefaultStore.Put(r, p); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	// The next login finds the User of the saved Profile.
+
+	p2 := New("Google", "http://plus.google.com")
+	p2.ID = "12345"
+	if _, err := p2.UpdateUser(w, r); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if p2.UserID != p.UserID {
+		t.Errorf(`p2.UserID: %v, want %v`, p2.UserID, p.UserID)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"github.com/gaego/auth/apikey"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/auth/session"
+	"github.com/gaego/auth/token"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	loginKey contextKey = iota
+	userKey
+)
+
+// CurrentLogin returns the login of a request passed by Require, or
+// nil.
+func CurrentLogin(r *http.Request) *session.LoginInfo {
+	l, _ := r.Context().Value(loginKey).(*session.LoginInfo)
+	return l
+}
+
+// CurrentUser returns the User of a request passed by Require.
+func CurrentUser(r *http.Request) (*user.User, error) {
+	if u, ok := r.Context().Value(userKey).(*user.User); ok {
+		return u, nil
+	}
+	l := CurrentLogin(r)
+	if l == nil {
+		return nil, user.ErrNoLoggedInUser
+	}
+	return profile.DefaultUserStore.Get(r, l.UserID)
+}
+
+// authenticate returns the login of the request's API key, access token
+// or session, the API key if any, and the User if it was loaded.
+func authenticate(r *http.Request) (*session.LoginInfo, *apikey.Key, *user.User, error) {
+	if k := apikey.Current(r); k != nil {
+		return &session.LoginInfo{UserID: k.UserID, Provider: "apikey"}, k, apikey.User(r), nil
+	}
+	if raw, ok := bearerToken(r); ok {
+		if strings.HasPrefix(raw, apikey.Prefix) {
+			k, err := apikey.Authenticate(r, raw)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			return &session.LoginInfo{UserID: k.UserID, Provider: "apikey"}, k, nil, nil
+		}
+		cl, err := token.Verify(r, raw)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// The tokens of auth/oidc are for other apps.
+		if cl.ClientID != "" {
+			return nil, nil, nil, token.ErrInvalidToken
+		}
+		return &session.LoginInfo{UserID: cl.Subject, Provider: cl.Provider}, nil, nil, nil
+	}
+	l, err := session.CurrentLogin(r)
+	if err == nil && l.UserID == "" {
+		err = user.ErrNoLoggedInUser
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return l, nil, nil, nil
+}
+
+// wantsJSON reports whether the request was made by a script rather than
+// a browser navigation, so that it should get a JSON error instead of a
+// redirect to the login page.
+func wantsJSON(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" ||
+		r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// LoginRedirectURL returns the LoginURL of the request's Tenant with
+// NextField set to return to next, a local URL, after the login, e.g.
+// for auth/oidc.
+func LoginRedirectURL(r *http.Request, next string) string {
+	return loginRedirect(r, url.Values{NextField: {next}})
+}
+
+// redirectToLogin sends the browser to LoginURL, to return to the
+// request's URL after the login.
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, LoginRedirectURL(r, r.URL.RequestURI()), http.StatusFound)
+}
+
+// unauthorized rejects a request that needs a, or a more recent, login.
+func unauthorized(w http.ResponseWriter, r *http.Request, e string) {
+	if !wantsJSON(r) {
+		redirectToLogin(w, r)
+		return
+	}
+	if e == "invalid_token" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(w, http.StatusUnauthorized, &TokenReply{Error: e})
+}
+
+// forbidden rejects a request whose login may not be used.
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusForbidden, &TokenReply{Error: "forbidden"})
+	} else {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
+
+// Require calls h only for requests with a logged in User, from the
+// session, an API key or an access token of auth/token. Browsers are
+// otherwise redirected to LoginURL, with NextField set to return to the
+// request's URL, and other requests get a 401 JSON error. The login is
+// available to h through CurrentLogin and CurrentUser.
+//
+//   http.Handle("/account", auth.Require(account))
+//
+func Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, k, u, err := authenticate(r)
+		if err != nil {
+			e := "login_required"
+			if _, ok := bearerToken(r); ok {
+				e = "invalid_token"
+			}
+			unauthorized(w, r, e)
+			return
+		}
+		if k != nil && apikey.Current(r) == nil {
+			r = apikey.NewRequest(r, k, nil)
+		}
+		ctx := context.WithValue(r.Context(), loginKey, l)
+		if u != nil {
+			ctx = context.WithValue(ctx, userKey, u)
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole is Require for Users with the role, e.g. "admin". Other
+// Users get a 403.
+func RequireRole(role string, h http.Handler) http.Handler {
+	return Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := CurrentUser(r)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &TokenReply{Error: "server_error"})
+			return
+		}
+		if !u.HasRole(role) {
+			forbidden(w, r)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+	}))
+}
+
+// RequireScope is Require for requests whose API key was granted the
+// scope, e.g. "repo". Keys without Scopes, sessions and access tokens
+// have every scope. Requests with other keys get a 403.
+//
+//   http.Handle("/api/repos", auth.RequireScope("repo", repos))
+//
+func RequireScope(scope string, h http.Handler) http.Handler {
+	return Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if k := apikey.Current(r); k != nil && len(k.Scopes) > 0 && !k.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			writeJSON(w, http.StatusForbidden, &TokenReply{Error: "insufficient_scope"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// RequireFreshLogin is Require for Users who logged in with a provider
+// within maxAge, e.g. before changing their email address. Otherwise
+// they must log in again. API keys, access tokens and stores that don't
+// record the time of the login, e.g. the gaego/user cookie, get a 403, as
+// logging in again wouldn't help.
+func RequireFreshLogin(maxAge time.Duration, h http.Handler) http.Handler {
+	return Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := CurrentLogin(r)
+		if l.AuthTime.IsZero() {
+			forbidden(w, r)
+			return
+		}
+		if time.Since(l.AuthTime) > maxAge {
+			unauthorized(w, r, "fresh_login_required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,26 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"net/http"
+)
+
+type Service struct{}
+
+type Args struct{}
+
+type Reply struct {
+	Providers []*ProviderInfo
+}
+
+// Providers returns the providers of the request's Tenant, e.g. for the
+// login page of a single-page app. It doesn't need a login.
+func (s *Service) Providers(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	reply.Providers = ListProviders(r)
+	return nil
+}
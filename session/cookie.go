@@ -0,0 +1,149 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCookie = errors.New("auth/session: the session cookie is invalid")
+	ErrShortKey      = errors.New("auth/session: the cookie key must be at least 32 bytes")
+)
+
+// MinKeyLen is the minimum length of the key of a CookieStore, that of
+// the HMAC-SHA256 output.
+const MinKeyLen = 32
+
+// CookieStore keeps the session in a cookie signed with HMAC-SHA256.
+// The cookie is not encrypted so it must not hold secrets.
+type CookieStore struct {
+	Name string
+	Key  []byte
+	// Options is the cookie policy. If nil DefaultOptions is used.
+	Options *Options
+}
+
+// options returns the cookie policy of the store.
+func (s *CookieStore) options() *Options {
+	if s.Options != nil {
+		return s.Options
+	}
+	return DefaultOptions
+}
+
+// NewCookieStore creates a CookieStore that signs the cookie name with
+// key, e.g. 32 random bytes. It panics with ErrShortKey if the key is
+// shorter than MinKeyLen, as the cookies could be forged.
+func NewCookieStore(name string, key []byte) *CookieStore {
+	if len(key) < MinKeyLen {
+		panic(ErrShortKey)
+	}
+	return &CookieStore{Name: name, Key: key}
+}
+
+func (s *CookieStore) sign(b []byte) []byte {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func (s *CookieStore) encode(d *Data) (string, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(b)), nil
+}
+
+func (s *CookieStore) decode(v string) (*Data, error) {
+	i := strings.LastIndex(v, ".")
+	if i < 0 {
+		return nil, ErrInvalidCookie
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(v[i+1:])
+	if err != nil || !hmac.Equal(sig, s.sign(b)) {
+		return nil, ErrInvalidCookie
+	}
+	d := new(Data)
+	if err = json.Unmarshal(b, d); err != nil {
+		return nil, ErrInvalidCookie
+	}
+	if !d.Expires.IsZero() && time.Now().After(d.Expires) {
+		return nil, ErrNoSession
+	}
+	return d, nil
+}
+
+// get returns the Data of the request's session.
+func (s *CookieStore) get(r *http.Request) (*Data, error) {
+	c, err := r.Cookie(s.Name)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	return s.decode(c.Value)
+}
+
+func (s *CookieStore) save(w http.ResponseWriter, r *http.Request, d *Data) error {
+	v, err := s.encode(d)
+	if err != nil {
+		return err
+	}
+	setCookie(w, r, s.options().cookies(s.Name, v))
+	return nil
+}
+
+// SetLogin starts a new session for the login; nothing is carried over
+// from the request's session.
+func (s *CookieStore) SetLogin(w http.ResponseWriter, r *http.Request, l *LoginInfo) error {
+	return s.save(w, r, newData(l, s.options()))
+}
+
+func (s *CookieStore) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return s.SetLogin(w, r, &LoginInfo{UserID: userID, AuthTime: time.Now()})
+}
+
+func (s *CookieStore) UserID(r *http.Request) (string, error) {
+	d, err := s.get(r)
+	if err != nil || d.UserID == "" {
+		return "", user.ErrNoLoggedInUser
+	}
+	return d.UserID, nil
+}
+
+func (s *CookieStore) LoginInfo(r *http.Request) (*LoginInfo, error) {
+	d, err := s.get(r)
+	if err != nil {
+		return nil, user.ErrNoLoggedInUser
+	}
+	return d.loginInfo()
+}
+
+func (s *CookieStore) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	d, err := s.get(r)
+	if err != nil {
+		return user.ErrNoLoggedInUser
+	}
+	d.setRole(role, value)
+	return s.save(w, r, d)
+}
+
+func (s *CookieStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	setCookie(w, r, s.options().expired(s.Name))
+	return nil
+}
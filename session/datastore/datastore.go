@@ -0,0 +1,269 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/session/datastore saves the sessions of auth/session to the
+App Engine datastore, as "AuthSession" entities, and sets
+session.Default to them, with a Registry that records every login. It
+is imported for its side effect:
+
+  import _ "github.com/gaego/auth/session/datastore"
+
+The default also keeps the gaego/user session cookie in sync, see
+Compat, so that apps that call gaego/user's Current or CurrentUserID
+keep working.
+
+It also provides the Backends of the remember me Series and the
+Registry's Records, and UserStore, the gaego/user session cookie.
+*/
+package datastore
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"bytes"
+	"github.com/gaego/auth/namespace"
+	"github.com/gaego/auth/session"
+	authuser "github.com/gaego/auth/user"
+	"github.com/gaego/user"
+	"net/http"
+	"time"
+)
+
+func init() {
+	session.Default = Compat{session.NewRegistry(session.NewServerStore("auth", Backend{}), Records{})}
+}
+
+// Backend saves sessions to the App Engine datastore as
+// "AuthSession" entities.
+type Backend struct{}
+
+func (Backend) Get(r *http.Request, id string) (*session.Data, error) {
+	c := namespace.NewContext(r)
+	d := new(session.Data)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthSession", id, 0, nil), d)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, session.ErrNoSession
+	}
+	return d, err
+}
+
+func (Backend) Put(r *http.Request, id string, d *session.Data) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthSession", id, 0, nil), d)
+	return err
+}
+
+func (Backend) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthSession", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+// Series saves the remember me Series to the App Engine datastore as
+// "AuthRememberSeries" entities.
+type Series struct{}
+
+func (Series) Get(r *http.Request, id string) (*session.Series, error) {
+	c := namespace.NewContext(r)
+	s := new(session.Series)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthRememberSeries", id, 0, nil), s)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, session.ErrNoSession
+	}
+	s.ID = id
+	return s, err
+}
+
+func (Series) Put(r *http.Request, s *session.Series) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthRememberSeries", s.ID, 0, nil), s)
+	return err
+}
+
+func (Series) Update(r *http.Request, s *session.Series, old []byte) error {
+	c := namespace.NewContext(r)
+	key := datastore.NewKey(c, "AuthRememberSeries", s.ID, 0, nil)
+	return datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		cur := new(session.Series)
+		err := datastore.Get(tc, key, cur)
+		if err == datastore.ErrNoSuchEntity {
+			return session.ErrNoSession
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(cur.TokenHash, old) {
+			return session.ErrTokenReused
+		}
+		_, err = datastore.Put(tc, key, s)
+		return err
+	}, nil)
+}
+
+func (Series) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthRememberSeries", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Series) List(r *http.Request, userID string) ([]*session.Series, error) {
+	c := namespace.NewContext(r)
+	var ss []*session.Series
+	keys, err := datastore.NewQuery("AuthRememberSeries").
+		Filter("UserID =", userID).GetAll(c, &ss)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		ss[i].ID = k.StringID()
+	}
+	return ss, nil
+}
+
+// Records saves Records to the App Engine datastore as
+// "AuthSessionRecord" entities.
+type Records struct{}
+
+func (Records) Get(r *http.Request, id string) (*session.Record, error) {
+	c := namespace.NewContext(r)
+	rec := new(session.Record)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthSessionRecord", id, 0, nil), rec)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, session.ErrNoSession
+	}
+	rec.ID = id
+	return rec, err
+}
+
+func (Records) Put(r *http.Request, rec *session.Record) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthSessionRecord", rec.ID, 0, nil), rec)
+	return err
+}
+
+func (Records) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthSessionRecord", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+func (Records) List(r *http.Request, userID string) ([]*session.Record, error) {
+	c := namespace.NewContext(r)
+	var recs []*session.Record
+	keys, err := datastore.NewQuery("AuthSessionRecord").
+		Filter("UserID =", userID).GetAll(c, &recs)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		recs[i].ID = k.StringID()
+	}
+	return recs, nil
+}
+
+// UserStore is the Store that uses the gaego/user session cookie. Its
+// cookie is not affected by the Options and is not rotated on login.
+type UserStore struct{}
+
+// loggedIn returns the error of auth/user for that of gaego/user.
+func loggedIn(err error) error {
+	if err == user.ErrNoLoggedInUser {
+		return authuser.ErrNoLoggedInUser
+	}
+	return err
+}
+
+func (UserStore) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return user.CurrentUserSetID(w, r, userID)
+}
+
+func (UserStore) UserID(r *http.Request) (string, error) {
+	id, err := user.CurrentUserID(r)
+	return id, loggedIn(err)
+}
+
+func (UserStore) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	return loggedIn(user.CurrentUserSetRole(w, r, role, value))
+}
+
+func (UserStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	return user.Logout(w, r)
+}
+
+// Compat is a Store that keeps the gaego/user session cookie in sync
+// with the wrapped Store, for the apps that still call gaego/user's
+// Current or CurrentUserID. The wrapped Store decides who is logged in
+// for the auth packages. A session revoked in a Registry keeps its
+// gaego/user cookie until it logs out, so apps should move to
+// session.Current.
+type Compat struct {
+	Store session.Store
+}
+
+// Unwrap returns the wrapped Store.
+func (c Compat) Unwrap() session.Store {
+	return c.Store
+}
+
+// SetLogin logs in the User with the wrapped Store and the gaego/user
+// cookie.
+func (c Compat) SetLogin(w http.ResponseWriter, r *http.Request, l *session.LoginInfo) error {
+	var err error
+	if ls, ok := c.Store.(interface {
+		SetLogin(w http.ResponseWriter, r *http.Request, l *session.LoginInfo) error
+	}); ok {
+		err = ls.SetLogin(w, r, l)
+	} else {
+		err = c.Store.SetUserID(w, r, l.UserID)
+	}
+	if err != nil {
+		return err
+	}
+	return user.CurrentUserSetID(w, r, l.UserID)
+}
+
+func (c Compat) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return c.SetLogin(w, r, &session.LoginInfo{UserID: userID, AuthTime: time.Now()})
+}
+
+// LoginInfo returns the login of the wrapped Store.
+func (c Compat) LoginInfo(r *http.Request) (*session.LoginInfo, error) {
+	if lg, ok := c.Store.(interface {
+		LoginInfo(r *http.Request) (*session.LoginInfo, error)
+	}); ok {
+		return lg.LoginInfo(r)
+	}
+	id, err := c.Store.UserID(r)
+	if err != nil {
+		return nil, err
+	}
+	return &session.LoginInfo{UserID: id}, nil
+}
+
+func (c Compat) UserID(r *http.Request) (string, error) {
+	return c.Store.UserID(r)
+}
+
+func (c Compat) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	if err := c.Store.SetRole(w, r, role, value); err != nil {
+		return err
+	}
+	return loggedIn(user.CurrentUserSetRole(w, r, role, value))
+}
+
+func (c Compat) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if err := c.Store.Destroy(w, r); err != nil {
+		return err
+	}
+	return user.Logout(w, r)
+}
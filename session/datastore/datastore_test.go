@@ -0,0 +1,51 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"github.com/gaego/auth/session"
+	authuser "github.com/gaego/auth/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	// The default Store keeps the gaego/user cookie, records the logins
+	// and gives the sessions a new ID on login.
+	c, ok := session.Default.(Compat)
+	if !ok {
+		t.Fatalf(`Default: %T, want Compat`, session.Default)
+	}
+	g, ok := c.Store.(*session.Registry)
+	if !ok {
+		t.Fatalf(`Store: %T, want *session.Registry`, c.Store)
+	}
+	if _, ok = g.Store.(*session.ServerStore); !ok {
+		t.Errorf(`Store: %T, want *session.ServerStore`, g.Store)
+	}
+	if _, ok = g.Records.(Records); !ok {
+		t.Errorf(`Records: %T, want Records`, g.Records)
+	}
+}
+
+func TestCompat(t *testing.T) {
+	c := Compat{session.NewCookieStore("auth", []byte("01234567890123456789012345678901"))}
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	w := httptest.NewRecorder()
+	if err := c.SetLogin(w, r, &session.LoginInfo{UserID: "1", Provider: "Google"}); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	l, err := c.LoginInfo(r)
+	if err != nil || l.UserID != "1" || l.Provider != "Google" {
+		t.Errorf(`l: %+v, err: %v, want the Google login of User 1`, l, err)
+	}
+	if err = c.Destroy(w, r); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if _, err = c.UserID(r); err != authuser.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, authuser.ErrNoLoggedInUser)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gaego/auth/origin"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LastSeenInterval is how often a session's LastSeen time is updated.
+var LastSeenInterval = 5 * time.Minute
+
+// Record is an entry in the Registry for each login. Its ID is the hash
+// of the token in the session's cookie, so that the IDs that are listed
+// can't be used as cookies.
+type Record struct {
+	ID        string    `datastore:"-" json:"id"`
+	UserID    string    `json:"-"`
+	Provider  string    `json:"provider"`
+	IP        string    `datastore:",noindex" json:"ip"`
+	UserAgent string    `datastore:",noindex" json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// SeriesID is the ID of the remember me Series that logged in the
+	// session, see RememberStore.
+	SeriesID string `datastore:",noindex" json:"-"`
+	// Current is set when the Record is the session of the request.
+	Current bool `datastore:"-" json:"current"`
+}
+
+// RecordBackend is implemented by the types that persist Records.
+type RecordBackend interface {
+	// Get returns the Record, or ErrNoSession.
+	Get(r *http.Request, id string) (*Record, error)
+	Put(r *http.Request, rec *Record) error
+	Delete(r *http.Request, id string) error
+	// List returns the User's Records.
+	List(r *http.Request, userID string) ([]*Record, error)
+}
+
+// Registry is a Store that records every login so that the User can
+// list their sessions and revoke them. It wraps the Store that holds the
+// session; a session whose Record has been revoked is logged out on its
+// next request. auth/session/datastore sets Default to a Registry.
+//
+//	session.Default = session.NewRegistry(session.Default, session.NewMemoryRecords())
+type Registry struct {
+	Store   Store
+	Records RecordBackend
+	// Name is the name of the cookie holding the token of the Record.
+	Name string
+	// Options is the cookie policy. If nil DefaultOptions is used.
+	Options *Options
+}
+
+// NewRegistry creates a Registry around s that saves its Records to b.
+func NewRegistry(s Store, b RecordBackend) *Registry {
+	return &Registry{Store: s, Records: b, Name: "auth-session"}
+}
+
+// options returns the cookie policy of the Registry.
+func (g *Registry) options() *Options {
+	if g.Options != nil {
+		return g.Options
+	}
+	return DefaultOptions
+}
+
+// Unwrap returns the wrapped Store.
+func (g *Registry) Unwrap() Store {
+	return g.Store
+}
+
+// recordID returns the ID of the Record of the cookie's token.
+func recordID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// current returns the Record of the request's session.
+func (g *Registry) current(r *http.Request) (*Record, error) {
+	c, err := r.Cookie(g.Name)
+	if err != nil || c.Value == "" {
+		return nil, ErrNoSession
+	}
+	return g.Records.Get(r, recordID(c.Value))
+}
+
+// SetLogin logs in the User and records the login.
+func (g *Registry) SetLogin(w http.ResponseWriter, r *http.Request, l *LoginInfo) error {
+	if err := login(g.Store, w, r, l); err != nil {
+		return err
+	}
+	// The login replaces any previous session of this browser.
+	if rec, err := g.current(r); err == nil {
+		g.Records.Delete(r, rec.ID)
+	}
+	now := time.Now()
+	token := NewID()
+	rec := &Record{
+		ID:        recordID(token),
+		UserID:    l.UserID,
+		Provider:  l.Provider,
+		IP:        origin.IP(r),
+		UserAgent: r.UserAgent(),
+		Created:   now,
+		LastSeen:  now,
+	}
+	if err := g.Records.Put(r, rec); err != nil {
+		return err
+	}
+	setCookie(w, r, g.options().cookies(g.Name, token))
+	return nil
+}
+
+func (g *Registry) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return g.SetLogin(w, r, &LoginInfo{UserID: userID, AuthTime: time.Now()})
+}
+
+// LoginInfo returns the login of the wrapped Store unless the session's
+// Record has been revoked.
+func (g *Registry) LoginInfo(r *http.Request) (*LoginInfo, error) {
+	if _, err := g.UserID(r); err != nil {
+		return nil, err
+	}
+	return loginInfo(g.Store, r)
+}
+
+// UserID returns the ID of the logged in User. The session is treated
+// as logged out when its Record has been revoked.
+func (g *Registry) UserID(r *http.Request) (string, error) {
+	id, err := g.Store.UserID(r)
+	if err != nil {
+		return "", err
+	}
+	rec, err := g.current(r)
+	if err != nil || rec.UserID != id {
+		return "", user.ErrNoLoggedInUser
+	}
+	if time.Since(rec.LastSeen) > LastSeenInterval {
+		rec.LastSeen = time.Now()
+		g.Records.Put(r, rec)
+	}
+	return id, nil
+}
+
+// SetRole changes the role and moves the session's Record to a new ID.
+func (g *Registry) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	if err := g.Store.SetRole(w, r, role, value); err != nil {
+		return err
+	}
+	rec, err := g.current(r)
+	if err != nil {
+		return nil
+	}
+	oldID := rec.ID
+	token := NewID()
+	rec.ID = recordID(token)
+	if err = g.Records.Put(r, rec); err != nil {
+		return err
+	}
+	if err = g.Records.Delete(r, oldID); err != nil {
+		return err
+	}
+	setCookie(w, r, g.options().cookies(g.Name, token))
+	return nil
+}
+
+func (g *Registry) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if rec, err := g.current(r); err == nil {
+		if err = g.Records.Delete(r, rec.ID); err != nil {
+			return err
+		}
+	}
+	setCookie(w, r, g.options().expired(g.Name))
+	return g.Store.Destroy(w, r)
+}
+
+// List returns the sessions of the logged in User, newest first.
+func (g *Registry) List(r *http.Request) ([]*Record, error) {
+	id, err := g.UserID(r)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := g.Records.List(r, id)
+	if err != nil {
+		return nil, err
+	}
+	cur, _ := g.current(r)
+	for _, rec := range recs {
+		rec.Current = cur != nil && rec.ID == cur.ID
+	}
+	sort.Sort(byCreated(recs))
+	return recs, nil
+}
+
+// link ties the remember me Series to the Record of the request's
+// session.
+func (g *Registry) link(r *http.Request, seriesID string) error {
+	rec, err := g.current(r)
+	if err != nil || rec.SeriesID == seriesID {
+		return nil
+	}
+	rec.SeriesID = seriesID
+	return g.Records.Put(r, rec)
+}
+
+// Revoke logs out one of the logged in User's sessions, and revokes the
+// remember me Series that logged it in.
+func (g *Registry) Revoke(r *http.Request, recordID string) error {
+	id, err := g.UserID(r)
+	if err != nil {
+		return err
+	}
+	rec, err := g.Records.Get(r, recordID)
+	if err != nil {
+		return err
+	}
+	if rec.UserID != id {
+		return ErrNoSession
+	}
+	if err = g.Records.Delete(r, rec.ID); err != nil {
+		return err
+	}
+	if rs, err := rememberStore(); err == nil && rec.SeriesID != "" {
+		return rs.Series.Delete(r, rec.SeriesID)
+	}
+	return nil
+}
+
+// RevokeOthers logs out all of the logged in User's sessions except
+// that of the request, and revokes their remember me Series.
+func (g *Registry) RevokeOthers(r *http.Request) error {
+	id, err := g.UserID(r)
+	if err != nil {
+		return err
+	}
+	recs, err := g.List(r)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.Current {
+			continue
+		}
+		if err = g.Records.Delete(r, rec.ID); err != nil {
+			return err
+		}
+	}
+	if rs, err := rememberStore(); err == nil {
+		return rs.revokeOthers(r, id)
+	}
+	return nil
+}
+
+type byCreated []*Record
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+// MemoryRecords keeps Records in memory. It is intended for tests and
+// development servers.
+type MemoryRecords struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+// NewMemoryRecords creates an empty MemoryRecords.
+func NewMemoryRecords() *MemoryRecords {
+	return &MemoryRecords{records: make(map[string]Record)}
+}
+
+func (b *MemoryRecords) Get(r *http.Request, id string) (*Record, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	rec, ok := b.records[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return &rec, nil
+}
+
+func (b *MemoryRecords) Put(r *http.Request, rec *Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records[tenant.Key(r, rec.ID)] = *rec
+	return nil
+}
+
+func (b *MemoryRecords) Delete(r *http.Request, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.records, tenant.Key(r, id))
+	return nil
+}
+
+func (b *MemoryRecords) List(r *http.Request, userID string) ([]*Record, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var recs []*Record
+	for id, rec := range b.records {
+		if rec.UserID == userID && tenant.InNamespace(r, id) {
+			rec := rec
+			recs = append(recs, &rec)
+		}
+	}
+	return recs, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"github.com/gaego/auth/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	testStore(t, NewRegistry(NewCookieStore("auth", []byte("01234567890123456789012345678901")), NewMemoryRecords()))
+}
+
+func TestRegistry_Revoke(t *testing.T) {
+	g := NewRegistry(NewCookieStore("auth", []byte("01234567890123456789012345678901")), NewMemoryRecords())
+
+	// Login on two devices.
+
+	laptop, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	laptop.Header.Set("User-Agent", "laptop")
+	laptop.RemoteAddr = "10.0.0.1:1234"
+	if err := g.SetLogin(httptest.NewRecorder(), laptop, &LoginInfo{UserID: "1", Provider: "Google"}); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	phone, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	phone.Header.Set("User-Agent", "phone")
+	if err := g.SetLogin(httptest.NewRecorder(), phone, &LoginInfo{UserID: "1", Provider: "Password"}); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+
+	// List.
+
+	recs, err := g.List(phone)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf(`len(recs): %v, want 2`, len(recs))
+	}
+	for _, rec := range recs {
+		if rec.Current != (rec.UserAgent == "phone") {
+			t.Errorf(`rec.Current: %v for %v`, rec.Current, rec.UserAgent)
+		}
+		if rec.UserAgent == "laptop" && (rec.Provider != "Google" || rec.IP != "10.0.0.1") {
+			t.Errorf(`rec: %v, want Google from 10.0.0.1`, rec)
+		}
+	}
+
+	// The listed IDs can't be used as cookies.
+
+	c, err := phone.Cookie("auth-session")
+	if err != nil {
+		t.Fatalf(`err: %v, want the cookie of the Record`, err)
+	}
+	for _, rec := range recs {
+		if rec.ID == c.Value {
+			t.Errorf(`rec.ID: %v, want the hash of the cookie`, rec.ID)
+		}
+	}
+
+	// Sign out other devices.
+
+	if err = g.RevokeOthers(phone); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if _, err = g.UserID(laptop); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+	if id, _ := g.UserID(phone); id != "1" {
+		t.Errorf(`id: %v, want "1"`, id)
+	}
+
+	// Another User's session can't be revoked.
+
+	other, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	g.SetLogin(httptest.NewRecorder(), other, &LoginInfo{UserID: "2", Provider: "Google"})
+	recs, _ = g.List(other)
+	if err = g.Revoke(phone, recs[0].ID); err != ErrNoSession {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSession)
+	}
+	if id, _ := g.UserID(other); id != "2" {
+		t.Errorf(`id: %v, want "2"`, id)
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoRememberStore = errors.New("auth/session: remember me is not enabled")
+	ErrTokenReused     = errors.New("auth/session: remember me token reused, the series has been revoked")
+)
+
+// RememberMaxAge is the lifetime of a remember me series.
+var RememberMaxAge = 90 * 24 * time.Hour
+
+// RememberGrace is how long the previous token of a Series is still
+// accepted after it was replaced, for the concurrent requests of a
+// browser that sent it before it got the new token.
+var RememberGrace = 30 * time.Second
+
+// Series is a remember me login. The cookie holds the series ID and a
+// token; only the hash of the token is saved. The token is replaced each
+// time the series is used, so a request with a previous token means that
+// the cookie was stolen.
+type Series struct {
+	ID        string `datastore:"-"`
+	UserID    string
+	Provider  string
+	TokenHash []byte
+	// PrevTokenHash is the hash of the token replaced at LastUsed.
+	PrevTokenHash []byte `datastore:",noindex"`
+	Created       time.Time
+	LastUsed      time.Time
+	Expires       time.Time
+}
+
+// SeriesBackend is implemented by the types that persist Series.
+type SeriesBackend interface {
+	// Get returns the Series with the ID, or ErrNoSession.
+	Get(r *http.Request, id string) (*Series, error)
+	Put(r *http.Request, s *Series) error
+	// Update replaces s if its stored TokenHash is still old,
+	// atomically, so that concurrent restores with the same token can't
+	// both rotate it. It returns ErrTokenReused if the TokenHash has
+	// changed and ErrNoSession if s was deleted.
+	Update(r *http.Request, s *Series, old []byte) error
+	Delete(r *http.Request, id string) error
+	// List returns the User's Series.
+	List(r *http.Request, userID string) ([]*Series, error)
+}
+
+// RememberStore wraps a Store and logs the User in again, from the
+// remember me cookie, when the wrapped session has expired. The session
+// cookie can therefore stay short lived.
+//
+//	session.Default = datastore.Compat{session.NewRegistry(
+//	  session.NewRememberStore(
+//	    session.NewServerStore("auth", datastore.Backend{}),
+//	    datastore.Series{}),
+//	  datastore.Records{})}
+//
+// A series is only issued by Remember, e.g. by auth.CreateAndLogin when
+// the login form asks for it. The Registry records the logins restored
+// from a Series, and revoking them revokes the Series.
+type RememberStore struct {
+	Store
+	Series SeriesBackend
+	// Name is the name of the remember me cookie.
+	Name string
+	// Options is the cookie policy. If nil DefaultOptions is used. The
+	// MaxAge of the cookie is always RememberMaxAge.
+	Options *Options
+}
+
+// NewRememberStore creates a RememberStore around s that saves its
+// Series to b.
+func NewRememberStore(s Store, b SeriesBackend) *RememberStore {
+	return &RememberStore{Store: s, Series: b, Name: "auth-remember"}
+}
+
+// options returns the cookie policy of the RememberStore.
+func (g *RememberStore) options() *Options {
+	o := *DefaultOptions
+	if g.Options != nil {
+		o = *g.Options
+	}
+	o.MaxAge = int(RememberMaxAge / time.Second)
+	return &o
+}
+
+// Unwrap returns the wrapped Store.
+func (g *RememberStore) Unwrap() Store {
+	return g.Store
+}
+
+// hashToken returns the hash of a remember me token.
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+// cookie returns the Series ID and token of the request's cookie.
+func (g *RememberStore) cookie(r *http.Request) (id, token string, err error) {
+	c, err := r.Cookie(g.Name)
+	if err != nil {
+		return "", "", ErrNoSession
+	}
+	p := strings.SplitN(c.Value, ":", 2)
+	if len(p) != 2 {
+		return "", "", ErrNoSession
+	}
+	return p[0], p[1], nil
+}
+
+// current returns the Series and token of the request's cookie.
+func (g *RememberStore) current(r *http.Request) (*Series, string, error) {
+	id, token, err := g.cookie(r)
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := g.Series.Get(r, id)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, token, nil
+}
+
+// rotate issues a new token for the Series and returns the value of its
+// cookie.
+func rotate(s *Series) string {
+	token := NewID()
+	s.PrevTokenHash = s.TokenHash
+	s.TokenHash = hashToken(token)
+	s.LastUsed = time.Now()
+	return s.ID + ":" + token
+}
+
+// outer returns the Store that the logins of g go through: Default if it
+// wraps g, so that the Stores around g, e.g. a Registry, see them.
+func (g *RememberStore) outer() Store {
+	if rs, err := rememberStore(); err == nil && rs == g {
+		return Default
+	}
+	return g
+}
+
+// link ties the Series to the Record of the request's session, if the
+// Default Store has a Registry, so that revoking the session revokes
+// the Series.
+func link(r *http.Request, s *Series) error {
+	g, err := registry()
+	if err != nil {
+		return nil
+	}
+	return g.link(r, s.ID)
+}
+
+// forget removes the request's Series and cookie.
+func (g *RememberStore) forget(w http.ResponseWriter, r *http.Request) error {
+	if s, _, err := g.current(r); err == nil {
+		if err = g.Series.Delete(r, s.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := r.Cookie(g.Name); err == nil {
+		setCookie(w, r, g.options().expired(g.Name))
+	}
+	return nil
+}
+
+// Remember issues a new Series for the User, replacing the request's
+// Series if any.
+func (g *RememberStore) Remember(w http.ResponseWriter, r *http.Request, userID, provider string) error {
+	if err := g.forget(w, r); err != nil {
+		return err
+	}
+	now := time.Now()
+	s := &Series{
+		ID:       NewID(),
+		UserID:   userID,
+		Provider: provider,
+		Created:  now,
+		Expires:  now.Add(RememberMaxAge),
+	}
+	v := rotate(s)
+	if err := g.Series.Put(r, s); err != nil {
+		return err
+	}
+	setCookie(w, r, g.options().cookies(g.Name, v))
+	return link(r, s)
+}
+
+// Restore logs in the User of the request's Series, through the Default
+// Store if it wraps g, and rotates its token. The previous token is
+// accepted within RememberGrace, without rotating it again. If the token
+// doesn't match, the Series is revoked and ErrTokenReused is returned.
+func (g *RememberStore) Restore(w http.ResponseWriter, r *http.Request) (string, error) {
+	s, token, err := g.current(r)
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(s.Expires) {
+		g.forget(w, r)
+		return "", ErrNoSession
+	}
+	h := hashToken(token)
+	switch {
+	case subtle.ConstantTimeCompare(h, s.TokenHash) == 1:
+		old := s.TokenHash
+		v := rotate(s)
+		if err = g.Series.Update(r, s, old); err == ErrTokenReused {
+			// A concurrent request rotated it; the browser gets its token.
+		} else if err != nil {
+			return "", err
+		} else {
+			setCookie(w, r, g.options().cookies(g.Name, v))
+		}
+	case subtle.ConstantTimeCompare(h, s.PrevTokenHash) == 1 &&
+		time.Since(s.LastUsed) < RememberGrace:
+		// A concurrent request; the browser has the new token.
+	default:
+		g.forget(w, r)
+		return "", ErrTokenReused
+	}
+	err = login(g.outer(), w, r, &LoginInfo{
+		UserID:   s.UserID,
+		Provider: s.Provider,
+		AuthTime: s.Created,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = link(r, s); err != nil {
+		return "", err
+	}
+	return s.UserID, nil
+}
+
+// revokeOthers deletes the User's Series except that of the request's
+// cookie.
+func (g *RememberStore) revokeOthers(r *http.Request, userID string) error {
+	ss, err := g.Series.List(r, userID)
+	if err != nil {
+		return err
+	}
+	cur, _, _ := g.cookie(r)
+	for _, s := range ss {
+		if s.ID == cur {
+			continue
+		}
+		if err = g.Series.Delete(r, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLogin logs in the User with the wrapped Store.
+func (g *RememberStore) SetLogin(w http.ResponseWriter, r *http.Request, l *LoginInfo) error {
+	return login(g.Store, w, r, l)
+}
+
+// LoginInfo returns the login of the wrapped Store.
+func (g *RememberStore) LoginInfo(r *http.Request) (*LoginInfo, error) {
+	return loginInfo(g.Store, r)
+}
+
+// Destroy logs out the User and revokes the request's Series.
+func (g *RememberStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if err := g.forget(w, r); err != nil {
+		return err
+	}
+	return g.Store.Destroy(w, r)
+}
+
+// rememberStore returns the RememberStore of the Default Store.
+func rememberStore() (*RememberStore, error) {
+	for s := Default; s != nil; {
+		if g, ok := s.(*RememberStore); ok {
+			return g, nil
+		}
+		u, ok := s.(interface {
+			Unwrap() Store
+		})
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+	return nil, ErrNoRememberStore
+}
+
+// Remember issues a remember me Series for the User with the
+// RememberStore of the Default Store. It returns ErrNoRememberStore if
+// there is none.
+func Remember(w http.ResponseWriter, r *http.Request, userID, provider string) error {
+	g, err := rememberStore()
+	if err != nil {
+		return err
+	}
+	return g.Remember(w, r, userID, provider)
+}
+
+// RememberHandler wraps h so that, when the session has expired, the
+// User is logged in again from the remember me cookie before h is called.
+// It does nothing if the Default Store has no RememberStore.
+//
+//	http.Handle("/", session.RememberHandler(mux))
+func RememberHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Default.UserID(r); err == user.ErrNoLoggedInUser {
+			if g, err := rememberStore(); err == nil {
+				g.Restore(w, r)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// MemorySeries keeps Series in memory. It is intended for tests and
+// development servers.
+type MemorySeries struct {
+	mu     sync.RWMutex
+	series map[string]Series
+}
+
+// NewMemorySeries creates an empty MemorySeries.
+func NewMemorySeries() *MemorySeries {
+	return &MemorySeries{series: make(map[string]Series)}
+}
+
+func (b *MemorySeries) Get(r *http.Request, id string) (*Series, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	s, ok := b.series[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return &s, nil
+}
+
+func (b *MemorySeries) Put(r *http.Request, s *Series) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.series[tenant.Key(r, s.ID)] = *s
+	return nil
+}
+
+func (b *MemorySeries) Update(r *http.Request, s *Series, old []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := tenant.Key(r, s.ID)
+	cur, ok := b.series[key]
+	if !ok {
+		return ErrNoSession
+	}
+	if !bytes.Equal(cur.TokenHash, old) {
+		return ErrTokenReused
+	}
+	b.series[key] = *s
+	return nil
+}
+
+func (b *MemorySeries) Delete(r *http.Request, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.series, tenant.Key(r, id))
+	return nil
+}
+
+func (b *MemorySeries) List(r *http.Request, userID string) ([]*Series, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var ss []*Series
+	for k, s := range b.series {
+		if s.UserID == userID && tenant.InNamespace(r, k) {
+			s := s
+			ss = append(ss, &s)
+		}
+	}
+	return ss, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"github.com/gaego/auth/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRememberStore(t *testing.T) {
+	testStore(t, NewRememberStore(NewCookieStore("auth", []byte("01234567890123456789012345678901")), NewMemorySeries()))
+}
+
+func TestRememberHandler(t *testing.T) {
+	g := NewRememberStore(NewServerStore("auth", NewMemoryBackend()), NewMemorySeries())
+	defer func(s Store) { Default = s }(Default)
+	Default = g
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	w := httptest.NewRecorder()
+	if err := Login(w, r, "1", "Google"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if err := Remember(w, r, "1", "Google"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	remember, _ := r.Cookie("auth-remember")
+
+	var id string
+	h := RememberHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ = Default.UserID(r)
+	}))
+
+	// The session has expired, only the remember me cookie is left.
+
+	restored := time.Now()
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r2.AddCookie(remember)
+	w2 := httptest.NewRecorder()
+	h.ServeHTTP(w2, r2)
+	if id != "1" {
+		t.Errorf(`id: %q, want "1"`, id)
+	}
+	// The restored login is not a fresh login.
+	if l, err := CurrentLogin(r2); err != nil || l.Provider != "Google" || !l.AuthTime.Before(restored) {
+		t.Errorf(`l: %+v, err: %v, want the Google login from before the restore`, l, err)
+	}
+	rotated, _ := r2.Cookie("auth-remember")
+	if rotated.Value == remember.Value {
+		t.Errorf(`token: %v, want a new token`, rotated.Value)
+	}
+
+	// A concurrent request sent the old token before the rotation.
+
+	r3, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r3.AddCookie(remember)
+	w3 := httptest.NewRecorder()
+	if id, err := g.Restore(w3, r3); id != "1" || err != nil {
+		t.Errorf(`id: %q, err: %v, want "1", nil`, id, err)
+	}
+	if c := w3.Header().Get("Set-Cookie"); strings.Contains(c, "auth-remember=") {
+		t.Errorf(`Set-Cookie: %v, want the token not to be rotated again`, c)
+	}
+
+	// The old token is presented again after RememberGrace, e.g. by a
+	// thief.
+
+	defer func(d time.Duration) { RememberGrace = d }(RememberGrace)
+	RememberGrace = 0
+	id = ""
+	r3, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	r3.AddCookie(remember)
+	if _, err := g.Restore(httptest.NewRecorder(), r3); err != ErrTokenReused {
+		t.Errorf(`err: %v, want %v`, err, ErrTokenReused)
+	}
+
+	// The whole series is revoked, including the rotated token.
+
+	r4, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r4.AddCookie(rotated)
+	h.ServeHTTP(httptest.NewRecorder(), r4)
+	if id != "" {
+		t.Errorf(`id: %q, want ""`, id)
+	}
+	if _, err := Default.UserID(r4); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+}
+
+func TestRemember_NotEnabled(t *testing.T) {
+	defer func(s Store) { Default = s }(Default)
+	Default = NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if err := Remember(httptest.NewRecorder(), r, "1", "Google"); err != ErrNoRememberStore {
+		t.Errorf(`err: %v, want %v`, err, ErrNoRememberStore)
+	}
+}
+
+func TestRememberStore_Registry(t *testing.T) {
+	series := NewMemorySeries()
+	g := NewRegistry(NewRememberStore(NewServerStore("auth", NewMemoryBackend()), series), NewMemoryRecords())
+	defer func(s Store) { Default = s }(Default)
+	Default = g
+
+	remember := func(userAgent string) *http.Cookie {
+		r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		if err := Login(w, r, "1", "Google"); err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		if err := Remember(w, r, "1", "Google"); err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		c, _ := r.Cookie("auth-remember")
+		return c
+	}
+	restore := func(c *http.Cookie) string {
+		var id string
+		r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		r.AddCookie(c)
+		RememberHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ = Default.UserID(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return id
+	}
+
+	// The restored login is recorded by the Registry around the
+	// RememberStore.
+
+	laptop := remember("laptop")
+	if id := restore(laptop); id != "1" {
+		t.Errorf(`id: %q, want "1"`, id)
+	}
+
+	// Revoking a session revokes the Series that logged it in.
+
+	tablet := remember("tablet")
+	phone, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if err := Login(httptest.NewRecorder(), phone, "1", "Password"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	recs, _ := g.List(phone)
+	for _, rec := range recs {
+		if rec.UserAgent != "tablet" {
+			continue
+		}
+		if rec.SeriesID == "" {
+			t.Errorf(`rec.SeriesID: "", want the Series of the tablet`)
+		}
+		if err := g.Revoke(phone, rec.ID); err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+	}
+	if id := restore(tablet); id != "" {
+		t.Errorf(`id: %q, want the tablet's Series revoked`, id)
+	}
+
+	// Signing out the other devices revokes all their Series.
+
+	if err := g.RevokeOthers(phone); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if ss, _ := series.List(phone, "1"); len(ss) != 0 {
+		t.Errorf(`len(ss): %v, want 0`, len(ss))
+	}
+	if id := restore(laptop); id != "" {
+		t.Errorf(`id: %q, want the laptop's Series revoked`, id)
+	}
+}
+
+func TestMemorySeries_Update(t *testing.T) {
+	b := NewMemorySeries()
+	s := &Series{ID: "1", UserID: "1", TokenHash: hashToken("a")}
+	b.Put(nil, s)
+	s2 := *s
+	s2.TokenHash = hashToken("b")
+	if err := b.Update(nil, &s2, hashToken("a")); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	// A concurrent restore with the same token loses.
+	s3 := *s
+	s3.TokenHash = hashToken("c")
+	if err := b.Update(nil, &s3, hashToken("a")); err != ErrTokenReused {
+		t.Errorf(`err: %v, want %v`, err, ErrTokenReused)
+	}
+	b.Delete(nil, "1")
+	if err := b.Update(nil, &s2, hashToken("b")); err != ErrNoSession {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSession)
+	}
+}
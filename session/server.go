@@ -0,0 +1,175 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/gaego/auth/tenant"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Backend is implemented by the types that persist server side
+// sessions.
+type Backend interface {
+	// Get returns the Data of the session, or ErrNoSession.
+	Get(r *http.Request, id string) (*Data, error)
+	Put(r *http.Request, id string, d *Data) error
+	Delete(r *http.Request, id string) error
+}
+
+// ServerStore keeps the session on the server. The cookie only holds a
+// random session ID, which is replaced on every login and role change to
+// prevent session fixation.
+type ServerStore struct {
+	Name    string
+	Backend Backend
+	// Options is the cookie policy. If nil DefaultOptions is used.
+	Options *Options
+}
+
+// NewServerStore creates a ServerStore that saves sessions to b.
+func NewServerStore(name string, b Backend) *ServerStore {
+	return &ServerStore{Name: name, Backend: b}
+}
+
+// options returns the cookie policy of the store.
+func (s *ServerStore) options() *Options {
+	if s.Options != nil {
+		return s.Options
+	}
+	return DefaultOptions
+}
+
+// NewID returns a new random session ID.
+func NewID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth/session: unable to generate a session ID: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// get returns the ID and Data of the request's session.
+func (s *ServerStore) get(r *http.Request) (string, *Data, error) {
+	c, err := r.Cookie(s.Name)
+	if err != nil || c.Value == "" {
+		return "", nil, ErrNoSession
+	}
+	d, err := s.Backend.Get(r, c.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	if !d.Expires.IsZero() && time.Now().After(d.Expires) {
+		s.Backend.Delete(r, c.Value)
+		return "", nil, ErrNoSession
+	}
+	return c.Value, d, nil
+}
+
+// save saves the Data under a new session ID and deletes the previous
+// session, if any.
+func (s *ServerStore) save(w http.ResponseWriter, r *http.Request, oldID string, d *Data) error {
+	id := NewID()
+	if err := s.Backend.Put(r, id, d); err != nil {
+		return err
+	}
+	if oldID != "" {
+		if err := s.Backend.Delete(r, oldID); err != nil {
+			return err
+		}
+	}
+	setCookie(w, r, s.options().cookies(s.Name, id))
+	return nil
+}
+
+// SetLogin starts a new session for the login; nothing is carried over
+// from the request's session.
+func (s *ServerStore) SetLogin(w http.ResponseWriter, r *http.Request, l *LoginInfo) error {
+	var oldID string
+	if c, err := r.Cookie(s.Name); err == nil {
+		oldID = c.Value
+	}
+	return s.save(w, r, oldID, newData(l, s.options()))
+}
+
+func (s *ServerStore) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return s.SetLogin(w, r, &LoginInfo{UserID: userID, AuthTime: time.Now()})
+}
+
+func (s *ServerStore) UserID(r *http.Request) (string, error) {
+	_, d, err := s.get(r)
+	if err != nil || d.UserID == "" {
+		return "", user.ErrNoLoggedInUser
+	}
+	return d.UserID, nil
+}
+
+func (s *ServerStore) LoginInfo(r *http.Request) (*LoginInfo, error) {
+	_, d, err := s.get(r)
+	if err != nil {
+		return nil, user.ErrNoLoggedInUser
+	}
+	return d.loginInfo()
+}
+
+func (s *ServerStore) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	id, d, err := s.get(r)
+	if err != nil {
+		return user.ErrNoLoggedInUser
+	}
+	d.setRole(role, value)
+	return s.save(w, r, id, d)
+}
+
+func (s *ServerStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if c, err := r.Cookie(s.Name); err == nil && c.Value != "" {
+		if err = s.Backend.Delete(r, c.Value); err != nil {
+			return err
+		}
+	}
+	setCookie(w, r, s.options().expired(s.Name))
+	return nil
+}
+
+// MemoryBackend keeps sessions in memory. It is intended for tests and
+// development servers.
+type MemoryBackend struct {
+	mu       sync.RWMutex
+	sessions map[string]Data
+}
+
+// NewMemoryBackend creates an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{sessions: make(map[string]Data)}
+}
+
+func (b *MemoryBackend) Get(r *http.Request, id string) (*Data, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	d, ok := b.sessions[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	d.Roles = append([]string(nil), d.Roles...)
+	return &d, nil
+}
+
+func (b *MemoryBackend) Put(r *http.Request, id string, d *Data) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions[tenant.Key(r, id)] = *d
+	return nil
+}
+
+func (b *MemoryBackend) Delete(r *http.Request, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, tenant.Key(r, id))
+	return nil
+}
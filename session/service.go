@@ -0,0 +1,84 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrNoRegistry = errors.New("auth/session: the session registry is not enabled")
+)
+
+type Service struct{}
+
+type Args struct {
+	ID string
+}
+
+type Reply struct {
+	Sessions []*Record
+}
+
+// registry returns the Registry of the Default Store.
+func registry() (*Registry, error) {
+	for s := Default; s != nil; {
+		if g, ok := s.(*Registry); ok {
+			return g, nil
+		}
+		u, ok := s.(interface {
+			Unwrap() Store
+		})
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+	return nil, ErrNoRegistry
+}
+
+// ListSessions returns the current User's sessions.
+func (s *Service) ListSessions(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	g, err := registry()
+	if err != nil {
+		return err
+	}
+	reply.Sessions, err = g.List(r)
+	return err
+}
+
+// RevokeSession logs out the current User's session with args.ID.
+func (s *Service) RevokeSession(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	g, err := registry()
+	if err != nil {
+		return err
+	}
+	if err = g.Revoke(r, args.ID); err != nil {
+		return err
+	}
+	reply.Sessions, err = g.List(r)
+	return err
+}
+
+// RevokeAllOtherSessions logs out all of the current User's sessions
+// except the one making the request.
+func (s *Service) RevokeAllOtherSessions(w http.ResponseWriter, r *http.Request,
+	args *Args, reply *Reply) (err error) {
+
+	g, err := registry()
+	if err != nil {
+		return err
+	}
+	if err = g.RevokeOthers(r); err != nil {
+		return err
+	}
+	reply.Sessions, err = g.List(r)
+	return err
+}
@@ -0,0 +1,312 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/session provides the session backends used to remember the
+logged in User between requests.
+
+The auth packages use Default. Importing auth/session/datastore sets it
+to keep the sessions in the datastore, give them a new ID on each login
+and role change, record every login in a Registry, and keep the
+gaego/user cookie in sync for the apps that still read it:
+
+  session.Default = datastore.Compat{session.NewRegistry(
+    session.NewServerStore("auth", datastore.Backend{}), datastore.Records{})}
+
+It may be replaced by any type that implements Store, e.g. to use a
+cookie signed with a secret key of at least 32 random bytes:
+
+  session.Default = session.NewCookieStore("auth", key)
+
+or the gaego/user cookie, which is neither rotated nor records the
+logins:
+
+  session.Default = datastore.UserStore{}
+
+A Store may be wrapped by a Registry, to list and revoke the sessions of
+a User, as the default is, or by a RememberStore, to log in the User
+again from a long lived remember me cookie with RememberHandler.
+*/
+package session
+
+import (
+	"errors"
+	"github.com/gaego/auth/user"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrNoSession   = errors.New("auth/session: no such session")
+	ErrNoStore     = errors.New("auth/session: no Store is set, import github.com/gaego/auth/appengine or set Default")
+	ErrNoUserStore = errors.New("auth/session: no UserStore is set, import github.com/gaego/auth/profile")
+)
+
+// Store is the interface implemented by session backends.
+type Store interface {
+	// SetUserID logs in the User with the ID.
+	SetUserID(w http.ResponseWriter, r *http.Request, userID string) error
+	// UserID returns the ID of the logged in User, or
+	// user.ErrNoLoggedInUser.
+	UserID(r *http.Request) (string, error)
+	// SetRole adds, or if value is false removes, a role from the
+	// session.
+	SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error
+	// Destroy logs out the User.
+	Destroy(w http.ResponseWriter, r *http.Request) error
+}
+
+// Default is the Store used by the auth packages. Until it is set, e.g.
+// by importing auth/session/datastore, logins fail with ErrNoStore.
+var Default Store = noStore{}
+
+// noStore is the Default Store until one is set.
+type noStore struct{}
+
+func (noStore) SetUserID(w http.ResponseWriter, r *http.Request, userID string) error {
+	return ErrNoStore
+}
+
+func (noStore) UserID(r *http.Request) (string, error) {
+	return "", ErrNoStore
+}
+
+func (noStore) SetRole(w http.ResponseWriter, r *http.Request, role string, value bool) error {
+	return ErrNoStore
+}
+
+func (noStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	return ErrNoStore
+}
+
+// LoginInfo describes how the User of a session logged in.
+type LoginInfo struct {
+	UserID string
+	// Provider is the name of the provider that authenticated the User,
+	// e.g. "Google".
+	Provider string
+	// AuthTime is when the provider authenticated the User. A login
+	// restored from a remember me cookie keeps the time of the original
+	// login.
+	AuthTime time.Time
+}
+
+// loginSetter is implemented by Stores that record the provider and the
+// time of the login.
+type loginSetter interface {
+	SetLogin(w http.ResponseWriter, r *http.Request, l *LoginInfo) error
+}
+
+// loginGetter is implemented by Stores that return the recorded login.
+type loginGetter interface {
+	LoginInfo(r *http.Request) (*LoginInfo, error)
+}
+
+// Login logs in the User with the Default Store. provider is the name of
+// the provider that authenticated the User, e.g. "Google".
+func Login(w http.ResponseWriter, r *http.Request, userID, provider string) error {
+	return login(Default, w, r, &LoginInfo{
+		UserID:   userID,
+		Provider: provider,
+		AuthTime: time.Now(),
+	})
+}
+
+func login(s Store, w http.ResponseWriter, r *http.Request, l *LoginInfo) error {
+	if ls, ok := s.(loginSetter); ok {
+		return ls.SetLogin(w, r, l)
+	}
+	return s.SetUserID(w, r, l.UserID)
+}
+
+// CurrentLogin returns how the User of the Default Store's session
+// logged in. Only the UserID is set if the Store doesn't record logins,
+// e.g. the gaego/user cookie of auth/session/datastore's UserStore.
+func CurrentLogin(r *http.Request) (*LoginInfo, error) {
+	return loginInfo(Default, r)
+}
+
+func loginInfo(s Store, r *http.Request) (*LoginInfo, error) {
+	if lg, ok := s.(loginGetter); ok {
+		return lg.LoginInfo(r)
+	}
+	id, err := s.UserID(r)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginInfo{UserID: id}, nil
+}
+
+// GetUser returns the User with the ID for Current. auth/profile sets it
+// to the Get of its DefaultUserStore.
+var GetUser = func(r *http.Request, id string) (*user.User, error) {
+	return nil, ErrNoUserStore
+}
+
+// Current returns the logged in User.
+func Current(r *http.Request) (*user.User, error) {
+	id, err := Default.UserID(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(r, id)
+}
+
+// Data is the content of a session.
+type Data struct {
+	UserID   string
+	Roles    []string
+	Provider string
+	AuthTime time.Time
+	Created  time.Time
+	Expires  time.Time
+}
+
+// newData returns the Data of a new session of the login.
+func newData(l *LoginInfo, o *Options) *Data {
+	now := time.Now()
+	d := &Data{
+		UserID:   l.UserID,
+		Provider: l.Provider,
+		AuthTime: l.AuthTime,
+		Created:  now,
+	}
+	if o.MaxAge > 0 {
+		d.Expires = now.Add(time.Duration(o.MaxAge) * time.Second)
+	}
+	return d
+}
+
+// loginInfo returns the login of the session.
+func (d *Data) loginInfo() (*LoginInfo, error) {
+	if d.UserID == "" {
+		return nil, user.ErrNoLoggedInUser
+	}
+	return &LoginInfo{UserID: d.UserID, Provider: d.Provider, AuthTime: d.AuthTime}, nil
+}
+
+// setRole adds or removes role from the Data.
+func (d *Data) setRole(role string, value bool) {
+	for i, v := range d.Roles {
+		if v == role {
+			if !value {
+				d.Roles = append(d.Roles[:i], d.Roles[i+1:]...)
+			}
+			return
+		}
+	}
+	if value {
+		d.Roles = append(d.Roles, role)
+	}
+}
+
+// HasRole reports whether the session has the role.
+func (d *Data) HasRole(role string) bool {
+	for _, v := range d.Roles {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Options are the attributes of the session cookies. They are shared by
+// all of the stores unless a store sets its own.
+type Options struct {
+	Path     string
+	Domain   string
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	// CrossSitePaths are the paths, e.g. "/-/auth/apple/callback", that
+	// receive cross-site POSTs from providers. A SameSite=None copy of
+	// the cookie is scoped to each of them so that the session is sent
+	// with the POST. auth.Register adds the callbacks of providers that
+	// need it.
+	CrossSitePaths []string
+}
+
+// DefaultOptions is the cookie policy used by the stores that don't set
+// their own Options. Secure must be set to false to use the development
+// server over http.
+var DefaultOptions = &Options{
+	Path:     "/",
+	MaxAge:   86400 * 30,
+	Secure:   true,
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode,
+}
+
+// AddCrossSitePath adds path to the CrossSitePaths of the
+// DefaultOptions.
+func AddCrossSitePath(path string) {
+	for _, p := range DefaultOptions.CrossSitePaths {
+		if p == path {
+			return
+		}
+	}
+	DefaultOptions.CrossSitePaths = append(DefaultOptions.CrossSitePaths, path)
+}
+
+// cookie creates a cookie with the options.
+func (o *Options) cookie(name, value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   o.Secure,
+		HttpOnly: o.HttpOnly,
+		SameSite: o.SameSite,
+	}
+	if o.MaxAge > 0 {
+		c.Expires = time.Now().Add(time.Duration(o.MaxAge) * time.Second)
+	} else if o.MaxAge < 0 {
+		c.Expires = time.Unix(1, 0)
+	}
+	return c
+}
+
+// cookies returns the session cookie followed by a copy for each of the
+// CrossSitePaths. Browsers only accept SameSite=None when it is Secure.
+func (o *Options) cookies(name, value string) []*http.Cookie {
+	c := o.cookie(name, value)
+	cs := []*http.Cookie{c}
+	for _, p := range o.CrossSitePaths {
+		cc := *c
+		cc.Path = p
+		cc.SameSite = http.SameSiteNoneMode
+		cc.Secure = true
+		cs = append(cs, &cc)
+	}
+	return cs
+}
+
+// expired returns the cookies that remove the session cookies.
+func (o *Options) expired(name string) []*http.Cookie {
+	eo := *o
+	eo.MaxAge = -1
+	return eo.cookies(name, "")
+}
+
+// setCookie writes the cookies to the response and replaces the first
+// in the request, so that it is seen by the rest of the request.
+func setCookie(w http.ResponseWriter, r *http.Request, cs []*http.Cookie) {
+	for _, c := range cs {
+		http.SetCookie(w, c)
+	}
+	c := cs[0]
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, v := range cookies {
+		if v.Name != c.Name {
+			r.AddCookie(v)
+		}
+	}
+	if c.MaxAge >= 0 {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"github.com/gaego/auth/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testStore runs the same checks against each Store implementation.
+func testStore(t *testing.T, s Store) {
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	w := httptest.NewRecorder()
+
+	// No session.
+
+	if _, err := s.UserID(r); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+	if err := s.SetRole(w, r, "admin", true); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+
+	// Login.
+
+	if err := s.SetUserID(w, r, "1"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if id, err := s.UserID(r); id != "1" || err != nil {
+		t.Errorf(`id: %v, want "1"`, id)
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if err := s.SetRole(w, r, "admin", true); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+
+	// A new request with the response's cookie.
+
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	for _, c := range readCookies(w) {
+		r2.AddCookie(c)
+	}
+	if id, err := s.UserID(r2); id != "1" || err != nil {
+		t.Errorf(`id: %v, want "1"`, id)
+		t.Errorf(`err: %v, want nil`, err)
+	}
+
+	// Logout.
+
+	w = httptest.NewRecorder()
+	if err := s.Destroy(w, r2); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if _, err := s.UserID(r2); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+}
+
+// readCookies returns the last value of each cookie set on w.
+func readCookies(w *httptest.ResponseRecorder) []*http.Cookie {
+	res := http.Response{Header: w.Header()}
+	m := make(map[string]*http.Cookie)
+	var names []string
+	for _, c := range res.Cookies() {
+		if _, ok := m[c.Name]; !ok {
+			names = append(names, c.Name)
+		}
+		m[c.Name] = c
+	}
+	cookies := make([]*http.Cookie, len(names))
+	for i, n := range names {
+		cookies[i] = m[n]
+	}
+	return cookies
+}
+
+func TestCookieStore(t *testing.T) {
+	testStore(t, NewCookieStore("auth", []byte("01234567890123456789012345678901")))
+}
+
+func TestNewCookieStore_ShortKey(t *testing.T) {
+	defer func() {
+		if x := recover(); x != ErrShortKey {
+			t.Errorf(`recover: %v, want %v`, x, ErrShortKey)
+		}
+	}()
+	NewCookieStore("auth", []byte("secret"))
+}
+
+func TestCookieStore_Tampered(t *testing.T) {
+	s := NewCookieStore("auth", []byte("01234567890123456789012345678901"))
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	w := httptest.NewRecorder()
+	s.SetUserID(w, r, "1")
+
+	// Sign with another key.
+
+	s2 := NewCookieStore("auth", []byte("abcdefghijklmnopqrstuvwxyz012345"))
+	v, _ := s2.encode(&Data{UserID: "2"})
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r2.AddCookie(&http.Cookie{Name: "auth", Value: v})
+	if _, err := s.UserID(r2); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+}
+
+func TestServerStore(t *testing.T) {
+	testStore(t, NewServerStore("auth", NewMemoryBackend()))
+}
+
+func TestCurrentLogin(t *testing.T) {
+	defer func(s Store) { Default = s }(Default)
+	for _, s := range []Store{
+		NewCookieStore("auth", []byte("01234567890123456789012345678901")),
+		NewServerStore("auth", NewMemoryBackend()),
+		NewRegistry(NewCookieStore("auth", []byte("01234567890123456789012345678901")), NewMemoryRecords()),
+	} {
+		Default = s
+		r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		if _, err := CurrentLogin(r); err != user.ErrNoLoggedInUser {
+			t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+		}
+		before := time.Now()
+		Login(httptest.NewRecorder(), r, "1", "Google")
+		l, err := CurrentLogin(r)
+		if err != nil {
+			t.Fatalf(`err: %v, want nil`, err)
+		}
+		if l.UserID != "1" || l.Provider != "Google" || l.AuthTime.Before(before) {
+			t.Errorf(`l: %+v, want the Google login of User 1`, l)
+		}
+	}
+}
+
+func TestData_setRole(t *testing.T) {
+	d := &Data{}
+	d.setRole("admin", true)
+	d.setRole("admin", true)
+	if len(d.Roles) != 1 || !d.HasRole("admin") {
+		t.Errorf(`d.Roles: %v, want [admin]`, d.Roles)
+	}
+	d.setRole("admin", false)
+	if d.HasRole("admin") {
+		t.Errorf(`d.Roles: %v, want []`, d.Roles)
+	}
+}
+
+func TestServerStore_Rotate(t *testing.T) {
+	b := NewMemoryBackend()
+	s := NewServerStore("auth", b)
+	s.Options = &Options{Path: "/", MaxAge: 3600, CrossSitePaths: []string{"/-/auth/apple/callback"}}
+
+	// A session planted by an attacker.
+
+	b.Put(nil, "fixed", &Data{UserID: "2"})
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r.AddCookie(&http.Cookie{Name: "auth", Value: "fixed"})
+
+	w := httptest.NewRecorder()
+	if err := s.SetUserID(w, r, "1"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	c, _ := r.Cookie("auth")
+	if c.Value == "fixed" {
+		t.Errorf(`id: %v, want a new id`, c.Value)
+	}
+	if _, err := b.Get(nil, "fixed"); err == nil {
+		t.Errorf(`err: nil, want the old session to be deleted`)
+	}
+
+	// A role change moves the session too.
+
+	old := c.Value
+	w = httptest.NewRecorder()
+	if err := s.SetRole(w, r, "admin", true); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	c, _ = r.Cookie("auth")
+	if c.Value == old {
+		t.Errorf(`id: %v, want a new id`, c.Value)
+	}
+	// The copy of the cross-site path is moved with it.
+	cs := w.Result().Cookies()
+	if len(cs) != 2 {
+		t.Fatalf(`len(cs): %v, want 2`, len(cs))
+	}
+	for _, sc := range cs {
+		if sc.Value != c.Value {
+			t.Errorf(`%v: %v, want %v`, sc.Path, sc.Value, c.Value)
+		}
+	}
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	r2.AddCookie(&http.Cookie{Name: "auth", Value: old})
+	if _, err := s.UserID(r2); err != user.ErrNoLoggedInUser {
+		t.Errorf(`err: %v, want %v`, err, user.ErrNoLoggedInUser)
+	}
+	if id, err := s.UserID(r); id != "1" || err != nil {
+		t.Errorf(`id: %v, want "1"`, id)
+		t.Errorf(`err: %v, want nil`, err)
+	}
+}
+
+func TestOptions_cookies(t *testing.T) {
+	o := &Options{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode,
+		CrossSitePaths: []string{"/-/auth/apple/callback"}}
+	cs := o.cookies("auth", "1")
+	if len(cs) != 2 {
+		t.Fatalf(`len(cs): %v, want 2`, len(cs))
+	}
+	if cs[0].Path != "/" || cs[0].SameSite != http.SameSiteLaxMode || cs[0].Secure {
+		t.Errorf(`cs[0]: %v, want Path=/ SameSite=Lax`, cs[0])
+	}
+	c := cs[1]
+	if c.Path != "/-/auth/apple/callback" || c.SameSite != http.SameSiteNoneMode || !c.Secure {
+		t.Errorf(`cs[1]: %v, want Path=/-/auth/apple/callback SameSite=None Secure`, c)
+	}
+	for _, c := range o.expired("auth") {
+		if c.MaxAge >= 0 {
+			t.Errorf(`c.MaxAge: %v, want < 0`, c.MaxAge)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	// Without a Store the logins fail instead of being lost.
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if err := Login(httptest.NewRecorder(), r, "1", "Google"); err != ErrNoStore {
+		t.Errorf(`err: %v, want %v`, err, ErrNoStore)
+	}
+}
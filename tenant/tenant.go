@@ -0,0 +1,169 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/tenant serves several tenants, e.g. customer-branded
+hostnames, from one app. Each Tenant has its own providers, registered
+with auth.RegisterTenant, its own URLs and its own datastore namespace
+for the Profiles, Users, sessions and other entities of the auth
+packages. The Tenant of a request is chosen by Resolver:
+
+  acme := &tenant.Tenant{Name: "acme", SuccessURL: "/dashboard"}
+  auth.RegisterTenant(acme, "google", google.New(acmeID, acmeSecret, "openid email"))
+  tenant.Resolver = tenant.ByHost(map[string]*tenant.Tenant{
+    "login.acme.com": acme,
+  })
+
+Requests without a Tenant use the providers of auth.Register, the URLs
+of auth and the default namespace. Stores that don't use the datastore,
+e.g. profile.SQLStore and the Memory stores, keep the entities of each
+namespace apart with Key.
+*/
+package tenant
+
+import (
+	"context"
+	"errors"
+	"github.com/gaego/auth/origin"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrMissingName      = errors.New("auth/tenant: a name is required")
+	ErrInvalidNamespace = errors.New("auth/tenant: the namespace may only contain letters, digits, '.', '-' and '_'")
+)
+
+// Tenant is a tenant of the app.
+type Tenant struct {
+	// Name identifies the Tenant.
+	Name string
+	// Namespace is the datastore namespace of the Tenant's entities. It
+	// defaults to the Name.
+	Namespace string
+	// LoginURL and SuccessURL replace those of auth unless they are
+	// empty.
+	LoginURL   string
+	SuccessURL string
+}
+
+// validNamespace matches the valid datastore namespaces.
+var validNamespace = regexp.MustCompile(`^[0-9A-Za-z._-]{0,100}$`)
+
+// Validate returns ErrMissingName or ErrInvalidNamespace.
+func (t *Tenant) Validate() error {
+	if t.Name == "" {
+		return ErrMissingName
+	}
+	if !validNamespace.MatchString(t.namespace()) {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+// namespace returns the datastore namespace of the Tenant.
+func (t *Tenant) namespace() string {
+	if t.Namespace != "" {
+		return t.Namespace
+	}
+	return t.Name
+}
+
+// Resolver returns the Tenant of a request, or nil for the default
+// configuration. If nil, no request has a Tenant. It may only be set
+// before the requests are served; use SetResolver to change it
+// afterwards.
+var Resolver func(r *http.Request) *Tenant
+
+// resolver holds the *resolverFunc of the last SetResolver.
+var resolver atomic.Value
+
+type resolverFunc struct {
+	f func(r *http.Request) *Tenant
+}
+
+// SetResolver replaces Resolver, e.g. when auth/config reloads its
+// configuration while requests are served. Once it is called Resolver
+// is no longer read.
+func SetResolver(f func(r *http.Request) *Tenant) {
+	resolver.Store(&resolverFunc{f})
+}
+
+// currentResolver returns the Resolver of the last SetResolver, or else
+// Resolver.
+func currentResolver() func(r *http.Request) *Tenant {
+	if rf, ok := resolver.Load().(*resolverFunc); ok {
+		return rf.f
+	}
+	return Resolver
+}
+
+// ByHost returns a Resolver that chooses the Tenant by the request's
+// public host, without the port, e.g. "login.acme.com". The host is that
+// of the proxy's headers if origin.TrustProxy is set.
+func ByHost(tenants map[string]*Tenant) func(r *http.Request) *Tenant {
+	m := make(map[string]*Tenant, len(tenants))
+	for h, t := range tenants {
+		m[strings.ToLower(h)] = t
+	}
+	return func(r *http.Request) *Tenant {
+		host := origin.Host(r)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return m[strings.ToLower(host)]
+	}
+}
+
+type contextKey int
+
+const tenantKey contextKey = 0
+
+// WithTenant returns a copy of r whose Tenant is t, e.g. for a task
+// that acts for a Tenant outside of its hosts.
+func WithTenant(r *http.Request, t *Tenant) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantKey, t))
+}
+
+// Current returns the Tenant of the request, or nil.
+func Current(r *http.Request) *Tenant {
+	if r == nil {
+		return nil
+	}
+	if t, ok := r.Context().Value(tenantKey).(*Tenant); ok {
+		return t
+	}
+	resolve := currentResolver()
+	if resolve == nil {
+		return nil
+	}
+	return resolve(r)
+}
+
+// Namespace returns the datastore namespace of the request's Tenant, or
+// "" for the default namespace. The datastore implementations of the
+// stores use it through auth/namespace.
+func Namespace(r *http.Request) string {
+	if t := Current(r); t != nil {
+		return t.namespace()
+	}
+	return ""
+}
+
+// Key returns the ID qualified by the namespace of the request's Tenant,
+// e.g. "acme/google|12345", for the stores that keep the entities of all
+// the Tenants together. Namespaces contain no '/', so the keys of two
+// namespaces never collide.
+func Key(r *http.Request, id string) string {
+	return Namespace(r) + "/" + id
+}
+
+// InNamespace reports whether key, returned by Key, is in the namespace
+// of the request's Tenant.
+func InNamespace(r *http.Request, key string) bool {
+	return strings.HasPrefix(key, Namespace(r)+"/")
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tenant
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCurrent(t *testing.T) {
+	acme := &Tenant{Name: "acme"}
+	Resolver = ByHost(map[string]*Tenant{"Login.Acme.com": acme})
+	defer func() { Resolver = nil }()
+
+	for _, tt := range []struct {
+		url  string
+		want *Tenant
+	}{
+		{"http://login.acme.com/-/auth/google", acme},
+		{"http://login.acme.com:8080/-/auth/google", acme},
+		{"http://example.com/-/auth/google", nil},
+	} {
+		r, _ := http.NewRequest("GET", tt.url, nil)
+		if x := Current(r); x != tt.want {
+			t.Errorf(`%v: Current: %v, want %v`, tt.url, x, tt.want)
+		}
+	}
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if x := Current(WithTenant(r, acme)); x != acme {
+		t.Errorf(`Current: %v, want %v`, x, acme)
+	}
+	if x := Current(nil); x != nil {
+		t.Errorf(`Current(nil): %v, want nil`, x)
+	}
+}
+
+func TestKey(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	acme := WithTenant(r, &Tenant{Name: "acme"})
+	if x := Key(acme, "google|12345"); x != "acme/google|12345" {
+		t.Errorf(`Key: %v, want "acme/google|12345"`, x)
+	}
+	if x := Key(nil, "google|12345"); x != "/google|12345" {
+		t.Errorf(`Key(nil): %v, want "/google|12345"`, x)
+	}
+	if !InNamespace(acme, Key(acme, "1")) {
+		t.Errorf(`InNamespace: false, want true`)
+	}
+	if InNamespace(r, Key(acme, "1")) {
+		t.Errorf(`InNamespace: true, want false`)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		t   *Tenant
+		err error
+	}{
+		{&Tenant{Name: "acme"}, nil},
+		{&Tenant{Name: "Acme Corp", Namespace: "acme-corp"}, nil},
+		{&Tenant{Name: "Acme Corp"}, ErrInvalidNamespace},
+		{&Tenant{Namespace: "acme"}, ErrMissingName},
+	} {
+		if err := tt.t.Validate(); err != tt.err {
+			t.Errorf(`%+v: err: %v, want %v`, tt.t, err, tt.err)
+		}
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	defer func() { resolver = atomic.Value{} }()
+	acme := &Tenant{Name: "acme"}
+	Resolver = ByHost(map[string]*Tenant{"login.acme.com": acme})
+	defer func() { Resolver = nil }()
+
+	r, _ := http.NewRequest("GET", "http://login.acme.com/", nil)
+	SetResolver(nil)
+	if x := Current(r); x != nil {
+		t.Errorf(`Current: %v, want nil`, x)
+	}
+	SetResolver(ByHost(map[string]*Tenant{"login.acme.com": acme}))
+	if x := Current(r); x != acme {
+		t.Errorf(`Current: %v, want %v`, x, acme)
+	}
+}
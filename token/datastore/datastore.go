@@ -0,0 +1,107 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/token/datastore saves the signing keys and refresh tokens of
+auth/token to the App Engine datastore, as "AuthSigningKey" and
+"AuthRefreshToken" entities. It is imported for its side effect:
+
+  import _ "github.com/gaego/auth/token/datastore"
+*/
+package datastore
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"bytes"
+	"github.com/gaego/auth/namespace"
+	"github.com/gaego/auth/token"
+	"net/http"
+)
+
+func init() {
+	token.DefaultKeyStore = KeyStore{}
+	token.DefaultRefreshStore = RefreshStore{}
+}
+
+// KeyStore saves SigningKeys to the App Engine datastore as
+// "AuthSigningKey" entities.
+type KeyStore struct{}
+
+func (KeyStore) List(r *http.Request) ([]*token.SigningKey, error) {
+	c := namespace.NewContext(r)
+	var ks []*token.SigningKey
+	keys, err := datastore.NewQuery("AuthSigningKey").GetAll(c, &ks)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		ks[i].ID = k.StringID()
+	}
+	return ks, nil
+}
+
+func (KeyStore) Put(r *http.Request, k *token.SigningKey) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthSigningKey", k.ID, 0, nil), k)
+	return err
+}
+
+func (KeyStore) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthSigningKey", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+// RefreshStore saves RefreshTokens to the App Engine datastore
+// as "AuthRefreshToken" entities.
+type RefreshStore struct{}
+
+func (RefreshStore) Get(r *http.Request, id string) (*token.RefreshToken, error) {
+	c := namespace.NewContext(r)
+	t := new(token.RefreshToken)
+	err := datastore.Get(c, datastore.NewKey(c, "AuthRefreshToken", id, 0, nil), t)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, token.ErrInvalidToken
+	}
+	t.ID = id
+	return t, err
+}
+
+func (RefreshStore) Put(r *http.Request, t *token.RefreshToken) error {
+	c := namespace.NewContext(r)
+	_, err := datastore.Put(c, datastore.NewKey(c, "AuthRefreshToken", t.ID, 0, nil), t)
+	return err
+}
+
+func (RefreshStore) Update(r *http.Request, t *token.RefreshToken, old []byte) error {
+	c := namespace.NewContext(r)
+	key := datastore.NewKey(c, "AuthRefreshToken", t.ID, 0, nil)
+	return datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		cur := new(token.RefreshToken)
+		err := datastore.Get(tc, key, cur)
+		if err == datastore.ErrNoSuchEntity {
+			return token.ErrInvalidToken
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(cur.Hash, old) {
+			return token.ErrTokenReused
+		}
+		_, err = datastore.Put(tc, key, t)
+		return err
+	}, nil)
+}
+
+func (RefreshStore) Delete(r *http.Request, id string) error {
+	c := namespace.NewContext(r)
+	err := datastore.Delete(c, datastore.NewKey(c, "AuthRefreshToken", id, 0, nil))
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
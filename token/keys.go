@@ -0,0 +1,262 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/tenant"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// RotationInterval is the age after which the signing key is
+	// replaced. The previous keys are published until the access tokens
+	// they signed have expired.
+	RotationInterval = 30 * 24 * time.Hour
+	// KeyCacheExpiration is how long the signing keys are cached by an
+	// instance before they are read again from the KeyStore.
+	KeyCacheExpiration = 10 * time.Minute
+)
+
+// SigningKey is an ES256 key that signs access tokens.
+type SigningKey struct {
+	ID string `datastore:"-"`
+	// DER is the PKCS#8 encoding of the private key.
+	DER     []byte `datastore:",noindex"`
+	Created time.Time
+	key     *ecdsa.PrivateKey
+}
+
+// NewSigningKey generates a SigningKey.
+func NewSigningKey() (*SigningKey, error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: newID(8), DER: der, Created: time.Now(), key: k}, nil
+}
+
+// PrivateKey returns the decoded private key.
+func (k *SigningKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	if k.key != nil {
+		return k.key, nil
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(k.DER)
+	if err != nil {
+		return nil, err
+	}
+	ek, ok := pk.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, jwt.ErrUnsupportedKeyType
+	}
+	k.key = ek
+	return ek, nil
+}
+
+// KeyStore is implemented by the types that persist SigningKeys. The
+// keys must be shared by all instances of the app.
+type KeyStore interface {
+	List(r *http.Request) ([]*SigningKey, error)
+	Put(r *http.Request, k *SigningKey) error
+	Delete(r *http.Request, id string) error
+}
+
+// DefaultKeyStore is the KeyStore of the signing keys. Until it is set,
+// e.g. by importing auth/token/datastore, it returns ErrNoStore.
+var DefaultKeyStore KeyStore = noKeyStore{}
+
+// noKeyStore is the DefaultKeyStore until one is set.
+type noKeyStore struct{}
+
+func (noKeyStore) List(r *http.Request) ([]*SigningKey, error) {
+	return nil, ErrNoStore
+}
+
+func (noKeyStore) Put(r *http.Request, k *SigningKey) error {
+	return ErrNoStore
+}
+
+func (noKeyStore) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+// keyCache holds the signing keys of a datastore namespace, newest
+// first.
+type keyCache struct {
+	keys   []*SigningKey
+	loaded time.Time
+}
+
+// cache holds the signing keys of the instance by the namespace of the
+// Tenant, see tenant.Namespace, so that each Tenant signs and verifies
+// with its own keys.
+var cache struct {
+	sync.Mutex
+	namespaces map[string]*keyCache
+}
+
+// resetCache makes the next requests read the keys from the KeyStore.
+func resetCache() {
+	cache.Lock()
+	cache.namespaces = nil
+	cache.Unlock()
+}
+
+// keyReloadInterval is the least time between the reloads of the keys
+// of a namespace for tokens with an unknown key ID.
+const keyReloadInterval = time.Second
+
+// expireCache makes the next request of the namespace of r read the keys
+// from the KeyStore, e.g. for a key that another instance created. It
+// reports false if the keys were read within keyReloadInterval.
+func expireCache(r *http.Request) bool {
+	cache.Lock()
+	defer cache.Unlock()
+	ns := tenant.Namespace(r)
+	if c := cache.namespaces[ns]; c != nil && time.Since(c.loaded) < keyReloadInterval {
+		return false
+	}
+	delete(cache.namespaces, ns)
+	return true
+}
+
+type byCreated []*SigningKey
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+// signingKeys returns the signing keys, newest first. A new key is
+// created when the newest is older than RotationInterval, and keys that
+// can no longer have valid tokens are deleted.
+func signingKeys(r *http.Request) ([]*SigningKey, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	now := time.Now()
+	ns := tenant.Namespace(r)
+	c := cache.namespaces[ns]
+	if c != nil && len(c.keys) > 0 && now.Sub(c.loaded) < KeyCacheExpiration &&
+		now.Sub(c.keys[0].Created) < RotationInterval {
+		return c.keys, nil
+	}
+	ks, err := DefaultKeyStore.List(r)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byCreated(ks))
+	if len(ks) == 0 || now.Sub(ks[0].Created) >= RotationInterval {
+		k, err := NewSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		if err = DefaultKeyStore.Put(r, k); err != nil {
+			return nil, err
+		}
+		ks = append([]*SigningKey{k}, ks...)
+	}
+	// A key stopped signing when the next key was created.
+	for i := 1; i < len(ks); i++ {
+		if now.Sub(ks[i-1].Created) > AccessTokenExpiration+jwt.Leeway {
+			for _, k := range ks[i:] {
+				if err = DefaultKeyStore.Delete(r, k.ID); err != nil {
+					return nil, err
+				}
+			}
+			ks = ks[:i]
+			break
+		}
+	}
+	if cache.namespaces == nil {
+		cache.namespaces = make(map[string]*keyCache)
+	}
+	cache.namespaces[ns] = &keyCache{keys: ks, loaded: now}
+	return ks, nil
+}
+
+// Rotate replaces the signing key now. Tokens signed with the previous
+// keys stay valid until they expire; to invalidate them, e.g. after a
+// key was leaked, delete the key from the KeyStore.
+func Rotate(r *http.Request) error {
+	k, err := NewSigningKey()
+	if err != nil {
+		return err
+	}
+	if err = DefaultKeyStore.Put(r, k); err != nil {
+		return err
+	}
+	resetCache()
+	return nil
+}
+
+// KeySet returns the public keys that verify the access tokens.
+func KeySet(r *http.Request) (*jwt.KeySet, error) {
+	ks, err := signingKeys(r)
+	if err != nil {
+		return nil, err
+	}
+	set := &jwt.KeySet{}
+	for _, k := range ks {
+		pk, err := k.PrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		jk, err := jwt.NewKey(k.ID, &pk.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jk)
+	}
+	return set, nil
+}
+
+// MemoryKeyStore keeps SigningKeys in memory. It is intended for tests
+// and single instance development servers.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]SigningKey
+}
+
+// NewMemoryKeyStore creates an empty MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]SigningKey)}
+}
+
+func (s *MemoryKeyStore) List(r *http.Request) ([]*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ks []*SigningKey
+	for id, k := range s.keys {
+		if tenant.InNamespace(r, id) {
+			k := k
+			ks = append(ks, &k)
+		}
+	}
+	return ks, nil
+}
+
+func (s *MemoryKeyStore) Put(r *http.Request, k *SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[tenant.Key(r, k.ID)] = *k
+	return nil
+}
+
+func (s *MemoryKeyStore) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, tenant.Key(r, id))
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/tenant"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenReused = errors.New("auth/token: refresh token reused, the login has been revoked")
+	ErrNoStore     = errors.New("auth/token: no store is set, import github.com/gaego/auth/appengine or set DefaultKeyStore and DefaultRefreshStore")
+)
+
+// RefreshTokenExpiration is the lifetime of a login's refresh tokens.
+// It is not extended by refreshing.
+var RefreshTokenExpiration = 30 * 24 * time.Hour
+
+// refreshPrefix starts every refresh token.
+const refreshPrefix = "grt_"
+
+// RefreshToken is the state of the refresh tokens of one login. Each
+// refresh replaces the secret, so a request with a previous secret means
+// that a token was stolen.
+type RefreshToken struct {
+	ID       string `datastore:"-"`
+	UserID   string
+	Provider string
+	// Hash is the SHA-256 hash of the current secret.
+	Hash     []byte `datastore:",noindex"`
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// RefreshStore is implemented by the types that persist RefreshTokens.
+type RefreshStore interface {
+	// Get returns the RefreshToken with the ID, or ErrInvalidToken.
+	Get(r *http.Request, id string) (*RefreshToken, error)
+	Put(r *http.Request, t *RefreshToken) error
+	// Update replaces t if its stored Hash is still old, atomically, so
+	// that concurrent refreshes with the same secret can't both succeed.
+	// It returns ErrTokenReused if the Hash has changed and
+	// ErrInvalidToken if t was deleted.
+	Update(r *http.Request, t *RefreshToken, old []byte) error
+	Delete(r *http.Request, id string) error
+}
+
+// DefaultRefreshStore is the RefreshStore of the refresh tokens. Until
+// it is set, e.g. by importing auth/token/datastore, it returns
+// ErrNoStore.
+var DefaultRefreshStore RefreshStore = noRefreshStore{}
+
+// noRefreshStore is the DefaultRefreshStore until one is set.
+type noRefreshStore struct{}
+
+func (noRefreshStore) Get(r *http.Request, id string) (*RefreshToken, error) {
+	return nil, ErrNoStore
+}
+
+func (noRefreshStore) Put(r *http.Request, t *RefreshToken) error {
+	return ErrNoStore
+}
+
+func (noRefreshStore) Update(r *http.Request, t *RefreshToken, old []byte) error {
+	return ErrNoStore
+}
+
+func (noRefreshStore) Delete(r *http.Request, id string) error {
+	return ErrNoStore
+}
+
+func hash(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// rotate replaces the secret of t and returns the new refresh token.
+func rotate(t *RefreshToken) string {
+	secret := newID(32)
+	t.Hash = hash(secret)
+	t.LastUsed = time.Now()
+	return refreshPrefix + t.ID + "." + secret
+}
+
+// newRefreshToken starts the refresh tokens of a login.
+func newRefreshToken(r *http.Request, userID, provider string) (string, error) {
+	now := time.Now()
+	t := &RefreshToken{
+		ID:       newID(16),
+		UserID:   userID,
+		Provider: provider,
+		Created:  now,
+		Expires:  now.Add(RefreshTokenExpiration),
+	}
+	rt := rotate(t)
+	if err := DefaultRefreshStore.Put(r, t); err != nil {
+		return "", err
+	}
+	return rt, nil
+}
+
+// parse returns the RefreshToken and secret of raw.
+func parse(r *http.Request, raw string) (*RefreshToken, string, error) {
+	if !strings.HasPrefix(raw, refreshPrefix) {
+		return nil, "", ErrInvalidToken
+	}
+	p := strings.SplitN(raw[len(refreshPrefix):], ".", 2)
+	if len(p) != 2 {
+		return nil, "", ErrInvalidToken
+	}
+	t, err := DefaultRefreshStore.Get(r, p[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return t, p[1], nil
+}
+
+// Refresh exchanges a refresh token for an access token and the next
+// refresh token. If the refresh token was already used, the login is
+// revoked and ErrTokenReused is returned.
+func Refresh(r *http.Request, raw string) (*Tokens, error) {
+	t, secret, err := parse(r, raw)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hash(secret), t.Hash) != 1 {
+		return nil, reused(r, t.ID)
+	}
+	if time.Now().After(t.Expires) {
+		DefaultRefreshStore.Delete(r, t.ID)
+		return nil, jwt.ErrExpired
+	}
+	old := t.Hash
+	rt := rotate(t)
+	if err = DefaultRefreshStore.Update(r, t, old); err == ErrTokenReused {
+		// A concurrent refresh with the same secret won.
+		return nil, reused(r, t.ID)
+	} else if err != nil {
+		return nil, err
+	}
+	tok, err := issue(r, t.UserID, t.Provider)
+	if err != nil {
+		return nil, err
+	}
+	tok.RefreshToken = rt
+	return tok, nil
+}
+
+// reused revokes the login of a reused refresh token.
+func reused(r *http.Request, id string) error {
+	if err := DefaultRefreshStore.Delete(r, id); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// Revoke revokes the login of a refresh token, e.g. when the User logs
+// out of a client. The access tokens stay valid until they expire.
+func Revoke(r *http.Request, raw string) error {
+	t, _, err := parse(r, raw)
+	if err != nil {
+		return err
+	}
+	return DefaultRefreshStore.Delete(r, t.ID)
+}
+
+// MemoryRefreshStore keeps RefreshTokens in memory. It is intended for
+// tests and development servers.
+type MemoryRefreshStore struct {
+	mu     sync.RWMutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshStore creates an empty MemoryRefreshStore.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{tokens: make(map[string]RefreshToken)}
+}
+
+func (s *MemoryRefreshStore) Get(r *http.Request, id string) (*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[tenant.Key(r, id)]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func (s *MemoryRefreshStore) Put(r *http.Request, t *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[tenant.Key(r, t.ID)] = *t
+	return nil
+}
+
+func (s *MemoryRefreshStore) Update(r *http.Request, t *RefreshToken, old []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenant.Key(r, t.ID)
+	cur, ok := s.tokens[key]
+	if !ok {
+		return ErrInvalidToken
+	}
+	if !bytes.Equal(cur.Hash, old) {
+		return ErrTokenReused
+	}
+	s.tokens[key] = *t
+	return nil
+}
+
+func (s *MemoryRefreshStore) Delete(r *http.Request, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, tenant.Key(r, id))
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/token issues the bearer tokens used by single page apps and
+mobile clients instead of, or in addition to, the cookie session.
+
+A login returns a short lived access token, a JWT signed with ES256, and
+an opaque refresh token. The access token is verified with Verify, or by
+other services with the keys published by JWKSHandler. The refresh
+token is exchanged at RefreshHandler for a new access token and a new
+refresh token; presenting a refresh token a second time revokes all of
+the tokens descending from the same login.
+
+  http.HandleFunc("/-/auth/refresh", token.RefreshHandler)
+  http.HandleFunc("/.well-known/jwks.json", token.JWKSHandler)
+
+The signing keys are saved to DefaultKeyStore and rotated every
+RotationInterval.
+*/
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/origin"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("auth/token: invalid token")
+)
+
+var (
+	// Issuer is the "iss" claim of the access tokens. If empty the
+	// public origin of the request is used, e.g. "https://example.com".
+	Issuer string
+	// Audience is the "aud" claim of the access tokens. It is omitted
+	// if empty.
+	Audience string
+	// AccessTokenExpiration is the lifetime of an access token.
+	AccessTokenExpiration = 15 * time.Minute
+)
+
+// Claims are the claims of an access token. The Subject is the User ID.
+type Claims struct {
+	jwt.StandardClaims
+	// Provider is the name of the provider the User logged in with.
+	Provider string `json:"provider,omitempty"`
+	// ClientID and Scope are set on the access tokens that auth/oidc
+	// issues to other apps. Those tokens are only valid for the userinfo
+	// endpoint, not for the APIs of this app.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// TokenUse is "access" on every access token. Verify requires it,
+	// so that other tokens signed with the same keys, e.g. the ID tokens
+	// of auth/oidc, are not accepted as access tokens.
+	TokenUse string `json:"token_use"`
+}
+
+// accessTokenUse is the TokenUse of the access tokens.
+const accessTokenUse = "access"
+
+// Tokens is the response of a login or refresh, as in RFC 6749.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// newID returns n random bytes encoded as base64.
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth/token: unable to generate an ID: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// IssuerURL returns the Issuer, or the public origin of the request, see
+// auth/origin.
+func IssuerURL(r *http.Request) string {
+	if Issuer != "" {
+		return Issuer
+	}
+	return origin.Of(r)
+}
+
+// NewClaims returns the Claims of a new access token for the User.
+func NewClaims(r *http.Request, userID, provider string) *Claims {
+	now := time.Now()
+	cl := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    IssuerURL(r),
+			Subject:   userID,
+			ExpiresAt: now.Add(AccessTokenExpiration).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        newID(12),
+		},
+		Provider: provider,
+		TokenUse: accessTokenUse,
+	}
+	if Audience != "" {
+		cl.Audience = jwt.Audience{Audience}
+	}
+	return cl
+}
+
+// Sign signs claims with the current signing key. The signature is
+// verified with the keys published by JWKSHandler.
+func Sign(r *http.Request, claims interface{}) (string, error) {
+	ks, err := signingKeys(r)
+	if err != nil {
+		return "", err
+	}
+	pk, err := ks[0].PrivateKey()
+	if err != nil {
+		return "", err
+	}
+	return jwt.Sign(claims, ks[0].ID, pk)
+}
+
+// AccessToken returns an access token for the User.
+func AccessToken(r *http.Request, userID, provider string) (string, error) {
+	return Sign(r, NewClaims(r, userID, provider))
+}
+
+// Issue returns an access token and a new refresh token for the User.
+func Issue(r *http.Request, userID, provider string) (*Tokens, error) {
+	rt, err := newRefreshToken(r, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	t, err := issue(r, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	t.RefreshToken = rt
+	return t, nil
+}
+
+// issue returns the Tokens with an access token only.
+func issue(r *http.Request, userID, provider string) (*Tokens, error) {
+	at, err := AccessToken(r, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken: at,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(AccessTokenExpiration / time.Second),
+	}, nil
+}
+
+// Verify checks an access token and returns its Claims.
+func Verify(r *http.Request, raw string) (*Claims, error) {
+	t, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	set, err := KeySet(r)
+	if err != nil {
+		return nil, err
+	}
+	err = set.Verify(t)
+	if err == jwt.ErrKeyNotFound && expireCache(r) {
+		// The key may be newer than the cache, e.g. created by another
+		// instance, so reload the keys once.
+		if set, err = KeySet(r); err != nil {
+			return nil, err
+		}
+		err = set.Verify(t)
+	}
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	cl := new(Claims)
+	if err = t.Claims(cl); err != nil || cl.TokenUse != accessTokenUse {
+		return nil, ErrInvalidToken
+	}
+	if err = cl.Validate(IssuerURL(r), Audience, time.Now()); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorReply is the error response of RefreshHandler, as in RFC 6749.
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+// RefreshHandler exchanges the "refresh_token" of a POST for new Tokens.
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, &errorReply{"invalid_request"})
+		return
+	}
+	t, err := Refresh(r, r.FormValue("refresh_token"))
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, t)
+	case ErrInvalidToken, ErrTokenReused, jwt.ErrExpired:
+		writeJSON(w, http.StatusBadRequest, &errorReply{"invalid_grant"})
+	default:
+		writeJSON(w, http.StatusInternalServerError, &errorReply{"server_error"})
+	}
+}
+
+// JWKSHandler serves the public keys of the access tokens as a JSON Web
+// Key Set.
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	set, err := KeySet(r)
+	if err != nil {
+		http.Error(w, "auth/token: unable to load the signing keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(set)
+}
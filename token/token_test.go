@@ -0,0 +1,238 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"encoding/json"
+	"github.com/gaego/auth/jwt"
+	"github.com/gaego/auth/tenant"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setup() {
+	DefaultKeyStore = NewMemoryKeyStore()
+	DefaultRefreshStore = NewMemoryRefreshStore()
+	resetCache()
+}
+
+func TestIssue(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("POST", "https://example.com/-/auth/google/token", nil)
+
+	tok, err := Issue(r, "1", "Google")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if tok.TokenType != "Bearer" || tok.ExpiresIn != 900 || tok.RefreshToken == "" {
+		t.Errorf(`tok: %v, want a Bearer token for 900s with a refresh token`, tok)
+	}
+	cl, err := Verify(r, tok.AccessToken)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if cl.Subject != "1" || cl.Provider != "Google" || cl.Issuer != "https://example.com" {
+		t.Errorf(`cl: %v, want sub 1 from Google issued by https://example.com`, cl)
+	}
+
+	// Another issuer.
+
+	r2, _ := http.NewRequest("GET", "https://other.example.com/", nil)
+	if _, err = Verify(r2, tok.AccessToken); err != jwt.ErrInvalidIssuer {
+		t.Errorf(`err: %v, want %v`, err, jwt.ErrInvalidIssuer)
+	}
+
+	// Other tokens signed with the same keys, e.g. ID tokens.
+
+	idt, _ := Sign(r, &jwt.StandardClaims{
+		Issuer:    "https://example.com",
+		Subject:   "1",
+		Audience:  jwt.Audience{"client"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	if _, err = Verify(r, idt); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+
+	// Tampered.
+
+	if _, err = Verify(r, tok.AccessToken[:len(tok.AccessToken)-4]+"AAAA"); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("POST", "https://example.com/-/auth/refresh", nil)
+	tok, _ := Issue(r, "1", "Google")
+
+	tok2, err := Refresh(r, tok.RefreshToken)
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if tok2.RefreshToken == tok.RefreshToken {
+		t.Errorf(`RefreshToken: not rotated`)
+	}
+	if cl, _ := Verify(r, tok2.AccessToken); cl == nil || cl.Subject != "1" {
+		t.Errorf(`cl: %v, want sub 1`, cl)
+	}
+
+	// The first token is used again, e.g. by a thief.
+
+	if _, err = Refresh(r, tok.RefreshToken); err != ErrTokenReused {
+		t.Errorf(`err: %v, want %v`, err, ErrTokenReused)
+	}
+	if _, err = Refresh(r, tok2.RefreshToken); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+
+	// Revoke.
+
+	tok, _ = Issue(r, "1", "Google")
+	if err = Revoke(r, tok.RefreshToken); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if _, err = Refresh(r, tok.RefreshToken); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+}
+
+// racingRefreshStore refreshes raw while the first Get of a refresh is
+// in progress, like a concurrent request with the same token.
+type racingRefreshStore struct {
+	*MemoryRefreshStore
+	raw string
+}
+
+func (s *racingRefreshStore) Get(r *http.Request, id string) (*RefreshToken, error) {
+	t, err := s.MemoryRefreshStore.Get(r, id)
+	if raw := s.raw; raw != "" {
+		s.raw = ""
+		Refresh(r, raw)
+	}
+	return t, err
+}
+
+func TestRefreshRace(t *testing.T) {
+	setup()
+	store := &racingRefreshStore{MemoryRefreshStore: NewMemoryRefreshStore()}
+	DefaultRefreshStore = store
+	r, _ := http.NewRequest("POST", "https://example.com/-/auth/refresh", nil)
+	tok, _ := Issue(r, "1", "Google")
+
+	store.raw = tok.RefreshToken
+	if _, err := Refresh(r, tok.RefreshToken); err != ErrTokenReused {
+		t.Errorf(`err: %v, want %v`, err, ErrTokenReused)
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("POST", "https://example.com/-/auth/refresh", nil)
+	tok, _ := Issue(r, "1", "Google")
+
+	post := func(rt string) *httptest.ResponseRecorder {
+		body := url.Values{"refresh_token": {rt}}.Encode()
+		r, _ := http.NewRequest("POST", "https://example.com/-/auth/refresh", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		RefreshHandler(w, r)
+		return w
+	}
+	w := post(tok.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf(`code: %v, want %v`, w.Code, http.StatusOK)
+	}
+	tok2 := new(Tokens)
+	json.Unmarshal(w.Body.Bytes(), tok2)
+	if tok2.AccessToken == "" || tok2.RefreshToken == "" {
+		t.Errorf(`body: %s, want the tokens`, w.Body)
+	}
+	if w = post(tok.RefreshToken); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf(`code: %v, body: %s, want 400 invalid_grant`, w.Code, w.Body)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("GET", "https://example.com/.well-known/jwks.json", nil)
+	old, _ := AccessToken(r, "1", "Google")
+
+	// An expired signing key is replaced, but still published for the
+	// tokens it signed.
+
+	ks, _ := DefaultKeyStore.List(r)
+	ks[0].Created = time.Now().Add(-RotationInterval - time.Minute)
+	DefaultKeyStore.Put(r, ks[0])
+	resetCache()
+	if _, err := AccessToken(r, "1", "Google"); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	w := httptest.NewRecorder()
+	JWKSHandler(w, r)
+	set := new(jwt.KeySet)
+	json.Unmarshal(w.Body.Bytes(), set)
+	if len(set.Keys) != 2 {
+		t.Fatalf(`len(set.Keys): %v, want 2`, len(set.Keys))
+	}
+	if _, err := Verify(r, old); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+
+	// Once its tokens have expired it is deleted.
+
+	ks, _ = DefaultKeyStore.List(r)
+	for _, k := range ks {
+		k.Created = k.Created.Add(-AccessTokenExpiration - jwt.Leeway - time.Minute)
+		DefaultKeyStore.Put(r, k)
+	}
+	resetCache()
+	set, _ = KeySet(r)
+	if len(set.Keys) != 1 {
+		t.Errorf(`len(set.Keys): %v, want 1`, len(set.Keys))
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	acme := tenant.WithTenant(r, &tenant.Tenant{Name: "acme"})
+
+	tok, err := AccessToken(acme, "1", "Google")
+	if err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	if _, err := Verify(acme, tok); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if _, err := Verify(r, tok); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+}
+
+func TestVerifyNewKey(t *testing.T) {
+	setup()
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	KeySet(r)
+
+	// Another instance rotates the key.
+
+	k, _ := NewSigningKey()
+	DefaultKeyStore.Put(r, k)
+	pk, _ := k.PrivateKey()
+	tok, _ := jwt.Sign(NewClaims(r, "1", "Google"), k.ID, pk)
+	if _, err := Verify(r, tok); err != ErrInvalidToken {
+		t.Errorf(`err: %v, want %v`, err, ErrInvalidToken)
+	}
+	cache.namespaces[""].loaded = time.Now().Add(-keyReloadInterval)
+	if _, err := Verify(r, tok); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+}
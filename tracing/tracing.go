@@ -0,0 +1,69 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/tracing provides the OpenTelemetry spans of the login flow.
+
+The spans are created with Tracer, which uses the global TracerProvider,
+so they are exported once the app configures OpenTelemetry:
+
+  otel.SetTracerProvider(sdktrace.NewTracerProvider(...))
+  otel.SetTextMapPropagator(propagation.TraceContext{})
+
+The span of a request is carried by its context. Start returns a copy
+of the request with the new span, which is then the parent of the spans
+created while handling it, including those of the provider's HTTP
+requests made through auth/fetch.
+*/
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracer creates the auth spans.
+var Tracer trace.Tracer = otel.Tracer("github.com/gaego/auth")
+
+// Provider returns the attribute naming the provider of a span.
+func Provider(name string) attribute.KeyValue {
+	return attribute.String("auth.provider", name)
+}
+
+// Start starts a span as a child of the request's span and returns a
+// copy of the request that carries it. The span must be ended with End.
+func Start(r *http.Request, name string, attrs ...attribute.KeyValue) (*http.Request, trace.Span) {
+	ctx, span := Tracer.Start(r.Context(), name, trace.WithAttributes(attrs...))
+	return r.WithContext(ctx), span
+}
+
+// StartSpan starts a span as a child of the request's span for an
+// operation, e.g. a datastore read, that makes no traced calls of its
+// own. r may be nil.
+func StartSpan(r *http.Request, name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	_, span := Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// Kind returns the attribute naming the datastore kind of a span.
+func Kind(kind string) attribute.KeyValue {
+	return attribute.String("datastore.kind", kind)
+}
+
+// End records err, if any, as the status of the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
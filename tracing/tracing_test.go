@@ -0,0 +1,54 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"errors"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	defer func(tr trace.Tracer) { Tracer = tr }(Tracer)
+	Tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)).Tracer("test")
+
+	r, _ := http.NewRequest("GET", "http://localhost:8080/-/auth/google/callback", nil)
+	r, span := Start(r, "auth.handler", Provider("google"))
+	End(StartSpan(r, "datastore.Get", Kind("AuthProfile")), errors.New("timeout"))
+	End(span, nil)
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf(`len(spans): %v, want 2`, len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.Name != "datastore.Get" || parent.Name != "auth.handler" {
+		t.Errorf(`names: %v, %v, want datastore.Get, auth.handler`, child.Name, parent.Name)
+	}
+	if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Errorf(`child.Parent: %v, want %v`, child.Parent.SpanID(), parent.SpanContext.SpanID())
+	}
+	if child.Status.Code != codes.Error || child.Status.Description != "timeout" {
+		t.Errorf(`child.Status: %v, want Error "timeout"`, child.Status)
+	}
+	if parent.Status.Code == codes.Error {
+		t.Errorf(`parent.Status: %v, want not Error`, parent.Status)
+	}
+	if len(parent.Attributes) != 1 || parent.Attributes[0].Value.AsString() != "google" {
+		t.Errorf(`parent.Attributes: %v, want auth.provider=google`, parent.Attributes)
+	}
+
+	// Outside of a request.
+
+	End(StartSpan(nil, "datastore.Put"), nil)
+	if n := len(exp.GetSpans()); n != 3 {
+		t.Errorf(`len(spans): %v, want 3`, n)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/twitter provides Twitter authentication
+*/
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gaego/auth/metrics"
+	"github.com/gaego/auth/oauth1"
+	"github.com/gaego/auth/profile"
+	"github.com/gaego/person"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	VERIFY_CREDENTIALS_URL = "https://api.twitter.com/1.1/account/verify_credentials.json?include_email=true&skip_status=true"
+)
+
+type Provider struct {
+	oauth1.Provider
+}
+
+func New(consumerKey, consumerSecret string) *Provider {
+	return &Provider{
+		Provider: oauth1.Provider{
+			Name:            "Twitter",
+			URL:             "https://twitter.com",
+			ConsumerKey:     consumerKey,
+			ConsumerSecret:  consumerSecret,
+			RequestTokenURL: "https://api.twitter.com/oauth/request_token",
+			AuthorizeURL:    "https://api.twitter.com/oauth/authenticate",
+			AccessTokenURL:  "https://api.twitter.com/oauth/access_token",
+		},
+	}
+}
+
+// User represents the response of verify_credentials.
+type User struct {
+	ID          string `json:"id_str"`
+	Name        string `json:"name"`
+	ScreenName  string `json:"screen_name"`
+	Description string `json:"description"`
+	Email       string `json:"email"`
+	Image       string `json:"profile_image_url_https"`
+}
+
+// Person converts the Twitter user to a Person.
+func (u *User) Person() *person.Person {
+	per := &person.Person{
+		ID:          u.ID,
+		DisplayName: u.Name,
+		Nickname:    u.ScreenName,
+		AboutMe:     u.Description,
+		Email:       u.Email,
+		URL:         "https://twitter.com/" + u.ScreenName,
+	}
+	if u.Email != "" {
+		per.Emails = []*person.PersonEmails{
+			&person.PersonEmails{Primary: true, Type: "account", Value: u.Email},
+		}
+	}
+	if u.Image != "" {
+		per.Image = &person.PersonImage{URL: u.Image}
+	}
+	return per
+}
+
+// Authenticate process the request and returns a populated Profile.
+// If the Authenticate method can not authenticate the User based on the
+// request, an error or a redirect URL wll be return.
+func (p *Provider) Authenticate(w http.ResponseWriter, r *http.Request) (
+	up *profile.Profile, redirectURL string, err error) {
+
+	if !oauth1.IsCallback(r) {
+		redirectURL, err = p.Start(w, r)
+		return nil, redirectURL, err
+	}
+	tok, err := p.Callback(w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	m := metrics.Time(metrics.ProfileFetch, metrics.Provider(r, p.Name))
+	u, body, err := p.verifyCredentials(r, tok)
+	m.Done(err)
+	if err != nil {
+		return nil, "", err
+	}
+	up = profile.New(p.Name, p.URL)
+	up.ID = u.ID
+	up.Person = u.Person()
+	up.PersonRawJSON = body
+	return up, "", nil
+}
+
+// verifyCredentials fetches the User the token belongs to.
+func (p *Provider) verifyCredentials(r *http.Request, tok *oauth1.Token) (*User, []byte, error) {
+	res, err := p.Client(r, tok).Get(VERIFY_CREDENTIALS_URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("auth/twitter: verify_credentials returned %s", res.Status)
+	}
+	u := new(User)
+	if err = json.Unmarshal(body, u); err != nil {
+		return nil, nil, err
+	}
+	return u, body, nil
+}
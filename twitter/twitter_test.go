@@ -0,0 +1,36 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package twitter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUserPerson(t *testing.T) {
+	body := []byte(`{"id":6253282,"id_str":"6253282","name":"Twitter API",
+		"screen_name":"TwitterAPI","description":"The Real Twitter API.",
+		"profile_image_url_https":"https://pbs.twimg.com/profile_images/1.png"}`)
+	u := new(User)
+	if err := json.Unmarshal(body, u); err != nil {
+		t.Fatalf(`err: %v, want nil`, err)
+	}
+	per := u.Person()
+	if x := per.ID; x != "6253282" {
+		t.Errorf(`per.ID: %v, want "6253282"`, x)
+	}
+	if x := per.Nickname; x != "TwitterAPI" {
+		t.Errorf(`per.Nickname: %v, want "TwitterAPI"`, x)
+	}
+	if x := per.URL; x != "https://twitter.com/TwitterAPI" {
+		t.Errorf(`per.URL: %v, want "https://twitter.com/TwitterAPI"`, x)
+	}
+	if x := per.Image.URL; x != "https://pbs.twimg.com/profile_images/1.png" {
+		t.Errorf(`per.Image.URL: %v, want "https://pbs.twimg.com/profile_images/1.png"`, x)
+	}
+	if per.Emails != nil {
+		t.Errorf(`per.Emails: %v, want nil`, per.Emails)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package auth/user provides the User that the Profiles of the auth
+packages belong to. Users are saved by profile.DefaultUserStore, e.g. to
+the App Engine datastore as gaego/user entities by
+auth/profile/datastore.
+*/
+package user
+
+import (
+	"errors"
+	"net/mail"
+)
+
+var (
+	ErrNoLoggedInUser = errors.New("auth/user: no logged in user")
+	ErrAuthIDExists   = errors.New("auth/user: the auth id has already been added")
+	ErrRoleExists     = errors.New("auth/user: the role has already been added")
+	ErrNoSuchRole     = errors.New("auth/user: no such role")
+	ErrInvalidEmail   = errors.New("auth/user: invalid email address")
+)
+
+// User is an account of the app, with a Profile for each of the
+// providers it logs in with.
+type User struct {
+	// ID is set by the UserStore.
+	ID string
+	// AuthIDs are the auth ids of the User's Profiles, e.g.
+	// "google|12345".
+	AuthIDs []string
+	// Email is the first email address added to the User.
+	Email  string
+	Emails []string
+	Roles  []string
+}
+
+// New creates a User without an ID.
+func New() *User {
+	return new(User)
+}
+
+// Copy returns a copy of the User that shares none of its slices.
+func (u *User) Copy() *User {
+	c := *u
+	c.AuthIDs = append([]string(nil), u.AuthIDs...)
+	c.Emails = append([]string(nil), u.Emails...)
+	c.Roles = append([]string(nil), u.Roles...)
+	return &c
+}
+
+// contains reports whether s is in ss.
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAuthID adds the auth id of a Profile, or returns ErrAuthIDExists.
+func (u *User) AddAuthID(id string) error {
+	if contains(u.AuthIDs, id) {
+		return ErrAuthIDExists
+	}
+	u.AuthIDs = append(u.AuthIDs, id)
+	return nil
+}
+
+// HasEmail reports whether the email address was added to the User.
+func (u *User) HasEmail(email string) bool {
+	return contains(u.Emails, email)
+}
+
+// AddEmail adds the email address to the User. It returns false if the
+// User already has it.
+func (u *User) AddEmail(email string) bool {
+	if u.HasEmail(email) {
+		return false
+	}
+	if u.Email == "" {
+		u.Email = email
+	}
+	u.Emails = append(u.Emails, email)
+	return true
+}
+
+// HasRole reports whether the User has the role.
+func (u *User) HasRole(role string) bool {
+	return contains(u.Roles, role)
+}
+
+// AddRole grants the role, or returns ErrRoleExists.
+func (u *User) AddRole(role string) error {
+	if u.HasRole(role) {
+		return ErrRoleExists
+	}
+	u.Roles = append(u.Roles, role)
+	return nil
+}
+
+// RemoveRole revokes the role, or returns ErrNoSuchRole.
+func (u *User) RemoveRole(role string) error {
+	for i, v := range u.Roles {
+		if v == role {
+			u.Roles = append(u.Roles[:i], u.Roles[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoSuchRole
+}
+
+// ValidateEmail returns ErrInvalidEmail unless addr is a bare email
+// address, e.g. "test@example.org".
+func ValidateEmail(addr string) error {
+	a, err := mail.ParseAddress(addr)
+	if err != nil || a.Address != addr || a.Name != "" {
+		return ErrInvalidEmail
+	}
+	return nil
+}
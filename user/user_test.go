@@ -0,0 +1,72 @@
+// Copyright 2012 GAEGo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	u := New()
+	u.AddAuthID("google|12345")
+	u.AddEmail("test@example.org")
+	u.AddRole("admin")
+	c := u.Copy()
+	c.AuthIDs[0] = "google|changed"
+	c.Emails[0] = "changed@example.org"
+	c.Roles[0] = "changed"
+	if u.AuthIDs[0] != "google|12345" || u.Emails[0] != "test@example.org" || u.Roles[0] != "admin" {
+		t.Errorf(`u: %+v, want it unchanged`, u)
+	}
+}
+
+func TestAddEmail(t *testing.T) {
+	u := New()
+	if !u.AddEmail("test@example.org") {
+		t.Errorf(`AddEmail: false, want true`)
+	}
+	if u.AddEmail("test@example.org") {
+		t.Errorf(`AddEmail: true, want false`)
+	}
+	u.AddEmail("other@example.org")
+	if u.Email != "test@example.org" {
+		t.Errorf(`u.Email: %v, want "test@example.org"`, u.Email)
+	}
+	if len(u.Emails) != 2 {
+		t.Errorf(`u.Emails: %v, want 2 addresses`, u.Emails)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	u := New()
+	if err := u.AddRole("admin"); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if err := u.AddRole("admin"); err != ErrRoleExists {
+		t.Errorf(`err: %v, want %v`, err, ErrRoleExists)
+	}
+	if !u.HasRole("admin") {
+		t.Errorf(`HasRole: false, want true`)
+	}
+	if err := u.RemoveRole("admin"); err != nil {
+		t.Errorf(`err: %v, want nil`, err)
+	}
+	if err := u.RemoveRole("admin"); err != ErrNoSuchRole {
+		t.Errorf(`err: %v, want %v`, err, ErrNoSuchRole)
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	for addr, want := range map[string]error{
+		"test@example.org":        nil,
+		"fake":                    ErrInvalidEmail,
+		"Test <test@example.org>": ErrInvalidEmail,
+		" test@example.org":       ErrInvalidEmail,
+	} {
+		if err := ValidateEmail(addr); err != want {
+			t.Errorf(`ValidateEmail(%q): %v, want %v`, addr, err, want)
+		}
+	}
+}